@@ -0,0 +1,372 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// Blue/green colors and what happens to the previous color after the switch
+const (
+	ColorBlue  = "blue"
+	ColorGreen = "green"
+
+	PreviousStop = "stop"
+	PreviousKeep = "keep"
+	PreviousDown = "down"
+)
+
+var previousModes = []string{PreviousStop, PreviousKeep, PreviousDown}
+
+// blueGreenState is the active color of a project on a deploy context
+type blueGreenState struct {
+	Active     string    `json:"active"`
+	Previous   string    `json:"previous,omitempty"`
+	RunID      string    `json:"run_id,omitempty"`
+	SwitchedAt time.Time `json:"switched_at"`
+}
+
+var switchBackProfile string
+
+func init() {
+	SwitchBackCmd.Flags().StringVarP(&switchBackProfile, "profile", "p", "", "Profile deployed with the blue-green strategy (required)")
+}
+
+// SwitchBackCmd switches the traffic back to the previous color of a blue-green deployment
+var SwitchBackCmd = &cobra.Command{
+	Use:   "switch-back",
+	Short: "Switch the traffic back to the previous color of a blue-green deployment",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if switchBackProfile == "" {
+			return inputErrorf("The --profile flag is required.")
+		}
+		p, ok := config.FindProfile(switchBackProfile)
+		if !ok {
+			return configErrorf("Profile '%s' not found in the configuration.", switchBackProfile)
+		}
+		if p.Deploy == nil || p.Deploy.Strategy != StrategyBlueGreen {
+			return configErrorf("Profile '%s' does not use the blue-green strategy.", p.Name)
+		}
+		deployContext, ok := config.FindContext(p.DeployContext)
+		if !ok {
+			return configErrorf("Deploy context '%s' is not registered.", p.DeployContext)
+		}
+
+		f := &flow{
+			ctx:           appCtx,
+			record:        &history.Record{ID: history.NewID(), Profile: p.Name},
+			profile:       *p,
+			deployContext: deployContext,
+			composeFile:   p.ComposeFile,
+		}
+		f.absComposeFile = p.ComposeFile
+		if err := f.loadDeployConfig(); err != nil {
+			return err
+		}
+		return f.switchBack()
+	},
+}
+
+// blueGreenProject returns the base project name of the blue-green deployment
+func (f *flow) blueGreenProject() string {
+	if f.blueGreen.Project != "" {
+		return f.blueGreen.Project
+	}
+	return f.projectName()
+}
+
+// blueGreenDeploy starts the new version as the inactive color, checks its health,
+// switches the traffic to it and then handles the previous color.
+// The active color is left untouched until the switch succeeds.
+func (f *flow) blueGreenDeploy() error {
+	base := f.blueGreenProject()
+	state, err := f.loadBlueGreenState(base)
+	if err != nil {
+		return configErrorf("Unable to read the blue-green state: %w", err)
+	}
+	active := state.Active
+	target := otherColor(active)
+	project := base + "-" + target
+	if len(f.targetServices) > 0 {
+		outln("Blue-green deploys the whole project, the service selection only applies to the build and push.")
+	}
+	if active == "" {
+		outf("==> Blue-green: no active color, deploying %s...\n", project)
+	} else {
+		outf("==> Blue-green: %s-%s is active, deploying %s...\n", base, active, project)
+	}
+	f.record.Project = project
+	if err := f.checkSideBySide(); err != nil {
+		return err
+	}
+
+	// 1) Start the new color next to the active one
+	// Both colors share the project directory (and the files shipped to it)
+	args := []string{"--context", f.deployContext.Name, "compose", "-p", project, "-f", f.newComposePath}
+	args = append(args, projectDirectoryArgs(f.projectDirectory(), f.absComposeFile)...)
+	err = runCommandContext(f.ctx, "docker", append(args, "up", "-d", "--no-build", "--remove-orphans")...)
+	if err != nil {
+		f.projectCommand(project, PreviousDown)
+		return deployErrorf("Error starting %s: %w", project, err)
+	}
+
+	// 2) Health check, the new color is removed if it doesn't get healthy
+	ids, err := f.projectContainers(project)
+	if err == nil && len(ids) == 0 {
+		err = fmt.Errorf("no container is running")
+	}
+	if err == nil {
+		err = f.waitHealthy(project, ids)
+	}
+	if err != nil {
+		outf("==> Removing %s, the active color keeps serving...\n", project)
+		f.projectCommand(project, PreviousDown)
+		return deployErrorf("%s is not healthy: %w", project, err)
+	}
+
+	// 3) Switch the traffic
+	if err := f.switchTraffic(base, target, active); err != nil {
+		outf("==> Removing %s, the active color keeps serving...\n", project)
+		f.projectCommand(project, PreviousDown)
+		return err
+	}
+	return f.afterSwitch(base, target, active)
+}
+
+// checkSideBySide refuses the services that can't run in both colors at the same time
+// (fixed container name or host port). With switch hooks, a reverse proxy is declared
+// and the services are only reported.
+func (f *flow) checkSideBySide() error {
+	var fixed []string
+	for _, svc := range f.allServices {
+		if !svc.IsScalable() {
+			fixed = append(fixed, svc.Name)
+		}
+	}
+	if len(fixed) == 0 {
+		return nil
+	}
+	if len(f.blueGreen.Switch) == 0 {
+		return configErrorf("Blue-green can't start %s next to the active color: fixed container_name or host port. "+
+			"Remove them and declare switch hooks pointing a reverse proxy to the colors, or use another strategy.", strings.Join(fixed, ", "))
+	}
+	outf("Warning: %s use a fixed container_name or host port, the new color fails to start while the active one holds them.\n", strings.Join(fixed, ", "))
+	return nil
+}
+
+// switchBack makes the previous color active again
+func (f *flow) switchBack() error {
+	base := f.blueGreenProject()
+	state, err := f.loadBlueGreenState(base)
+	if err != nil {
+		return configErrorf("Unable to read the blue-green state: %w", err)
+	}
+	if state.Active == "" || state.Previous == "" {
+		return configErrorf("No previous color to switch back to for %s on '%s'.", base, f.deployContext.Name)
+	}
+	previous := base + "-" + state.Previous
+
+	// 1) The previous color must be running (it is only stopped by default)
+	ids, err := f.projectContainers(previous)
+	if err != nil {
+		return contextErrorf("Unable to list the containers of %s: %w", previous, err)
+	}
+	if len(ids) == 0 {
+		outf("==> Starting %s...\n", previous)
+		err = runCommandContext(f.ctx, "docker", "--context", f.deployContext.Name, "compose", "-p", previous, "start")
+		if err == nil {
+			ids, err = f.projectContainers(previous)
+		}
+		if err == nil && len(ids) == 0 {
+			err = errors.New("no container is running (was it removed with previous=down?)")
+		}
+		if err != nil {
+			return deployErrorf("Unable to start %s: %w", previous, err)
+		}
+	}
+	if err := f.waitHealthy(previous, ids); err != nil {
+		return deployErrorf("%s is not healthy: %w", previous, err)
+	}
+
+	// 2) Switch, then handle the color we leave like after a deployment
+	if err := f.switchTraffic(base, state.Previous, state.Active); err != nil {
+		return err
+	}
+	if err := f.afterSwitch(base, state.Previous, state.Active); err != nil {
+		return err
+	}
+	emitResult(map[string]interface{}{"project": previous, "active": state.Previous, "previous": state.Active})
+	return nil
+}
+
+// switchTraffic runs the switch hooks with the colors in their environment
+func (f *flow) switchTraffic(base, target, previous string) error {
+	outf("==> Switching the traffic to %s-%s...\n", base, target)
+	env := append(f.hookEnv("switch"),
+		"XPD_COLOR="+target,
+		"XPD_PROJECT="+base+"-"+target,
+		"XPD_PREVIOUS_COLOR="+previous,
+		"XPD_PREVIOUS_PROJECT="+projectName(base, previous),
+	)
+	if len(f.blueGreen.Switch) == 0 {
+		outln("No switch hook configured, the reverse proxy must pick the new color by itself.")
+	}
+	for i, h := range f.blueGreen.Switch {
+		outf("==> Running switch hook %d/%d...\n", i+1, len(f.blueGreen.Switch))
+		if err := f.runHook(f.ctx, h, env); err != nil && !h.ContinueOnError {
+			return deployErrorf("The switch to %s-%s failed: %w", base, target, err)
+		}
+	}
+	return nil
+}
+
+// afterSwitch records the new active color and stops, keeps or removes the previous one
+func (f *flow) afterSwitch(base, active, previous string) error {
+	state := blueGreenState{Active: active, Previous: previous, RunID: f.record.ID, SwitchedAt: time.Now()}
+	if err := f.saveBlueGreenState(base, state); err != nil {
+		return configErrorf("Unable to save the blue-green state: %w", err)
+	}
+	outf("%s-%s is now active.\n", base, active)
+
+	if previous != "" {
+		f.projectCommand(projectName(base, previous), f.blueGreen.Previous)
+	}
+	return nil
+}
+
+// projectCommand stops or removes a project ("keep" leaves it running).
+// A failure is only reported: the traffic already goes to the right color.
+func (f *flow) projectCommand(project, mode string) {
+	var args []string
+	switch mode {
+	case PreviousKeep:
+		outf("%s keeps running for an instant switch-back.\n", project)
+		return
+	case PreviousDown:
+		outf("==> Removing %s...\n", project)
+		args = []string{"down", "--remove-orphans"}
+	default:
+		outf("==> Stopping %s (switch-back starts it again)...\n", project)
+		args = []string{"stop"}
+	}
+	err := runCommandContext(appCtx, "docker", append([]string{"--context", f.deployContext.Name, "compose", "-p", project}, args...)...)
+	if err != nil {
+		outf("Unable to %s %s: %v\n", args[0], project, err)
+	}
+}
+
+// projectContainers returns the IDs of the running containers of a project
+func (f *flow) projectContainers(project string) ([]string, error) {
+	output, err := commandOutput(f.ctx, "docker", "--context", f.deployContext.Name, "compose", "-p", project, "ps", "-q")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// loadBlueGreenState returns the active color of a project on the deploy context.
+// Without a saved state, the running color (if any) is the active one.
+func (f *flow) loadBlueGreenState(base string) (blueGreenState, error) {
+	states, err := readBlueGreenStates()
+	if err != nil {
+		return blueGreenState{}, err
+	}
+	if state, ok := states[blueGreenKey(f.deployContext.Name, base)]; ok {
+		return state, nil
+	}
+	for _, color := range []string{ColorBlue, ColorGreen} {
+		if ids, err := f.projectContainers(base + "-" + color); err == nil && len(ids) > 0 {
+			return blueGreenState{Active: color}, nil
+		}
+	}
+	return blueGreenState{}, nil
+}
+
+// saveBlueGreenState stores the active color of a project
+func (f *flow) saveBlueGreenState(base string, state blueGreenState) error {
+	states, err := readBlueGreenStates()
+	if err != nil {
+		return err
+	}
+	states[blueGreenKey(f.deployContext.Name, base)] = state
+	path, err := blueGreenStatePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readBlueGreenStates reads ~/.xpdemon-deploy/bluegreen.json ("context/project" => state)
+func readBlueGreenStates() (map[string]blueGreenState, error) {
+	states := make(map[string]blueGreenState)
+	path, err := blueGreenStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return states, nil
+}
+
+func blueGreenStatePath() (string, error) {
+	dir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bluegreen.json"), nil
+}
+
+func blueGreenKey(contextName, base string) string {
+	return contextName + "/" + base
+}
+
+// otherColor returns the color to deploy when color is active
+func otherColor(color string) string {
+	if color == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+// projectName returns the project of a color ("" when there is no color)
+func projectName(base, color string) string {
+	if color == "" {
+		return ""
+	}
+	return base + "-" + color
+}
+
+// validateBlueGreen checks the blue-green configuration
+func validateBlueGreen(bg *config.BlueGreenConfig) error {
+	if bg.Previous != "" && !slices.Contains(previousModes, bg.Previous) {
+		return fmt.Errorf("invalid previous mode '%s' (expected one of %s)", bg.Previous, strings.Join(previousModes, ", "))
+	}
+	for _, h := range bg.Switch {
+		if (h.Command == "") == (h.Service == "") {
+			return errors.New("switch hook: exactly one of command or service is required")
+		}
+	}
+	return nil
+}
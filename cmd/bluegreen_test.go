@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+func TestBlueGreenState(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	// Only the green color of shop is running on prod
+	fakeDocker(t, `case "$*" in *"-p shop-green ps -q"*) echo abc123 ;; esac`)
+	f := &flow{ctx: context.Background(), deployContext: config.DockerContext{Name: "prod"}}
+
+	// 1) Without a saved state, the running color is the active one
+	if state, err := f.loadBlueGreenState("shop"); err != nil || state.Active != ColorGreen {
+		t.Errorf("loadBlueGreenState = %+v, %v, want green", state, err)
+	}
+	if state, err := f.loadBlueGreenState("blog"); err != nil || state.Active != "" {
+		t.Errorf("loadBlueGreenState without color = %+v, %v", state, err)
+	}
+
+	// 2) The saved state wins, per deploy context and project
+	if err := f.saveBlueGreenState("shop", blueGreenState{Active: ColorBlue, Previous: ColorGreen, RunID: "run-1"}); err != nil {
+		t.Fatalf("saveBlueGreenState: %v", err)
+	}
+	if err := f.saveBlueGreenState("blog", blueGreenState{Active: ColorGreen}); err != nil {
+		t.Fatalf("saveBlueGreenState: %v", err)
+	}
+	state, err := f.loadBlueGreenState("shop")
+	if err != nil || state.Active != ColorBlue || state.Previous != ColorGreen || state.RunID != "run-1" {
+		t.Errorf("loadBlueGreenState = %+v, %v", state, err)
+	}
+	staging := &flow{ctx: context.Background(), deployContext: config.DockerContext{Name: "staging"}}
+	if state, err := staging.loadBlueGreenState("blog"); err != nil || state.Active != "" {
+		t.Errorf("state of another context = %+v, %v", state, err)
+	}
+
+	// 3) A corrupted state file is reported
+	path, err := blueGreenStatePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.loadBlueGreenState("shop"); err == nil || !strings.Contains(err.Error(), "bluegreen.json") {
+		t.Errorf("loadBlueGreenState with a corrupted file = %v", err)
+	}
+}
+
+func TestColors(t *testing.T) {
+	for active, want := range map[string]string{"": ColorBlue, ColorBlue: ColorGreen, ColorGreen: ColorBlue} {
+		if got := otherColor(active); got != want {
+			t.Errorf("otherColor(%q) = %q, want %q", active, got, want)
+		}
+	}
+	if got := projectName("shop", ColorBlue); got != "shop-blue" {
+		t.Errorf("projectName = %q", got)
+	}
+	if got := projectName("shop", ""); got != "" {
+		t.Errorf("projectName without color = %q", got)
+	}
+}
+
+func TestValidateBlueGreen(t *testing.T) {
+	tests := []struct {
+		bg      config.BlueGreenConfig
+		wantErr string
+	}{
+		{bg: config.BlueGreenConfig{}},
+		{bg: config.BlueGreenConfig{Previous: PreviousKeep, Switch: []config.Hook{{Command: "./switch.sh"}}}},
+		{bg: config.BlueGreenConfig{Previous: "pause"}, wantErr: "invalid previous mode 'pause'"},
+		{bg: config.BlueGreenConfig{Switch: []config.Hook{{}}}, wantErr: "exactly one of command or service"},
+	}
+	for _, tt := range tests {
+		err := validateBlueGreen(&tt.bg)
+		if (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("validateBlueGreen(%+v) = %v, want %q", tt.bg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckSideBySide(t *testing.T) {
+	discardStdout(t)
+	f := &flow{allServices: []compose.Service{{Name: "api"}, {Name: "proxy", PublishedPorts: []string{"80"}}}}
+	if err := f.checkSideBySide(); err == nil || !strings.Contains(err.Error(), "can't start proxy") {
+		t.Errorf("checkSideBySide with a fixed port = %v", err)
+	}
+	// A reverse proxy switched by the hooks takes the fixed port
+	f.blueGreen.Switch = []config.Hook{{Command: "./switch.sh"}}
+	if err := f.checkSideBySide(); err != nil {
+		t.Errorf("checkSideBySide with switch hooks = %v", err)
+	}
+	f = &flow{allServices: []compose.Service{{Name: "api"}}}
+	if err := f.checkSideBySide(); err != nil {
+		t.Errorf("checkSideBySide = %v", err)
+	}
+}
+
+func TestSwitchBack(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	fakeDocker(t, `echo "$*" >> `+shellQuote(filepath.Join(dir, "docker"))+`
+case "$*" in
+*"-p shop-blue ps -q"*) echo blue1 ;;
+*" inspect "*) echo "blue1 running" ;;
+esac`)
+	f := &flow{
+		ctx:           context.Background(),
+		record:        &history.Record{ID: "run-2"},
+		deployContext: config.DockerContext{Name: "prod"},
+		blueGreen: config.BlueGreenConfig{
+			Project:  "shop",
+			Previous: PreviousStop,
+			Switch:   []config.Hook{{Command: `echo "$XPD_COLOR $XPD_PROJECT $XPD_PREVIOUS_PROJECT" > ` + shellQuote(filepath.Join(dir, "switch"))}},
+		},
+		healthTimeout: defaultHealthTimeout,
+	}
+
+	if err := f.switchBack(); err == nil || !strings.Contains(err.Error(), "No previous color") {
+		t.Errorf("switchBack without a previous color = %v", err)
+	}
+
+	if err := f.saveBlueGreenState("shop", blueGreenState{Active: ColorGreen, Previous: ColorBlue, RunID: "run-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.switchBack(); err != nil {
+		t.Fatalf("switchBack: %v", err)
+	}
+	checkFile(t, dir, "switch", "blue shop-blue shop-green\n")
+	state, err := f.loadBlueGreenState("shop")
+	if err != nil || state.Active != ColorBlue || state.Previous != ColorGreen || state.RunID != "run-2" {
+		t.Errorf("state after the switch-back = %+v, %v", state, err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "docker"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); lines[len(lines)-1] != "--context prod compose -p shop-green stop" {
+		t.Errorf("the previous color was not stopped: %q", lines)
+	}
+}
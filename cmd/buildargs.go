@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xpdemon/ac-deploy/compose"
+)
+
+// buildArg is a build argument, for every service when Service is empty
+type buildArg struct {
+	Service string
+	Key     string
+	Value   string
+}
+
+// buildSecret is a BuildKit secret read from a file or from an environment variable.
+// Only the source is written in the generated compose, never the value.
+type buildSecret struct {
+	Service string
+	ID      string
+	File    string
+	Env     string
+}
+
+// loadBuildInputs collects the build arguments and secrets of the profile and of the flags
+// (the flags come last, so they override the profile)
+func (f *flow) loadBuildInputs() error {
+	argFiles := append(append([]string{}, f.profile.BuildArgFiles...), runFlowBuildArgFiles...)
+	args := append(append([]string{}, f.profile.BuildArgs...), runFlowBuildArgs...)
+	secretEnvFiles := append(append([]string{}, f.profile.SecretEnvFiles...), runFlowSecretEnvFiles...)
+	secrets := append(append([]string{}, f.profile.BuildSecrets...), runFlowBuildSecrets...)
+
+	// 1) Build arguments: env files first, then KEY=VALUE entries
+	f.buildArgs = nil
+	for _, path := range argFiles {
+		values, err := compose.ParseEnvFile(expandHome(path))
+		if err != nil {
+			return inputErrorf("Error reading build arg file: %w", err)
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f.buildArgs = append(f.buildArgs, buildArg{Key: k, Value: values[k]})
+		}
+	}
+	for _, raw := range args {
+		arg, err := parseBuildArg(raw)
+		if err != nil {
+			return inputErrorf("Invalid build arg: %w", err)
+		}
+		f.buildArgs = append(f.buildArgs, arg)
+	}
+
+	// 2) Secret env files are loaded in the environment of the docker commands
+	for _, path := range secretEnvFiles {
+		values, err := compose.ParseEnvFile(expandHome(path))
+		if err != nil {
+			return inputErrorf("Error reading secret env file: %w", err)
+		}
+		for k, v := range values {
+			os.Setenv(k, v)
+		}
+	}
+
+	// 3) Secrets: check that every source exists
+	f.buildSecrets = nil
+	for _, raw := range secrets {
+		secret, err := parseBuildSecret(raw)
+		if err != nil {
+			return inputErrorf("Invalid build secret: %w", err)
+		}
+		if secret.File != "" {
+			if _, err := os.Stat(secret.File); err != nil {
+				return inputErrorf("Build secret '%s': %w", secret.ID, err)
+			}
+		} else if _, ok := os.LookupEnv(secret.Env); !ok {
+			return inputErrorf("Build secret '%s': environment variable %s is not set", secret.ID, secret.Env)
+		}
+		f.buildSecrets = append(f.buildSecrets, secret)
+	}
+
+	if len(f.buildArgs) > 0 || len(f.buildSecrets) > 0 {
+		outf("Build inputs: %d build arg(s), %d secret(s)\n", len(f.buildArgs), len(f.buildSecrets))
+	}
+	return nil
+}
+
+// parseBuildArg parses "KEY=VALUE" or "service:KEY=VALUE".
+// "KEY" alone takes the value from the environment, as docker build does.
+func parseBuildArg(raw string) (buildArg, error) {
+	left, value, hasValue := strings.Cut(raw, "=")
+	service, key := splitServicePrefix(left)
+	if key == "" {
+		return buildArg{}, fmt.Errorf("'%s': expected [service:]KEY=VALUE", raw)
+	}
+	if !hasValue {
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			return buildArg{}, fmt.Errorf("'%s': no value and %s is not set in the environment", raw, key)
+		}
+		value = v
+	}
+	return buildArg{Service: service, Key: key, Value: value}, nil
+}
+
+// parseBuildSecret parses "[service:]id=NAME,src=PATH" or "[service:]id=NAME,env=VAR"
+func parseBuildSecret(raw string) (buildSecret, error) {
+	var secret buildSecret
+	spec := raw
+	// The service prefix ends before the first "=" of "id="
+	if i := strings.Index(raw, ":"); i >= 0 && !strings.Contains(raw[:i], "=") {
+		secret.Service, spec = raw[:i], raw[i+1:]
+	}
+
+	for _, field := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			return buildSecret{}, fmt.Errorf("'%s': invalid field '%s'", raw, field)
+		}
+		switch key {
+		case "id":
+			secret.ID = value
+		case "src", "source":
+			secret.File = expandHome(value)
+		case "env":
+			secret.Env = value
+		default:
+			return buildSecret{}, fmt.Errorf("'%s': unknown field '%s'", raw, key)
+		}
+	}
+
+	if secret.ID == "" {
+		return buildSecret{}, fmt.Errorf("'%s': the id is required", raw)
+	}
+	if (secret.File == "") == (secret.Env == "") {
+		return buildSecret{}, fmt.Errorf("'%s': exactly one of src or env is required", raw)
+	}
+	if secret.File != "" {
+		abs, err := filepath.Abs(secret.File)
+		if err != nil {
+			return buildSecret{}, err
+		}
+		secret.File = abs
+	}
+	return secret, nil
+}
+
+// splitServicePrefix splits "service:rest" into its parts (service is empty without prefix)
+func splitServicePrefix(s string) (string, string) {
+	if service, rest, ok := strings.Cut(s, ":"); ok {
+		return service, rest
+	}
+	return "", s
+}
+
+// buildArgsFor returns the build arguments of a service (service-specific ones override the global ones)
+func buildArgsFor(args []buildArg, svcName string) map[string]string {
+	result := make(map[string]string)
+	for _, a := range args {
+		if a.Service == "" {
+			result[a.Key] = a.Value
+		}
+	}
+	for _, a := range args {
+		if a.Service == svcName {
+			result[a.Key] = a.Value
+		}
+	}
+	return result
+}
+
+// buildArgsHash returns a stable hash of the build arguments of a service ("" if it has none),
+// used to detect a change of arguments when the build context is unchanged
+func buildArgsHash(args []buildArg, svcName string) string {
+	values := buildArgsFor(args, svcName)
+	if len(values) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\x00", k, values[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// injectBuildInputs adds the build arguments and secrets to the compose file.
+// Secrets are declared with their source (file or environment variable) only.
+func injectBuildInputs(raw map[string]interface{}, args []buildArg, secrets []buildSecret) error {
+	servicesRaw, _ := raw["services"].(map[string]interface{})
+
+	// 1) Top-level secret definitions
+	if len(secrets) > 0 {
+		topLevel, ok := raw["secrets"].(map[string]interface{})
+		if !ok {
+			if raw["secrets"] != nil {
+				return errors.New("the top-level 'secrets' section is malformed")
+			}
+			topLevel = make(map[string]interface{})
+		}
+		added := make(map[string]bool)
+		for _, s := range secrets {
+			if added[s.ID] {
+				continue // same secret given for several services
+			}
+			if _, exists := topLevel[s.ID]; exists {
+				return fmt.Errorf("secret '%s' is already defined in the docker-compose", s.ID)
+			}
+			added[s.ID] = true
+			if s.File != "" {
+				topLevel[s.ID] = map[string]interface{}{"file": s.File}
+			} else {
+				topLevel[s.ID] = map[string]interface{}{"environment": s.Env}
+			}
+		}
+		raw["secrets"] = topLevel
+	}
+
+	// 2) Per-service build args and build secrets
+	for svcName, svcVal := range servicesRaw {
+		svcMap, ok := svcVal.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		build := buildSection(svcMap)
+		if build == nil {
+			continue
+		}
+
+		if values := buildArgsFor(args, svcName); len(values) > 0 {
+			merged := make(map[string]interface{})
+			switch existing := build["args"].(type) {
+			case map[string]interface{}:
+				for k, v := range existing {
+					merged[k] = v
+				}
+			case []interface{}:
+				// List syntax: ["KEY=VALUE", "KEY"]
+				for _, item := range existing {
+					if str, ok := item.(string); ok {
+						if k, v, ok := strings.Cut(str, "="); ok {
+							merged[k] = v
+						} else {
+							merged[str] = nil // value taken from the environment
+						}
+					}
+				}
+			}
+			for k, v := range values {
+				merged[k] = v
+			}
+			build["args"] = merged
+		}
+
+		for _, s := range secrets {
+			if s.Service != "" && s.Service != svcName {
+				continue
+			}
+			list, _ := build["secrets"].([]interface{})
+			build["secrets"] = append(list, s.ID)
+		}
+	}
+	return nil
+}
+
+// expandHome replaces a leading "~/" with the home directory
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[2:])
+		}
+	}
+	return path
+}
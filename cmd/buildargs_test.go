@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"maps"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBuildArg(t *testing.T) {
+	t.Setenv("FROM_ENV", "env value")
+	tests := []struct {
+		raw     string
+		want    buildArg
+		wantErr bool
+	}{
+		{raw: "VERSION=1.2", want: buildArg{Key: "VERSION", Value: "1.2"}},
+		{raw: "api:VERSION=1.2", want: buildArg{Service: "api", Key: "VERSION", Value: "1.2"}},
+		{raw: "URL=http://host:80/a=b", want: buildArg{Key: "URL", Value: "http://host:80/a=b"}},
+		{raw: "EMPTY=", want: buildArg{Key: "EMPTY"}},
+		{raw: "FROM_ENV", want: buildArg{Key: "FROM_ENV", Value: "env value"}},
+		{raw: "api:FROM_ENV", want: buildArg{Service: "api", Key: "FROM_ENV", Value: "env value"}},
+		{raw: "NOT_SET_ANYWHERE", wantErr: true},
+		{raw: "=value", wantErr: true},
+		{raw: "api:=value", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBuildArg(tt.raw)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBuildArg(%q) = %+v, %v, want %+v (error: %v)", tt.raw, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseBuildSecret(t *testing.T) {
+	abs, err := filepath.Abs("npmrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		raw     string
+		want    buildSecret
+		wantErr string
+	}{
+		{raw: "id=npm,src=npmrc", want: buildSecret{ID: "npm", File: abs}},
+		{raw: "id=npm, source=/etc/npmrc", want: buildSecret{ID: "npm", File: "/etc/npmrc"}},
+		{raw: "api:id=token,env=API_TOKEN", want: buildSecret{Service: "api", ID: "token", Env: "API_TOKEN"}},
+		{raw: "id=a:b,env=X", want: buildSecret{ID: "a:b", Env: "X"}},
+		{raw: "src=npmrc", wantErr: "the id is required"},
+		{raw: "id=npm", wantErr: "exactly one of src or env"},
+		{raw: "id=npm,src=npmrc,env=X", wantErr: "exactly one of src or env"},
+		{raw: "id=npm,mode=0400", wantErr: "unknown field 'mode'"},
+		{raw: "id=npm,npmrc", wantErr: "invalid field"},
+	}
+	for _, tt := range tests {
+		got, err := parseBuildSecret(tt.raw)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseBuildSecret(%q) error = %v, want %q", tt.raw, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseBuildSecret(%q) = %+v, %v, want %+v", tt.raw, got, err, tt.want)
+		}
+	}
+}
+
+func TestBuildArgsFor(t *testing.T) {
+	args := []buildArg{
+		{Service: "api", Key: "MODE", Value: "api"},
+		{Key: "MODE", Value: "all"},
+		{Key: "VERSION", Value: "1"},
+		{Service: "web", Key: "THEME", Value: "dark"},
+	}
+	if got, want := buildArgsFor(args, "api"), map[string]string{"MODE": "api", "VERSION": "1"}; !maps.Equal(got, want) {
+		t.Errorf("buildArgsFor(api) = %v, want %v", got, want)
+	}
+	if got, want := buildArgsFor(args, "db"), map[string]string{"MODE": "all", "VERSION": "1"}; !maps.Equal(got, want) {
+		t.Errorf("buildArgsFor(db) = %v, want %v", got, want)
+	}
+
+	// The hash only depends on the values of the service, not on their order
+	reordered := []buildArg{args[2], args[3], args[1], args[0]}
+	if buildArgsHash(args, "api") != buildArgsHash(reordered, "api") {
+		t.Error("buildArgsHash depends on the order of the arguments")
+	}
+	if buildArgsHash(args, "api") == buildArgsHash(args, "db") {
+		t.Error("buildArgsHash is the same for different values")
+	}
+	if got := buildArgsHash(nil, "api"); got != "" {
+		t.Errorf("buildArgsHash without arguments = %q, want \"\"", got)
+	}
+}
+
+func TestInjectBuildInputs(t *testing.T) {
+	raw := map[string]interface{}{
+		"services": map[string]interface{}{
+			"api": map[string]interface{}{"build": "./api"},
+			"web": map[string]interface{}{"build": map[string]interface{}{
+				"context": "./web",
+				"args":    []interface{}{"KEEP=1", "FROM_ENV", "VERSION=0"},
+			}},
+			"db": map[string]interface{}{"image": "postgres:16"},
+		},
+	}
+	args := []buildArg{{Key: "VERSION", Value: "2"}, {Service: "api", Key: "MODE", Value: "api"}}
+	secrets := []buildSecret{
+		{ID: "npm", File: "/home/me/.npmrc"},
+		{Service: "api", ID: "token", Env: "API_TOKEN"},
+	}
+	if err := injectBuildInputs(raw, args, secrets); err != nil {
+		t.Fatalf("injectBuildInputs: %v", err)
+	}
+
+	services := raw["services"].(map[string]interface{})
+	api := services["api"].(map[string]interface{})["build"].(map[string]interface{})
+	web := services["web"].(map[string]interface{})["build"].(map[string]interface{})
+	if got, want := api["args"], map[string]interface{}{"VERSION": "2", "MODE": "api"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("api args = %v, want %v", got, want)
+	}
+	if got, want := web["args"], map[string]interface{}{"KEEP": "1", "FROM_ENV": nil, "VERSION": "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("web args = %v, want %v", got, want)
+	}
+	if got, want := api["secrets"], []interface{}{"npm", "token"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("api secrets = %v, want %v", got, want)
+	}
+	if got, want := web["secrets"], []interface{}{"npm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("web secrets = %v, want %v", got, want)
+	}
+	if _, ok := services["db"].(map[string]interface{})["build"]; ok {
+		t.Error("db got a build section")
+	}
+	want := map[string]interface{}{
+		"npm":   map[string]interface{}{"file": "/home/me/.npmrc"},
+		"token": map[string]interface{}{"environment": "API_TOKEN"},
+	}
+	if got := raw["secrets"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("top-level secrets = %v, want %v", got, want)
+	}
+
+	// A secret of the compose file is never overwritten
+	raw = map[string]interface{}{
+		"services": map[string]interface{}{},
+		"secrets":  map[string]interface{}{"npm": map[string]interface{}{"file": "./npmrc"}},
+	}
+	if err := injectBuildInputs(raw, nil, secrets); err == nil || !strings.Contains(err.Error(), "already defined") {
+		t.Errorf("injectBuildInputs with a duplicate secret = %v", err)
+	}
+}
+
+func TestHashSalts(t *testing.T) {
+	f := &flow{
+		selectedServices: testServices(),
+		buildArgs:        []buildArg{{Service: "api", Key: "MODE", Value: "api"}},
+	}
+	before := f.hashSalts()
+	f.buildArgs[0].Value = "other"
+	after := f.hashSalts()
+	for name := range before {
+		if changed := before[name] != after[name]; changed != (name == "api") {
+			t.Errorf("salt of %s changed = %v when the build args of api changed", name, changed)
+		}
+	}
+}
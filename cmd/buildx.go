@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// buildxBuild builds (and pushes) multi-platform images with docker buildx bake.
+// Multi-arch images can't be loaded in a local engine, so they are pushed directly.
+func (f *flow) buildxBuild() error {
+	if f.registry == "" {
+		return inputErrorf("Buildx mode pushes the images while building: select a registry.")
+	}
+
+	targets := f.bakeTargets()
+	if targets != nil && len(targets) == 0 {
+		outln("No selected service has a build section, nothing to build.")
+		return nil
+	}
+
+	// 1) Resolve the platforms (configured or derived from the deploy context)
+	platforms := f.buildx.Platforms
+	if len(platforms) == 0 {
+		platform, err := contextPlatform(f.ctx, f.retry, f.deployContext.Name)
+		if err != nil {
+			return contextErrorf("Unable to detect the platform of the deploy context: %w", err)
+		}
+		outf("Platform of the deploy context '%s': %s\n", f.deployContext.Name, platform)
+		platforms = []string{platform}
+	}
+
+	// 2) Create or update the builder (build context + additional nodes)
+	builder, err := ensureBuildxBuilder(f.ctx, f.buildContext.Name, f.buildx.Nodes)
+	if err != nil {
+		return buildErrorf("Error preparing the buildx builder: %w", err)
+	}
+
+	// 3) Build and push
+	outf("==> Building and pushing images for %s with buildx...\n", strings.Join(platforms, ","))
+	args := []string{
+		"--context", f.buildContext.Name,
+		"buildx", "bake",
+		"--builder", builder,
+		"-f", f.newComposePath,
+		"--set", "*.platform=" + strings.Join(platforms, ","),
+		"--push",
+	}
+	err = f.runProgress("build", f.progressServices(), false, append(args, targets...)...)
+	if err != nil {
+		f.cleanupGenerated() // Optional cleanup
+		return buildErrorf("Error during buildx build: %w", err)
+	}
+	return nil
+}
+
+// bakeTargets returns the services to pass to buildx bake.
+// Bake only knows the services with a build section, nil means all of them.
+func (f *flow) bakeTargets() []string {
+	if f.buildServices == nil {
+		return nil
+	}
+	wanted := make(map[string]bool)
+	for _, name := range f.buildServices {
+		wanted[name] = true
+	}
+	targets := []string{}
+	for _, svc := range f.allServices {
+		if wanted[svc.Name] && svc.HasBuild() {
+			targets = append(targets, svc.Name)
+		}
+	}
+	return targets
+}
+
+// ensureBuildxBuilder creates the builder "xpdemon-<context>" on the build context if needed,
+// and appends (or updates) one node per additional context.
+// A node is either "context" or "context=platform1,platform2".
+func ensureBuildxBuilder(ctx context.Context, buildContext string, nodes []string) (string, error) {
+	name := buildxBuilderName(buildContext)
+
+	if commandContext(ctx, "docker", "buildx", "inspect", name).Run() != nil {
+		outf("==> Creating buildx builder '%s'...\n", name)
+		err := runCommandContext(ctx, "docker", "buildx", "create",
+			"--name", name,
+			"--node", name+"-"+sanitizeName(buildContext),
+			"--driver", "docker-container",
+			buildContext,
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, node := range nodes {
+		ctxName, platforms, _ := strings.Cut(node, "=")
+		args := []string{"buildx", "create",
+			"--name", name,
+			"--append",
+			"--node", name + "-" + sanitizeName(ctxName),
+		}
+		if platforms != "" {
+			args = append(args, "--platform", platforms)
+		}
+		if err := runCommandContext(ctx, "docker", append(args, ctxName)...); err != nil {
+			return "", fmt.Errorf("unable to add node '%s': %w", ctxName, err)
+		}
+	}
+
+	if err := runCommandContext(ctx, "docker", "buildx", "inspect", "--bootstrap", name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// buildxBuilderName returns the name of the builder created on a build context
+func buildxBuilderName(buildContext string) string {
+	return "xpdemon-" + sanitizeName(buildContext)
+}
+
+// contextPlatform returns the platform (e.g., linux/arm64) of the engine behind a Docker context
+func contextPlatform(ctx context.Context, policy retryPolicy, contextName string) (string, error) {
+	out, err := runWithRetry(ctx, policy, contextProbeTimeout, func(ctx context.Context) *exec.Cmd {
+		return commandContext(ctx, "docker", "--context", contextName, "info", "--format", "{{.OSType}}|{{.Architecture}}")
+	})
+	if err != nil {
+		return "", fmt.Errorf("docker info failed: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	osType, arch, ok := strings.Cut(lines[len(lines)-1], "|")
+	if !ok {
+		return "", fmt.Errorf("unexpected docker info output: %s", out)
+	}
+
+	// Map the kernel architecture names to the OCI ones
+	switch arch {
+	case "x86_64", "amd64":
+		arch = "amd64"
+	case "aarch64", "arm64":
+		arch = "arm64"
+	case "armv7l", "armhf":
+		arch = "arm/v7"
+	case "armv6l":
+		arch = "arm/v6"
+	case "i386", "i686":
+		arch = "386"
+	}
+	return osType + "/" + arch, nil
+}
+
+// sanitizeName turns a context name into a valid builder/node name
+func sanitizeName(name string) string {
+	return regexp.MustCompile(`[^a-zA-Z0-9_-]`).ReplaceAllString(name, "-")
+}
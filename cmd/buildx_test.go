@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// fakeDocker puts a docker command running the shell script first in the PATH until the end of the test
+func fakeDocker(t *testing.T, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestBakeTargets(t *testing.T) {
+	all := []compose.Service{
+		{Name: "api", BuildContext: "/app/api"},
+		{Name: "db", Image: "postgres:16"},
+		{Name: "web", BuildContext: "/app/web"},
+	}
+	tests := []struct {
+		name          string
+		buildServices []string
+		want          []string
+	}{
+		{"every service", nil, nil},
+		{"only the built ones", []string{"web", "db", "api"}, []string{"api", "web"}},
+		{"nothing to build", []string{"db"}, []string{}},
+	}
+	for _, tt := range tests {
+		f := &flow{allServices: all, buildServices: tt.buildServices}
+		got := f.bakeTargets()
+		if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: bakeTargets = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBuildxBuilderName(t *testing.T) {
+	if got := buildxBuilderName("my ctx/prod.1"); got != "xpdemon-my-ctx-prod-1" {
+		t.Errorf("buildxBuilderName = %q", got)
+	}
+}
+
+func TestContextPlatform(t *testing.T) {
+	discardStdout(t)
+	tests := []struct {
+		info string
+		want string
+	}{
+		{"linux|x86_64", "linux/amd64"},
+		{"linux|aarch64", "linux/arm64"},
+		{"linux|armv7l", "linux/arm/v7"},
+		{"linux|armv6l", "linux/arm/v6"},
+		{"linux|i686", "linux/386"},
+		{"linux|riscv64", "linux/riscv64"},
+		{"WARNING: No swap limit support\nlinux|aarch64", "linux/arm64"},
+	}
+	policy := retryPolicy{attempts: 1, delay: time.Millisecond, maxDelay: time.Millisecond}
+	for _, tt := range tests {
+		fakeDocker(t, "printf '%s\\n' "+shellQuote(tt.info))
+		got, err := contextPlatform(context.Background(), policy, "rpi")
+		if err != nil || got != tt.want {
+			t.Errorf("contextPlatform(%q) = %q, %v, want %q", tt.info, got, err, tt.want)
+		}
+	}
+
+	fakeDocker(t, "echo unexpected")
+	if _, err := contextPlatform(context.Background(), policy, "rpi"); err == nil {
+		t.Errorf("contextPlatform of an unexpected output succeeded")
+	}
+}
+
+func TestLoadProfileBuildx(t *testing.T) {
+	discardStdout(t)
+	setTestConfig(t, config.AppConfig{})
+	tests := []struct {
+		name      string
+		profile   *config.BuildxConfig
+		flag      bool
+		platforms []string
+		want      *config.BuildxConfig
+	}{
+		{"disabled", nil, false, nil, nil},
+		{"from the profile", &config.BuildxConfig{Platforms: []string{"linux/arm64"}}, false, nil, &config.BuildxConfig{Platforms: []string{"linux/arm64"}}},
+		{"enabled by the flag", nil, true, nil, &config.BuildxConfig{}},
+		{"platforms flag wins", &config.BuildxConfig{Platforms: []string{"linux/arm64"}, Nodes: []string{"rpi"}}, false, []string{"linux/amd64"},
+			&config.BuildxConfig{Platforms: []string{"linux/amd64"}, Nodes: []string{"rpi"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedBuildx, savedPlatforms := runFlowBuildx, runFlowPlatforms
+			runFlowBuildx, runFlowPlatforms = tt.flag, tt.platforms
+			defer func() { runFlowBuildx, runFlowPlatforms = savedBuildx, savedPlatforms }()
+
+			f := &flow{record: &history.Record{}, profile: config.Profile{Buildx: tt.profile}}
+			if err := f.loadProfile(); err != nil {
+				t.Fatalf("loadProfile: %v", err)
+			}
+			switch {
+			case tt.want == nil && f.buildx != nil:
+				t.Errorf("buildx = %+v, want disabled", f.buildx)
+			case tt.want != nil && (f.buildx == nil || !slices.Equal(f.buildx.Platforms, tt.want.Platforms) || !slices.Equal(f.buildx.Nodes, tt.want.Nodes)):
+				t.Errorf("buildx = %+v, want %+v", f.buildx, tt.want)
+			}
+			// The profile itself is not modified by the flags
+			if tt.profile != nil && len(tt.platforms) > 0 && slices.Equal(tt.profile.Platforms, tt.platforms) {
+				t.Errorf("the flags modified the profile")
+			}
+		})
+	}
+}
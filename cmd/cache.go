@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// pruneBuilderCache removes the builder cache of the build context.
+// With buildx, the cache of the xpdemon builder is pruned instead of the default one.
+func (f *flow) pruneBuilderCache(until, keepStorage string) error {
+	var args []string
+	if f.buildx != nil {
+		args = []string{"buildx", "prune", "--builder", buildxBuilderName(f.buildContext.Name), "-f"}
+	} else {
+		args = []string{"--context", f.buildContext.Name, "builder", "prune", "-f"}
+	}
+	if until != "" {
+		args = append(args, "--filter", "until="+until)
+	}
+	if keepStorage != "" {
+		args = append(args, "--keep-storage", keepStorage)
+	}
+	return runCommandContext(f.ctx, "docker", args...)
+}
+
+// reportBuildCache prints the size of the build cache on the build context
+func (f *flow) reportBuildCache(when string) {
+	if !f.cache.Report {
+		return
+	}
+	size, err := f.buildCacheSize()
+	if err != nil {
+		outf("Unable to measure the build cache (%s): %v\n", when, err)
+		return
+	}
+	outf("Build cache on '%s' (%s): %s\n", f.buildContext.Name, when, size)
+}
+
+// buildCacheSize returns a human-readable size of the build cache
+func (f *flow) buildCacheSize() (string, error) {
+	if f.buildx != nil {
+		// The docker-container builder keeps its own cache
+		builder := buildxBuilderName(f.buildContext.Name)
+		out, err := commandContext(f.ctx, "docker", "buildx", "du", "--builder", builder).Output()
+		if err != nil {
+			return "", fmt.Errorf("docker buildx du failed: %w", err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(line, "Total:") {
+				return "size=" + strings.TrimSpace(strings.TrimPrefix(line, "Total:")), nil
+			}
+		}
+		return "size=0B", nil
+	}
+
+	out, err := commandContext(f.ctx,
+		"docker", "--context", f.buildContext.Name,
+		"system", "df", "--format", "{{.Type}}|{{.Size}}|{{.Reclaimable}}",
+	).Output()
+	if err != nil {
+		return "", fmt.Errorf("docker system df failed: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Split(line, "|")
+		if len(parts) == 3 && parts[0] == "Build Cache" {
+			return fmt.Sprintf("size=%s, reclaimable=%s", parts[1], parts[2]), nil
+		}
+	}
+	return "", fmt.Errorf("no build cache line in docker system df")
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+func TestCacheInjection(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": `services:
+  api:
+    build: ./api
+    image: shop/api:latest
+  web:
+    build:
+      context: ./web
+      cache_from: [type=local,src=/tmp/old]
+  db:
+    image: postgres:16
+`})
+	path, err := generateTaggedCompose(filepath.Join(dir, "docker-compose.yml"), composeOptions{
+		CacheFrom: []string{"type=registry,ref=reg.example.com/cache:{service}"},
+		CacheTo:   []string{"type=registry,ref=reg.example.com/cache:{service},mode=max"},
+	})
+	if err != nil {
+		t.Fatalf("generateTaggedCompose: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Services map[string]struct {
+			Build interface{} `yaml:"build"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"api", "web"} {
+		build, ok := got.Services[name].Build.(map[string]interface{})
+		if !ok {
+			t.Fatalf("%s: build = %#v, want the long syntax", name, got.Services[name].Build)
+		}
+		wantFrom := "type=registry,ref=reg.example.com/cache:" + name
+		if from := build["cache_from"].([]interface{}); len(from) != 1 || from[0] != wantFrom {
+			t.Errorf("%s: cache_from = %v, want [%s]", name, from, wantFrom)
+		}
+		wantTo := wantFrom + ",mode=max"
+		if to := build["cache_to"].([]interface{}); len(to) != 1 || to[0] != wantTo {
+			t.Errorf("%s: cache_to = %v, want [%s]", name, to, wantTo)
+		}
+	}
+	if build, ok := got.Services["api"].Build.(map[string]interface{}); ok && build["context"] != "./api" {
+		t.Errorf("api: context = %v, want ./api", build["context"])
+	}
+	if got.Services["db"].Build != nil {
+		t.Errorf("db: build = %#v, the services without build must stay untouched", got.Services["db"].Build)
+	}
+}
+
+func TestPruneBuilderCache(t *testing.T) {
+	discardStdout(t)
+	log := filepath.Join(t.TempDir(), "args")
+	fakeDocker(t, `echo "$@" >> `+shellQuote(log))
+	f := &flow{ctx: context.Background(), buildContext: config.DockerContext{Name: "build"}}
+	if err := f.pruneBuilderCache("24h", "10GB"); err != nil {
+		t.Fatalf("pruneBuilderCache: %v", err)
+	}
+	f.buildx = &config.BuildxConfig{}
+	if err := f.pruneBuilderCache("", ""); err != nil {
+		t.Fatalf("pruneBuilderCache with buildx: %v", err)
+	}
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"--context build builder prune -f --filter until=24h --keep-storage 10GB",
+		"buildx prune --builder xpdemon-build -f",
+	}
+	if got := strings.Split(strings.TrimSpace(string(data)), "\n"); !slices.Equal(got, want) {
+		t.Errorf("docker commands = %q, want %q", got, want)
+	}
+}
+
+func TestBuildCacheSize(t *testing.T) {
+	f := &flow{ctx: context.Background(), buildContext: config.DockerContext{Name: "build"}}
+	fakeDocker(t, `printf 'Images|1.2GB|300MB\nBuild Cache|4.5GB|4.5GB\n'`)
+	if got, err := f.buildCacheSize(); err != nil || got != "size=4.5GB, reclaimable=4.5GB" {
+		t.Errorf("buildCacheSize = %q, %v", got, err)
+	}
+	fakeDocker(t, `printf 'Images|1.2GB|300MB\n'`)
+	if _, err := f.buildCacheSize(); err == nil {
+		t.Error("buildCacheSize without a Build Cache line succeeded")
+	}
+
+	f.buildx = &config.BuildxConfig{}
+	fakeDocker(t, `printf 'ID\tRECLAIMABLE\tSIZE\nabc\ttrue\t1GB\nReclaimable:\t1GB\nTotal:\t1.5GB\n'`)
+	if got, err := f.buildCacheSize(); err != nil || got != "size=1.5GB" {
+		t.Errorf("buildCacheSize with buildx = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const (
+	// contextProbeTimeout bounds the commands that only probe a Docker context (docker info...),
+	// so that an unreachable SSH host does not block forever
+	contextProbeTimeout = 30 * time.Second
+	// gracefulStopDelay is the time left to a command to stop after SIGINT before it is killed
+	gracefulStopDelay = 10 * time.Second
+)
+
+// appCtx is cancelled on SIGINT/SIGTERM (set from the root command context)
+var appCtx = context.Background()
+
+// InterruptContext returns a context cancelled on the first SIGINT/SIGTERM.
+// The handler is then removed, so a second Ctrl-C kills the CLI immediately.
+func InterruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		signal.Stop(sigs)
+		fmt.Fprintf(os.Stderr, "\nReceived %s, stopping the current step (press Ctrl-C again to force)...\n", sig)
+		cancel()
+	}()
+	return ctx
+}
+
+// commandContext is exec.CommandContext with a graceful stop: when ctx is done,
+// the process receives SIGINT (docker stops cleanly) and is killed after gracefulStopDelay.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = gracefulStopDelay
+	return cmd
+}
+
+// probeContext returns a context bounded by contextProbeTimeout
+func probeContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(appCtx, contextProbeTimeout)
+}
+
+// interrupted reports whether the user asked to stop (SIGINT/SIGTERM)
+func interrupted() bool {
+	return appCtx.Err() != nil
+}
+
+// contextError converts the end of a step context into a typed error:
+// a CancelledError on SIGINT/SIGTERM, a TimeoutError when the step timeout expired.
+func contextError(ctx context.Context, step string, timeout time.Duration) error {
+	switch {
+	case interrupted():
+		return &CancelledError{stepError{fmt.Errorf("Run cancelled during step '%s'", step)}}
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return &TimeoutError{stepError{fmt.Errorf("Step '%s' timed out after %s", step, timeout)}}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+func TestLoadTimeouts(t *testing.T) {
+	steps := []string{"build", "push", "deploy"}
+	tests := []struct {
+		name    string
+		profile map[string]string
+		flags   map[string]string
+		want    map[string]time.Duration
+		wantErr string
+	}{
+		{name: "none", want: map[string]time.Duration{}},
+		{
+			name:    "the flags override the profile",
+			profile: map[string]string{"push": "10m", "deploy": "5m"},
+			flags:   map[string]string{"deploy": "90s"},
+			want:    map[string]time.Duration{"push": 10 * time.Minute, "deploy": 90 * time.Second},
+		},
+		{name: "unknown step", flags: map[string]string{"tests": "1m"}, wantErr: "unknown step 'tests'"},
+		{name: "invalid duration", flags: map[string]string{"push": "10"}, wantErr: "not a positive duration"},
+		{name: "negative duration", profile: map[string]string{"push": "-1m"}, wantErr: "not a positive duration"},
+	}
+	saved := runFlowTimeouts
+	defer func() { runFlowTimeouts = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runFlowTimeouts = tt.flags
+			f := &flow{steps: steps, profile: config.Profile{Timeouts: tt.profile}}
+			err := f.loadTimeouts()
+			if tt.wantErr != "" {
+				var inputErr *InputError
+				if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadTimeouts error = %v, want an input error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadTimeouts: %v", err)
+			}
+			if len(f.timeouts) != len(tt.want) {
+				t.Errorf("timeouts = %v, want %v", f.timeouts, tt.want)
+			}
+			for step, d := range tt.want {
+				if f.timeouts[step] != d {
+					t.Errorf("timeout of %s = %s, want %s", step, f.timeouts[step], d)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStepTimeout(t *testing.T) {
+	events := captureEvents(t)
+	f := &flow{
+		record:   &history.Record{ID: "run-1"},
+		timeouts: map[string]time.Duration{"push": 100 * time.Millisecond},
+	}
+	start := time.Now()
+	err := f.runStep(flowStep{"push", func() error {
+		return commandContext(f.ctx, "sleep", "30").Run()
+	}})
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("runStep error = %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the command was stopped after %s", elapsed)
+	}
+	if last := (*events)[len(*events)-1]; last.Status != history.StatusFailed {
+		t.Errorf("step status = %q, want %q", last.Status, history.StatusFailed)
+	}
+	if got := ExitCode(err); got != ExitTimeout {
+		t.Errorf("exit code = %d, want %d", got, ExitTimeout)
+	}
+
+	// The other steps are not bounded
+	if err := f.runStep(flowStep{"deploy", func() error { return f.ctx.Err() }}); err != nil {
+		t.Errorf("step without timeout: %v", err)
+	}
+}
+
+func TestRunStepCancelled(t *testing.T) {
+	events := captureEvents(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	saved := appCtx
+	appCtx = ctx
+	defer func() { appCtx = saved }()
+
+	f := &flow{record: &history.Record{ID: "run-1"}}
+	time.AfterFunc(100*time.Millisecond, cancel)
+	err := f.runStep(flowStep{"build", func() error {
+		if err := commandContext(f.ctx, "sleep", "30").Run(); err != nil {
+			return buildErrorf("docker compose build failed: %w", err)
+		}
+		return nil
+	}})
+
+	var cancelled *CancelledError
+	if !errors.As(err, &cancelled) || !strings.Contains(err.Error(), "during step 'build'") {
+		t.Errorf("runStep error = %v, want a cancellation", err)
+	}
+	if last := (*events)[len(*events)-1]; last.Status != history.StatusCancelled {
+		t.Errorf("step status = %q, want %q", last.Status, history.StatusCancelled)
+	}
+	if got := ExitCode(err); got != ExitCancelled {
+		t.Errorf("exit code = %d, want %d", got, ExitCancelled)
+	}
+}
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -18,41 +18,46 @@ import (
 var ListContextsCmd = &cobra.Command{
 	Use:   "list-contexts",
 	Short: "List Docker contexts (in config + on the machine)",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Retrieve contexts registered in the config
 		cfgContexts := config.Cfg.DockerContexts
 
 		// Retrieve contexts actually present on the machine
 		localContexts, err := getLocalDockerContexts()
 		if err != nil {
-			fmt.Printf("Unable to retrieve the list of local contexts: %v\n", err)
-			return
+			return contextErrorf("Unable to retrieve the list of local contexts: %w", err)
 		}
 
+		emitResult(map[string]interface{}{
+			"config_contexts": cfgContexts,
+			"local_contexts":  localContexts,
+		})
+
 		// Display
-		fmt.Println("=== Docker Contexts in the Application Config ===")
+		outln("=== Docker Contexts in the Application Config ===")
 		if len(cfgContexts) == 0 {
-			fmt.Println("No Docker contexts are registered in the config.")
+			outln("No Docker contexts are registered in the config.")
 		} else {
 			for i, ctx := range cfgContexts {
-				fmt.Printf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
+				outf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
 				if ctx.Description != "" {
-					fmt.Printf("    Description: %s\n", ctx.Description)
+					outf("    Description: %s\n", ctx.Description)
 				}
 			}
 		}
 
-		fmt.Println("\n=== Docker Contexts Detected on the Machine ===")
+		outln("\n=== Docker Contexts Detected on the Machine ===")
 		if len(localContexts) == 0 {
-			fmt.Println("No local Docker contexts detected (docker context ls is empty).")
+			outln("No local Docker contexts detected (docker context ls is empty).")
 		} else {
 			for i, ctx := range localContexts {
-				fmt.Printf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
+				outf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
 				if ctx.Description != "" {
-					fmt.Printf("    Description: %s\n", ctx.Description)
+					outf("    Description: %s\n", ctx.Description)
 				}
 			}
 		}
+		return nil
 	},
 }
 
@@ -60,28 +65,26 @@ var ListContextsCmd = &cobra.Command{
 var AddContextCmd = &cobra.Command{
 	Use:   "add-context",
 	Short: "Create a new Docker context or register an existing local context in the configuration",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// Choose mode
 		choice := readLine("Do you want to (C)reate a new context or (R)egister an existing context? (C/R): ")
 		switch strings.ToLower(choice) {
 		case "c":
-			createNewContext()
+			return createNewContext()
 		case "r":
-			registerExistingContext()
+			return registerExistingContext()
 		default:
-			fmt.Println("Invalid choice, cancellation.")
-			return
+			return inputErrorf("Invalid choice, cancellation.")
 		}
 	},
 }
 
 // createNewContext contains the logic to create a real Docker context (docker context create).
-func createNewContext() {
+func createNewContext() error {
 	// 1. Ask for the context name
 	contextName := readLine("Name of the new Docker context: ")
 	if contextName == "" {
-		fmt.Println("Context name is required, cancellation.")
-		return
+		return inputErrorf("Context name is required, cancellation.")
 	}
 
 	// 2. Ask for an optional description
@@ -90,8 +93,7 @@ func createNewContext() {
 	// 3. Ask for the Docker Host (e.g., ssh://user@server or tcp://192.168.1.10:2376)
 	host := readLine("Docker Host (e.g., ssh://user@host, tcp://X.X.X.X:2376): ")
 	if host == "" {
-		fmt.Println("Docker host is required, cancellation.")
-		return
+		return inputErrorf("Docker host is required, cancellation.")
 	}
 
 	// 4. Build the docker context create command
@@ -105,26 +107,26 @@ func createNewContext() {
 		dockerArgs = append(dockerArgs, "--description", desc)
 	}
 
-	fmt.Println("==> Creating context via docker CLI...")
+	outln("==> Creating context via docker CLI...")
 	err := runCommand("docker", dockerArgs...)
 	if err != nil {
-		fmt.Printf("Failed to create Docker context: %v\n", err)
-		return
+		return contextErrorf("Failed to create Docker context: %w", err)
 	}
-	fmt.Printf("Docker context '%s' created successfully.\n", contextName)
+	outf("Docker context '%s' created successfully.\n", contextName)
 
 	// === Connection Test for the Context ===
-	fmt.Println("==> Testing connection for the new context...")
+	outln("==> Testing connection for the new context...")
 	if err := testDockerContext(contextName, host); err != nil {
-		fmt.Printf("Connection test failed: %v\n", err)
+		err = contextErrorf("Connection test failed: %w", err)
+		outln(err)
 		choice := readLine("Do you want to remove this context (y/n)? : ")
 		if strings.ToLower(choice) == "y" {
 			_ = removeDockerContext(contextName) // ignoring error
-			fmt.Printf("Context '%s' has been removed.\n", contextName)
+			outf("Context '%s' has been removed.\n", contextName)
 		} else {
-			fmt.Println("Context is kept, but it may be non-functional.")
+			outln("Context is kept, but it may be non-functional.")
 		}
-		return // stop here without saving to config
+		return err // stop here without saving to config
 	}
 
 	// 5. Store this context in the config for future use (only if test is OK)
@@ -139,35 +141,41 @@ func createNewContext() {
 	// 6. Save the config
 	err = config.SaveConfig()
 	if err != nil {
-		fmt.Printf("Error while saving: %v\n", err)
-		return
+		return configErrorf("Error while saving: %w", err)
 	}
 
-	fmt.Printf("Context '%s' added and saved in the configuration.\n", contextName)
+	outf("Context '%s' added and saved in the configuration.\n", contextName)
+	emitResult(map[string]interface{}{"context": newCtx, "created": true})
+	return nil
 }
 
 // registerExistingContext goes through the list of local Docker contexts and allows the user to register one.
-func registerExistingContext() {
+func registerExistingContext() error {
 	localContexts, err := getLocalDockerContexts()
 	if err != nil {
-		fmt.Printf("Error: unable to list existing contexts: %v\n", err)
-		return
+		return contextErrorf("Error: unable to list existing contexts: %w", err)
 	}
 	if len(localContexts) == 0 {
-		fmt.Println("No local contexts are available for registration.")
-		return
-	}
-
-	fmt.Println("Available local contexts:")
-	for i, ctx := range localContexts {
-		fmt.Printf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
+		return contextErrorf("No local contexts are available for registration.")
 	}
 
-	idxInput := readLine("Select the index of the context to register in the config: ")
-	idx := strToInt(idxInput)
-	if idx < 0 || idx >= len(localContexts) {
-		fmt.Println("Invalid index.")
-		return
+	var idx int
+	if interactive() {
+		items := make([]string, len(localContexts))
+		for i, ctx := range localContexts {
+			items[i] = fmt.Sprintf("%s (host=%s)", ctx.Name, ctx.Host)
+		}
+		if idx, err = selectOne("Context to register", items); err != nil {
+			return inputErrorf("%w", err)
+		}
+	} else {
+		outln("Available local contexts:")
+		for i, ctx := range localContexts {
+			outf("[%d] %s (host=%s)\n", i, ctx.Name, ctx.Host)
+		}
+		if idx, err = parseIndex(readLine("Select the index of the context to register in the config: "), len(localContexts)); err != nil {
+			return inputErrorf("Invalid context: %w", err)
+		}
 	}
 
 	selectedCtx := localContexts[idx]
@@ -175,8 +183,7 @@ func registerExistingContext() {
 	// Check if it's already in the config
 	for _, c := range config.Cfg.DockerContexts {
 		if c.Name == selectedCtx.Name {
-			fmt.Printf("Context '%s' is already present in the configuration.\n", selectedCtx.Name)
-			return
+			return inputErrorf("Context '%s' is already present in the configuration.", selectedCtx.Name)
 		}
 	}
 
@@ -184,27 +191,33 @@ func registerExistingContext() {
 	config.Cfg.DockerContexts = append(config.Cfg.DockerContexts, selectedCtx)
 	err = config.SaveConfig()
 	if err != nil {
-		fmt.Printf("Error while saving the config: %v\n", err)
-		return
+		return configErrorf("Error while saving the config: %w", err)
 	}
-	fmt.Printf("Context '%s' registered in the configuration.\n", selectedCtx.Name)
+	outf("Context '%s' registered in the configuration.\n", selectedCtx.Name)
+	emitResult(map[string]interface{}{"context": selectedCtx, "registered": true})
+	return nil
 }
 
 // testDockerContext executes a simple command to verify that the context is functional
 // and in case of error, attempts to add the SSH key (if Host key verification failed or Permission denied).
 func testDockerContext(contextName, dockerHost string) error {
-	// Execute "docker --context <name> info"
-	err := runCommand("docker", "--context", contextName, "info")
+	policy, err := defaultRetryPolicy()
+	if err != nil {
+		return configErrorf("Invalid retry configuration: %w", err)
+	}
+	probe := func(ctx context.Context) *exec.Cmd {
+		return commandContext(ctx, "docker", "--context", contextName, "info")
+	}
+
+	// Execute "docker --context <name> info" (each attempt is bounded, an unreachable host must not block forever)
+	output, err := runWithRetry(appCtx, policy, contextProbeTimeout, probe)
 	if err == nil {
 		return nil // OK
 	}
 
-	// Convert the error to string for analysis
-	errStr := err.Error()
-
-	// Check for "Host key verification failed" or "Permission denied"
-	if strings.Contains(errStr, "Host key verification failed") || strings.Contains(errStr, "Permission denied") {
-		fmt.Println("==> SSH connection failed.")
+	// Check for "Host key verification failed" or "Permission denied" in the docker output
+	if strings.Contains(output, "Host key verification failed") || strings.Contains(output, "Permission denied") {
+		outln("==> SSH connection failed.")
 
 		// Parse the dockerHost URL, e.g., "ssh://user@192.168.1.16"
 		user, hostAddr, parseErr := parseSSHURL(dockerHost)
@@ -228,8 +241,8 @@ func testDockerContext(contextName, dockerHost string) error {
 			}
 
 			// Retry the Docker command after adding the key
-			fmt.Println("SSH key added, retrying Docker connection...")
-			if reErr := runCommand("docker", "--context", contextName, "info"); reErr != nil {
+			outln("SSH key added, retrying Docker connection...")
+			if _, reErr := runWithRetry(appCtx, policy, contextProbeTimeout, probe); reErr != nil {
 				return fmt.Errorf("Docker connection still fails after adding the key: %v\n, "+
 					"please consult https://gist.github.com/dnaprawa/d3cfd6e444891c84846e099157fd51ef to add your public key \n"+
 					"on the remote machine", reErr)
@@ -253,7 +266,7 @@ func removeDockerContext(contextName string) error {
 
 // addSSHKeyWithPassword executes an SSH command with sshpass to add the SSH key
 func addSSHKeyWithPassword(user, hostAddr, password string) error {
-	fmt.Printf("==> Adding SSH key via 'sshpass' for %s@%s\n", user, hostAddr)
+	outf("==> Adding SSH key via 'sshpass' for %s@%s\n", user, hostAddr)
 
 	// Check if sshpass is installed
 	if !isSSHpassInstalled() {
@@ -276,10 +289,12 @@ func addSSHKeyWithPassword(user, hostAddr, password string) error {
 		"exit",
 	}
 
-	cmd := exec.Command("sshpass", cmdArgs...)
+	ctx, cancel := probeContext()
+	defer cancel()
+	cmd := commandContext(ctx, "sshpass", cmdArgs...)
 
 	// Redirect stdout/stderr for feedback
-	cmd.Stdout = os.Stdout
+	cmd.Stdout = out()
 	cmd.Stderr = os.Stderr
 
 	err := cmd.Run()
@@ -320,7 +335,7 @@ func parseSSHURL(sshURL string) (string, string, error) {
 
 // readPassword reads a password from standard input without displaying it
 func readPassword(prompt string) string {
-	fmt.Print(prompt)
+	fmt.Fprint(out(), prompt)
 	// Disable character echoing
 	// Works on Unix systems (Linux, macOS)
 	cmd := exec.Command("stty", "-echo")
@@ -328,14 +343,17 @@ func readPassword(prompt string) string {
 	cmd.Run()
 
 	// Read the password
-	reader := bufio.NewReader(os.Stdin)
-	password, _ := reader.ReadString('\n')
+	password, _ := stdinReader.ReadString('\n')
+	if interrupted() {
+		password = ""
+	}
 
 	// Re-enable character echoing
 	cmd = exec.Command("stty", "echo")
 	cmd.Stdin = os.Stdin
 	cmd.Run()
 
-	fmt.Println() // New line after password input
-	return strings.TrimSpace(password)
+	outln() // New line after password input
+	// Only the line ending is removed: spaces are part of the password
+	return strings.TrimRight(password, "\r\n")
 }
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/xpdemon/ac-deploy/compose"
+)
+
+// secretVersionsKept is the number of secret versions kept on the deploy host:
+// the previous ones are still used by a rollback or a blue-green switch-back
+const secretVersionsKept = 5
+
+// secretsDir is the directory of the delivered secrets, in the project directory
+const secretsDir = ".secrets"
+
+// checkEnvFiles verifies that the env files of the services exist.
+// docker compose reads them on the local machine, even for a remote deploy context.
+func (f *flow) checkEnvFiles() error {
+	var missing []string
+	for _, svc := range f.allServices {
+		for _, e := range svc.EnvFiles {
+			if _, err := os.Stat(e.Path); err != nil && e.Required {
+				missing = append(missing, fmt.Sprintf("%s (%s)", e.Path, svc.Name))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return composeErrorf("Missing env file(s): %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// runtimeSecretFiles returns the local file of the secrets mounted in the containers
+// (secret name => path). The secrets only used by the builds are read locally by BuildKit.
+func (f *flow) runtimeSecretFiles() (map[string]string, error) {
+	secrets, err := compose.ParseSecrets(f.absComposeFile)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]string)
+	var missing []string
+	for _, svc := range f.allServices {
+		for _, name := range svc.Secrets {
+			secret, ok := secrets[name]
+			if !ok || secret.File == "" {
+				continue
+			}
+			if _, err := os.Stat(secret.File); err != nil {
+				missing = append(missing, fmt.Sprintf("%s (%s)", secret.File, name))
+				continue
+			}
+			files[name] = secret.File
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing secret file(s): %s", strings.Join(missing, ", "))
+	}
+	return files, nil
+}
+
+// secretPaths returns the path of the secret files on the deploy host for this deployment
+// (secret name => <project directory>/.secrets/<run ID>/<name>), nil for a local deploy context
+func (f *flow) secretPaths() (map[string]string, error) {
+	files, err := f.runtimeSecretFiles()
+	if err != nil {
+		return nil, composeErrorf("Invalid secrets: %w", err)
+	}
+	host := f.deployContext.Host
+	if len(files) == 0 || host == "" || strings.HasPrefix(host, "unix://") {
+		return nil, nil
+	}
+	dir := f.projectDirectory()
+	if dir == "" && f.deployContext.ProjectsDir != "" {
+		dir = path.Join(f.deployContext.ProjectsDir, f.projectName())
+	}
+	if dir == "" {
+		return nil, configErrorf("The secret files must be delivered to '%s': set a project directory (project_directory, or projects_dir on the context).", f.deployContext.Name)
+	}
+
+	paths := make(map[string]string, len(files))
+	for name := range files {
+		paths[name] = path.Join(dir, secretsDir, f.record.ID, name)
+	}
+	return paths, nil
+}
+
+// deliverSecrets copies the secret files to the paths of the generated compose file:
+// a new directory per deployment, that only the SSH user can open (0700). The files
+// themselves are readable (0644): they are bind-mounted, and the containers may not run as root.
+// The oldest versions are removed.
+func (f *flow) deliverSecrets() error {
+	files, err := f.runtimeSecretFiles()
+	if err != nil {
+		return composeErrorf("Invalid secrets: %w", err)
+	}
+	generated, err := compose.ParseSecrets(f.newComposePath)
+	if err != nil {
+		return composeErrorf("Error parsing the generated docker-compose: %w", err)
+	}
+
+	// 1) Secrets whose path was rewritten, grouped by version directory
+	byDir := make(map[string][]syncFile)
+	for name, local := range files {
+		remotePath := generated[name].File
+		if remotePath == "" || remotePath == local {
+			continue
+		}
+		info, err := os.Stat(local)
+		if err != nil {
+			return composeErrorf("Invalid secrets: %w", err)
+		}
+		dir := path.Dir(remotePath)
+		byDir[dir] = append(byDir[dir], syncFile{rel: path.Base(remotePath), abs: local, info: info})
+	}
+	if len(byDir) == 0 {
+		return nil
+	}
+	remote, err := remoteShell(f.deployContext.Host)
+	if err != nil {
+		return contextErrorf("Unable to deliver the secret files to '%s': %w", f.deployContext.Name, err)
+	}
+
+	// 2) Upload, then keep the last versions
+	for dir, entries := range byDir {
+		outf("==> Delivering %d secret file(s) to %s:%s...\n", len(entries), f.deployContext.Name, dir)
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, shellQuote(e.rel))
+		}
+		// Only .secrets and the version directory get the restrictive permissions
+		command := fmt.Sprintf("mkdir -p %[4]s && umask 077 && mkdir -p %[1]s && chmod 700 %[4]s/.secrets %[1]s && tar -xzf - -C %[1]s && cd %[1]s && chmod 644 -- %[2]s"+
+			" && cd .. && ls -1 | sort -r | tail -n +%[3]d | xargs -r rm -rf --",
+			shellQuote(dir), strings.Join(names, " "), secretVersionsKept+1, shellQuote(path.Dir(path.Dir(dir))))
+		name, args := remote(command)
+		if err := streamArchive(f.ctx, entries, name, args); err != nil {
+			return deployErrorf("Error delivering the secret files: %w", err)
+		}
+	}
+	return nil
+}
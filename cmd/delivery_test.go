@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// secretsProject writes a compose file mounting the secret db_password in api
+func secretsProject(t *testing.T) (*flow, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docker-compose.yml": `services:
+  api:
+    image: shop/api:latest
+    env_file: [.env, {path: ./local.env, required: false}]
+    secrets: [db_password]
+secrets:
+  db_password:
+    file: ./secrets/db.txt
+  api_key:
+    environment: API_KEY
+`,
+		".env":           "TAG=1.0\n",
+		"secrets/db.txt": "s3cret\n",
+	})
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	services, err := compose.ParseServices(composeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &flow{
+		ctx:            context.Background(),
+		record:         &history.Record{ID: "20261019-120000.000"},
+		absComposeFile: composeFile,
+		composeFile:    composeFile,
+		allServices:    services,
+		profile:        config.Profile{Project: "shop"},
+	}
+	return f, dir
+}
+
+func TestCheckEnvFiles(t *testing.T) {
+	f, dir := secretsProject(t)
+	if err := f.checkEnvFiles(); err != nil {
+		t.Errorf("checkEnvFiles: %v", err)
+	}
+	os.Remove(filepath.Join(dir, ".env"))
+	err := f.checkEnvFiles()
+	var composeErr *ComposeError
+	if !errors.As(err, &composeErr) || !strings.Contains(err.Error(), filepath.Join(dir, ".env")+" (api)") {
+		t.Errorf("checkEnvFiles without .env = %v", err)
+	}
+	if strings.Contains(fmt.Sprint(err), "local.env") {
+		t.Errorf("an optional env file is reported: %v", err)
+	}
+}
+
+func TestSecretPaths(t *testing.T) {
+	f, dir := secretsProject(t)
+	tests := []struct {
+		name    string
+		context config.DockerContext
+		project string
+		want    map[string]string
+		wantErr string
+	}{
+		{name: "local context", context: config.DockerContext{Name: "local", Host: "unix:///var/run/docker.sock"}},
+		{name: "default context", context: config.DockerContext{Name: "default"}},
+		{
+			name:    "projects_dir of the context",
+			context: config.DockerContext{Name: "prod", Host: "ssh://deploy@prod", ProjectsDir: "/srv"},
+			want:    map[string]string{"db_password": "/srv/shop/.secrets/20261019-120000.000/db_password"},
+		},
+		{
+			name:    "project directory",
+			context: config.DockerContext{Name: "prod", Host: "ssh://deploy@prod"},
+			project: "/opt/shop",
+			want:    map[string]string{"db_password": "/opt/shop/.secrets/20261019-120000.000/db_password"},
+		},
+		{
+			name:    "no directory",
+			context: config.DockerContext{Name: "prod", Host: "ssh://deploy@prod"},
+			wantErr: "set a project directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f.deployContext = tt.context
+			f.profile.ProjectDirectory = tt.project
+			got, err := f.secretPaths()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("secretPaths error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("secretPaths = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	// A missing secret file is reported before anything is generated
+	os.Remove(filepath.Join(dir, "secrets", "db.txt"))
+	f.deployContext = config.DockerContext{Name: "prod", Host: "ssh://deploy@prod", ProjectsDir: "/srv"}
+	if _, err := f.secretPaths(); err == nil || !strings.Contains(err.Error(), "missing secret file(s)") {
+		t.Errorf("secretPaths without the secret file = %v", err)
+	}
+}
+
+func TestDeliverSecrets(t *testing.T) {
+	discardStdout(t)
+	f, dir := secretsProject(t)
+	remote := filepath.Join(t.TempDir(), "shop")
+	f.deployContext = config.DockerContext{Name: "local", Host: "unix:///var/run/docker.sock"}
+
+	deliver := func(id string) string {
+		t.Helper()
+		remotePath := filepath.Join(remote, secretsDir, id, "db_password")
+		path, err := generateTaggedCompose(f.absComposeFile, composeOptions{
+			EnvFileDir:  dir,
+			SecretFiles: map[string]string{"db_password": remotePath},
+		})
+		if err != nil {
+			t.Fatalf("generateTaggedCompose: %v", err)
+		}
+		f.newComposePath = path
+		if err := f.deliverSecrets(); err != nil {
+			t.Fatalf("deliverSecrets: %v", err)
+		}
+		return remotePath
+	}
+
+	// 1) The secret is copied to the version directory of the run, that only the user can open
+	remotePath := deliver("20261019-120000.000")
+	checkFile(t, filepath.Dir(remotePath), "db_password", "s3cret\n")
+	for path, want := range map[string]os.FileMode{filepath.Join(remote, secretsDir): 0700, filepath.Dir(remotePath): 0700, remotePath: 0644} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != want {
+			t.Errorf("%s: mode %v (%v), want %v", path, info.Mode().Perm(), err, want)
+		}
+	}
+	generated, err := compose.ParseSecrets(f.newComposePath)
+	if err != nil || generated["db_password"].File != remotePath {
+		t.Errorf("generated secret = %+v, %v", generated["db_password"], err)
+	}
+	services, err := compose.ParseServices(f.newComposePath)
+	if err != nil || services[0].EnvFiles[0].Path != filepath.Join(dir, ".env") {
+		t.Errorf("generated env files = %+v, %v", services, err)
+	}
+
+	// 2) Only the last versions are kept
+	for i := 1; i <= secretVersionsKept+1; i++ {
+		deliver(fmt.Sprintf("20261019-12000%d.000", i))
+	}
+	entries, err := os.ReadDir(filepath.Join(remote, secretsDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != secretVersionsKept || entries[0].Name() != "20261019-120002.000" {
+		t.Errorf("kept versions = %v, want the last %d", entries, secretVersionsKept)
+	}
+}
+
+func TestSecretUsedByBuildAndContainers(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": `services:
+  api:
+    build:
+      context: .
+      secrets: [db_password]
+    secrets: [db_password]
+secrets:
+  db_password:
+    file: ./db.txt
+`})
+	_, err := generateTaggedCompose(filepath.Join(dir, "docker-compose.yml"), composeOptions{
+		SecretFiles: map[string]string{"db_password": "/srv/shop/.secrets/1/db_password"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "declare two secrets") {
+		t.Errorf("generateTaggedCompose = %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/compose"
+)
+
+// Kinds of differences between the deployed compose file and the containers
+const (
+	DriftMissing  = "missing"  // a service of the compose file has no container
+	DriftUnknown  = "unknown"  // a container of the project belongs to no service of the compose file
+	DriftStopped  = "stopped"  // a container is not running
+	DriftReplicas = "replicas" // another number of running containers
+	DriftImage    = "image"    // the container was created from another image reference
+	DriftDigest   = "digest"   // the container runs another image than the one its tag points at
+	DriftEnv      = "env"      // a variable was changed, removed or added
+	DriftPorts    = "ports"    // other published ports
+)
+
+// secretPlaceholder is the value given to the variables of the secrets store when the compose
+// file is resolved: the values of the containers that use them can't be compared
+const secretPlaceholder = "<xpdemon-deploy secret>"
+
+// driftFinding is one difference found by the drift command
+type driftFinding struct {
+	Service string `json:"service"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+// desiredService is a service of "docker compose config --format json"
+type desiredService struct {
+	Image       string             `json:"image"`
+	Environment map[string]*string `json:"environment"`
+	Ports       []struct {
+		Target    int         `json:"target"`
+		Published interface{} `json:"published"`
+		Protocol  string      `json:"protocol"`
+		HostIP    string      `json:"host_ip"`
+	} `json:"ports"`
+	Scale  *int `json:"scale"`
+	Deploy *struct {
+		Replicas *int `json:"replicas"`
+	} `json:"deploy"`
+}
+
+// runningContainer is the part of "docker inspect" compared with the compose file
+type runningContainer struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Image  string `json:"Image"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Env    []string          `json:"Env"`
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+	State struct {
+		Status string `json:"Status"`
+	} `json:"State"`
+	HostConfig struct {
+		PortBindings map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"PortBindings"`
+	} `json:"HostConfig"`
+}
+
+// imageInfo is the part of "docker image inspect" used by the comparisons
+type imageInfo struct {
+	ID     string `json:"Id"`
+	Config struct {
+		Env []string `json:"Env"`
+	} `json:"Config"`
+}
+
+// DriftCmd compares a deployed stack with its compose file
+var DriftCmd = &cobra.Command{
+	Use:     "drift",
+	Aliases: []string{"diff"},
+	Short:   "Compare the containers of a deployed stack with its compose file (images, env, ports, replicas)",
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		if s.composeFile == "" {
+			return composeErrorf("The compose files of the deployment no longer exist, there is nothing to compare.")
+		}
+		outf("==> Comparing %s with the containers of %s on '%s'...\n", s.composeFile, s.project, s.context)
+		findings, err := s.drift()
+		if err != nil {
+			return err
+		}
+
+		for _, d := range findings {
+			outf("  %-12s %-8s %s\n", d.Service, d.Kind, d.Message)
+		}
+		emitResult(map[string]interface{}{
+			"run":          s.runID,
+			"context":      s.context,
+			"project":      s.project,
+			"compose_file": s.composeFile,
+			"findings":     findings,
+		})
+		if len(findings) > 0 {
+			return driftErrorf("%d difference(s) between the compose file and the containers of %s.", len(findings), s.project)
+		}
+		outln("No drift: the containers match the compose file.")
+		return nil
+	},
+}
+
+// drift lists the differences between the compose file and the containers of the project
+func (s *stack) drift() ([]driftFinding, error) {
+	desired, err := s.desiredServices()
+	if err != nil {
+		return nil, err
+	}
+	containers, err := s.containers()
+	if err != nil {
+		return nil, err
+	}
+
+	byService := make(map[string][]runningContainer)
+	for _, c := range containers {
+		// "docker compose run" containers are not part of the deployment
+		if c.Config.Labels["com.docker.compose.oneoff"] == "True" {
+			continue
+		}
+		name := c.Config.Labels["com.docker.compose.service"]
+		byService[name] = append(byService[name], c)
+	}
+
+	images := make(map[string]*imageInfo)
+	image := func(ref string) *imageInfo {
+		if info, ok := images[ref]; ok {
+			return info
+		}
+		var info *imageInfo
+		if output, err := commandOutput(appCtx, "docker", "--context", s.context, "image", "inspect", "--format", "{{json .}}", ref); err == nil {
+			info = &imageInfo{}
+			if json.Unmarshal([]byte(output), info) != nil {
+				info = nil
+			}
+		}
+		images[ref] = info
+		return info
+	}
+
+	var findings []driftFinding
+	add := func(service, kind, format string, a ...interface{}) {
+		d := driftFinding{Service: service, Kind: kind, Message: fmt.Sprintf(format, a...)}
+		// The replicas of a service often share the same difference
+		if !slices.Contains(findings, d) {
+			findings = append(findings, d)
+		}
+	}
+	for _, name := range sortedKeys(desired) {
+		svc := desired[name]
+		list := byService[name]
+		if len(list) == 0 && svc.replicas() > 0 {
+			add(name, DriftMissing, "no container (removed, or never started)")
+			continue
+		}
+		if len(list) == 0 {
+			continue
+		}
+		wantImage := svc.Image
+		if wantImage == "" {
+			// Same default as docker compose for the services built without an image name
+			wantImage = s.project + "-" + name
+		}
+		running := 0
+		for _, c := range list {
+			if c.State.Status != "running" {
+				add(name, DriftStopped, "container %s is %s", strings.TrimPrefix(c.Name, "/"), c.State.Status)
+				continue
+			}
+			running++
+			if normalizeImage(c.Config.Image) != normalizeImage(wantImage) {
+				add(name, DriftImage, "runs %s, the compose file has %s", c.Config.Image, wantImage)
+			} else if want := image(wantImage); want == nil {
+				add(name, DriftDigest, "%s is no longer on the deploy host", wantImage)
+			} else if want.ID != c.Image {
+				add(name, DriftDigest, "runs %s, %s now points at %s (pulled or built since)", shortDigest(c.Image), wantImage, shortDigest(want.ID))
+			}
+			for _, diff := range envDrift(svc.Environment, c.Config.Env, image(c.Image)) {
+				add(name, DriftEnv, "%s", diff)
+			}
+			if have, want := containerPorts(c), svc.ports(); !slices.Equal(have, want) {
+				add(name, DriftPorts, "publishes [%s], the compose file has [%s]", strings.Join(have, ", "), strings.Join(want, ", "))
+			}
+		}
+		if want := svc.replicas(); running != want {
+			add(name, DriftReplicas, "%d container(s) running, %d expected", running, want)
+		}
+	}
+	for _, name := range sortedKeys(byService) {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		for _, c := range byService[name] {
+			add(name, DriftUnknown, "container %s is not in the compose file (started by hand, or service removed)", strings.TrimPrefix(c.Name, "/"))
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Service < findings[j].Service })
+	return findings, nil
+}
+
+// desiredServices reads the services of the compose file, as docker compose resolves them
+// (interpolated variables, env files, normalized ports), with the environment of the deployment:
+// its project directory, its secret env files, and placeholders for the secrets store
+func (s *stack) desiredServices() (map[string]desiredService, error) {
+	for _, path := range s.secretEnvFiles {
+		values, err := compose.ParseEnvFile(expandHome(path))
+		if err != nil {
+			return nil, inputErrorf("Error reading secret env file: %w", err)
+		}
+		for k, v := range values {
+			os.Setenv(k, v)
+		}
+	}
+	for _, key := range s.secretKeys {
+		os.Setenv(key, secretPlaceholder)
+	}
+	output, err := commandOutput(appCtx, "docker", s.args("config", "--format", "json")...)
+	if err != nil {
+		return nil, composeErrorf("Unable to resolve %s: %w", s.composeFile, err)
+	}
+	var project struct {
+		Services map[string]desiredService `json:"services"`
+	}
+	if err := json.Unmarshal([]byte(output), &project); err != nil {
+		return nil, composeErrorf("Unable to read the configuration of %s: %w", s.composeFile, err)
+	}
+	return project.Services, nil
+}
+
+// containers inspects the containers of the project on the deploy context, stopped ones included
+func (s *stack) containers() ([]runningContainer, error) {
+	output, err := commandOutput(appCtx, "docker", "--context", s.context, "ps", "-a", "-q", "--filter", "label=com.docker.compose.project="+s.project)
+	if err != nil {
+		return nil, contextErrorf("Unable to list the containers of %s on '%s': %w", s.project, s.context, err)
+	}
+	ids := strings.Fields(output)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	output, err = commandOutput(appCtx, "docker", append([]string{"--context", s.context, "inspect"}, ids...)...)
+	if err != nil {
+		return nil, contextErrorf("Unable to inspect the containers of %s: %w", s.project, err)
+	}
+	var containers []runningContainer
+	if err := json.Unmarshal([]byte(output), &containers); err != nil {
+		return nil, contextErrorf("Unable to read the containers of %s: %w", s.project, err)
+	}
+	return containers, nil
+}
+
+// replicas returns the number of containers the service should run
+func (d desiredService) replicas() int {
+	switch {
+	case d.Deploy != nil && d.Deploy.Replicas != nil:
+		return *d.Deploy.Replicas
+	case d.Scale != nil:
+		return *d.Scale
+	}
+	return 1
+}
+
+// ports returns the published ports of the service: "127.0.0.1:8080:80/tcp"
+func (d desiredService) ports() []string {
+	var ports []string
+	for _, p := range d.Ports {
+		published := ""
+		if p.Published != nil {
+			published = fmt.Sprint(p.Published)
+		}
+		ports = append(ports, formatPort(p.HostIP, published, fmt.Sprintf("%d/%s", p.Target, cmp.Or(p.Protocol, "tcp"))))
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+// containerPorts returns the ports requested by the container, in the format of desiredService.ports
+func containerPorts(c runningContainer) []string {
+	var ports []string
+	for target, bindings := range c.HostConfig.PortBindings {
+		for _, b := range bindings {
+			ports = append(ports, formatPort(b.HostIP, b.HostPort, target))
+		}
+	}
+	sort.Strings(ports)
+	return ports
+}
+
+func formatPort(hostIP, published, target string) string {
+	if hostIP == "0.0.0.0" {
+		hostIP = ""
+	}
+	port := published + ":" + target
+	if hostIP != "" {
+		port = hostIP + ":" + port
+	}
+	return port
+}
+
+// envDrift compares the variables of the compose file with the ones of the container.
+// The variables defined by the image are expected; the values are never printed.
+func envDrift(desired map[string]*string, env []string, img *imageInfo) []string {
+	running := envMap(env)
+	var imageEnv map[string]string
+	if img != nil {
+		imageEnv = envMap(img.Config.Env)
+	}
+
+	var diffs []string
+	for _, key := range sortedKeys(desired) {
+		want := desired[key]
+		if want == nil || strings.Contains(*want, secretPlaceholder) {
+			// Unset when the compose file was resolved, or set from the secrets store
+			continue
+		}
+		have, ok := running[key]
+		switch {
+		case !ok:
+			diffs = append(diffs, key+" is not set in the container")
+		case have != *want:
+			diffs = append(diffs, key+" has another value than in the compose file")
+		}
+	}
+	for _, key := range sortedKeys(running) {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		if value, ok := imageEnv[key]; ok && value == running[key] {
+			continue
+		}
+		if img == nil {
+			// Without the image, the variables it defines can't be told apart
+			continue
+		}
+		diffs = append(diffs, key+" is set in the container but not in the compose file")
+	}
+	return diffs
+}
+
+// envMap converts KEY=VALUE entries to a map
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		m[key] = value
+	}
+	return m
+}
+
+// normalizeImage adds the implicit "latest" tag to an image reference
+func normalizeImage(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+// shortDigest shortens an image ID: "sha256:0123456789ab"
+func shortDigest(id string) string {
+	algo, hex, ok := strings.Cut(id, ":")
+	if !ok {
+		return shortID(id)
+	}
+	return algo + ":" + shortID(hex)
+}
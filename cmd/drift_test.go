@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestFormatPort(t *testing.T) {
+	tests := []struct {
+		hostIP, published, target string
+		want                      string
+	}{
+		{"", "8080", "80/tcp", "8080:80/tcp"},
+		{"0.0.0.0", "8080", "80/tcp", "8080:80/tcp"},
+		{"127.0.0.1", "8080", "80/tcp", "127.0.0.1:8080:80/tcp"},
+		{"::1", "53", "53/udp", "::1:53:53/udp"},
+		{"", "", "80/tcp", ":80/tcp"},
+	}
+	for _, tt := range tests {
+		if got := formatPort(tt.hostIP, tt.published, tt.target); got != tt.want {
+			t.Errorf("formatPort(%q, %q, %q) = %q, want %q", tt.hostIP, tt.published, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestPortsMatch(t *testing.T) {
+	// docker compose config prints the published port as a string, docker inspect the bindings by target
+	var desired desiredService
+	err := json.Unmarshal([]byte(`{"ports": [
+		{"target": 80, "published": "8080", "protocol": "tcp"},
+		{"target": 53, "published": "5353", "protocol": "udp", "host_ip": "127.0.0.1"},
+		{"target": 9000}
+	]}`), &desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c runningContainer
+	err = json.Unmarshal([]byte(`{"HostConfig": {"PortBindings": {
+		"80/tcp": [{"HostIp": "0.0.0.0", "HostPort": "8080"}],
+		"53/udp": [{"HostIp": "127.0.0.1", "HostPort": "5353"}],
+		"9000/tcp": [{"HostIp": "", "HostPort": ""}]
+	}}}`), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := containerPorts(c), desired.ports(); !slices.Equal(have, want) {
+		t.Errorf("containerPorts = %v, ports = %v", have, want)
+	}
+}
+
+func TestEnvDrift(t *testing.T) {
+	str := func(s string) *string { return &s }
+	image := &imageInfo{}
+	image.Config.Env = []string{"PATH=/usr/bin", "LANG=C"}
+
+	tests := []struct {
+		name    string
+		desired map[string]*string
+		env     []string
+		img     *imageInfo
+		want    []string
+	}{
+		{
+			name:    "same",
+			desired: map[string]*string{"A": str("1"), "B": str("x=y")},
+			env:     []string{"PATH=/usr/bin", "A=1", "B=x=y"},
+			img:     image,
+			want:    nil,
+		},
+		{
+			name:    "changed, missing and added",
+			desired: map[string]*string{"A": str("1"), "B": str("2")},
+			env:     []string{"A=10", "C=3"},
+			img:     image,
+			want: []string{
+				"A has another value than in the compose file",
+				"B is not set in the container",
+				"C is set in the container but not in the compose file",
+			},
+		},
+		{
+			name:    "empty value is a value",
+			desired: map[string]*string{"A": str("")},
+			env:     []string{"A="},
+			img:     image,
+			want:    nil,
+		},
+		{
+			name:    "unresolved variable is not compared",
+			desired: map[string]*string{"SECRET": nil},
+			env:     []string{"SECRET=s3cr3t"},
+			img:     image,
+			want:    nil,
+		},
+		{
+			name:    "secrets store values are not compared",
+			desired: map[string]*string{"DB_PASSWORD": str(secretPlaceholder), "DATABASE_URL": str("postgres://u:" + secretPlaceholder + "@db")},
+			env:     []string{"DB_PASSWORD=s3cr3t", "DATABASE_URL=postgres://u:s3cr3t@db"},
+			img:     image,
+			want:    nil,
+		},
+		{
+			name:    "image variables are expected, unless changed",
+			desired: map[string]*string{},
+			env:     []string{"PATH=/usr/bin", "LANG=fr_FR"},
+			img:     image,
+			want:    []string{"LANG is set in the container but not in the compose file"},
+		},
+		{
+			name:    "unknown image",
+			desired: map[string]*string{"A": str("1")},
+			env:     []string{"PATH=/bin", "EXTRA=1"},
+			img:     nil,
+			want:    []string{"A is not set in the container"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envDrift(tt.desired, tt.env, tt.img); !slices.Equal(got, tt.want) {
+				t.Errorf("envDrift = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeImage(t *testing.T) {
+	tests := map[string]string{
+		"nginx":                    "nginx:latest",
+		"nginx:1.27":               "nginx:1.27",
+		"reg.io:5000/app":          "reg.io:5000/app:latest",
+		"reg.io:5000/app:1.0":      "reg.io:5000/app:1.0",
+		"nginx@sha256:0123456789a": "nginx@sha256:0123456789a",
+	}
+	for ref, want := range tests {
+		if got := normalizeImage(ref); got != want {
+			t.Errorf("normalizeImage(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes returned by the CLI, one per kind of failure
+const (
+	ExitOK        = 0
+	ExitFailure   = 1  // unexpected error
+	ExitInput     = 2  // invalid flag, argument or answer
+	ExitConfig    = 3  // configuration or profile
+	ExitContext   = 4  // Docker context unavailable or invalid
+	ExitRegistry  = 5  // registry login
+	ExitCompose   = 6  // compose file missing, invalid or not writable
+	ExitBuild     = 7  // build step
+	ExitPush      = 8  // push step
+	ExitDeploy    = 9  // deploy step
+	ExitCancelled = 10 // interrupted by SIGINT/SIGTERM
+	ExitTimeout   = 11 // a step exceeded its timeout
+	ExitHook      = 12 // a hook failed
+	ExitPreflight = 13 // a preflight check failed on the deploy context
+	ExitDrift     = 14 // the running containers differ from the deployed compose file
+	ExitLocked    = 15 // another run of the profile is in progress
+)
+
+// stepError carries the cause of a typed error
+type stepError struct {
+	err error
+}
+
+func (e stepError) Error() string { return e.err.Error() }
+func (e stepError) Unwrap() error { return e.err }
+
+// Typed errors, mapped to the exit codes by ExitCode
+type (
+	InputError     struct{ stepError }
+	ConfigError    struct{ stepError }
+	ContextError   struct{ stepError }
+	RegistryError  struct{ stepError }
+	ComposeError   struct{ stepError }
+	BuildError     struct{ stepError }
+	PushError      struct{ stepError }
+	DeployError    struct{ stepError }
+	CancelledError struct{ stepError }
+	TimeoutError   struct{ stepError }
+	HookError      struct{ stepError }
+	PreflightError struct{ stepError }
+	DriftError     struct{ stepError }
+	LockedError    struct{ stepError }
+)
+
+func inputErrorf(format string, a ...interface{}) error {
+	return &InputError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func configErrorf(format string, a ...interface{}) error {
+	return &ConfigError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func contextErrorf(format string, a ...interface{}) error {
+	return &ContextError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func registryErrorf(format string, a ...interface{}) error {
+	return &RegistryError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func composeErrorf(format string, a ...interface{}) error {
+	return &ComposeError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func buildErrorf(format string, a ...interface{}) error {
+	return &BuildError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func pushErrorf(format string, a ...interface{}) error {
+	return &PushError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func deployErrorf(format string, a ...interface{}) error {
+	return &DeployError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func hookErrorf(format string, a ...interface{}) error {
+	return &HookError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func preflightErrorf(format string, a ...interface{}) error {
+	return &PreflightError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func driftErrorf(format string, a ...interface{}) error {
+	return &DriftError{stepError{fmt.Errorf(format, a...)}}
+}
+
+func lockedErrorf(format string, a ...interface{}) error {
+	return &LockedError{stepError{fmt.Errorf(format, a...)}}
+}
+
+// FlagError is the cobra flag error function: invalid flags are input errors
+func FlagError(cmd *cobra.Command, err error) error {
+	return &InputError{stepError{err}}
+}
+
+// ExitCode returns the exit code matching the kind of err.
+// The outermost typed error of the chain wins.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		switch e.(type) {
+		case *InputError:
+			return ExitInput
+		case *ConfigError:
+			return ExitConfig
+		case *ContextError:
+			return ExitContext
+		case *RegistryError:
+			return ExitRegistry
+		case *ComposeError:
+			return ExitCompose
+		case *BuildError:
+			return ExitBuild
+		case *PushError:
+			return ExitPush
+		case *DeployError:
+			return ExitDeploy
+		case *CancelledError:
+			return ExitCancelled
+		case *TimeoutError:
+			return ExitTimeout
+		case *HookError:
+			return ExitHook
+		case *PreflightError:
+			return ExitPreflight
+		case *DriftError:
+			return ExitDrift
+		case *LockedError:
+			return ExitLocked
+		}
+	}
+	return ExitFailure
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, ExitOK},
+		{"untyped", errors.New("boom"), ExitFailure},
+		{"input", inputErrorf("bad flag"), ExitInput},
+		{"config", configErrorf("bad profile"), ExitConfig},
+		{"context", contextErrorf("unreachable"), ExitContext},
+		{"registry", registryErrorf("login"), ExitRegistry},
+		{"compose", composeErrorf("missing"), ExitCompose},
+		{"build", buildErrorf("build"), ExitBuild},
+		{"push", pushErrorf("push"), ExitPush},
+		{"deploy", deployErrorf("deploy"), ExitDeploy},
+		{"cancelled", &CancelledError{stepError{errors.New("cancelled")}}, ExitCancelled},
+		{"timeout", &TimeoutError{stepError{errors.New("timeout")}}, ExitTimeout},
+		{"hook", hookErrorf("hook"), ExitHook},
+		{"preflight", preflightErrorf("preflight"), ExitPreflight},
+		{"drift", driftErrorf("drift"), ExitDrift},
+		{"locked", lockedErrorf("locked"), ExitLocked},
+		{"flag", FlagError(nil, errors.New("unknown flag")), ExitInput},
+		{"wrapped", fmt.Errorf("step: %w", pushErrorf("push")), ExitPush},
+		{"outermost wins", hookErrorf("hook: %w", deployErrorf("deploy")), ExitHook},
+		{"typed cause of an untyped error", fmt.Errorf("a: %w", fmt.Errorf("b: %w", contextErrorf("c"))), ExitContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Hook stages of run-flow
+const (
+	HookPreBuild   = "pre-build"
+	HookPostBuild  = "post-build"
+	HookPrePush    = "pre-push"
+	HookPreDeploy  = "pre-deploy"
+	HookPostDeploy = "post-deploy"
+	HookOnFailure  = "on-failure"
+)
+
+var hookStages = []string{HookPreBuild, HookPostBuild, HookPrePush, HookPreDeploy, HookPostDeploy, HookOnFailure}
+
+// loadHooks merges the hooks of the profile with the --hook flags ("stage=command")
+func (f *flow) loadHooks() error {
+	f.hooks = make(map[string][]config.Hook)
+	for stage, hooks := range f.profile.Hooks {
+		f.hooks[stage] = append(f.hooks[stage], hooks...)
+	}
+	for _, raw := range runFlowHooks {
+		stage, command, ok := strings.Cut(raw, "=")
+		if !ok || strings.TrimSpace(command) == "" {
+			return inputErrorf("Invalid hook '%s': expected stage=command", raw)
+		}
+		f.hooks[stage] = append(f.hooks[stage], config.Hook{Command: command})
+	}
+
+	for stage, hooks := range f.hooks {
+		if !slices.Contains(hookStages, stage) {
+			return inputErrorf("Invalid hook stage '%s' (expected one of %s)", stage, strings.Join(hookStages, ", "))
+		}
+		for _, h := range hooks {
+			if (h.Command == "") == (h.Service == "") {
+				return inputErrorf("Invalid %s hook: exactly one of command or service is required", stage)
+			}
+		}
+	}
+	if len(f.hooks) == 0 {
+		f.hooks = nil
+	}
+	return nil
+}
+
+// runHooks executes the hooks of a stage in order.
+// A failing hook stops the flow unless it is marked continue_on_error.
+func (f *flow) runHooks(stage string) error {
+	return f.runHooksContext(f.ctx, stage, nil)
+}
+
+// runHooksContext executes the hooks of a stage with additional environment variables
+func (f *flow) runHooksContext(ctx context.Context, stage string, extraEnv []string) error {
+	hooks := f.hooks[stage]
+	if len(hooks) == 0 {
+		return nil
+	}
+	env := append(f.hookEnv(stage), extraEnv...)
+	for i, h := range hooks {
+		outf("==> Running %s hook %d/%d...\n", stage, i+1, len(hooks))
+		err := f.runHook(ctx, h, env)
+		if err == nil {
+			continue
+		}
+		if h.ContinueOnError {
+			outf("The %s hook failed, continuing: %v\n", stage, err)
+			continue
+		}
+		return hookErrorf("The %s hook failed: %w", stage, err)
+	}
+	return nil
+}
+
+// runHook executes one hook: a local shell command or a one-off compose service
+func (f *flow) runHook(ctx context.Context, h config.Hook, env []string) error {
+	var name string
+	var args []string
+	if h.Command != "" {
+		name, args = "sh", []string{"-c", h.Command}
+	} else {
+		// The generated compose is deleted when the build or the push fails
+		composePath := f.newComposePath
+		if _, err := os.Stat(composePath); composePath == "" || err != nil {
+			composePath = f.composeFile
+		}
+		if composePath == "" {
+			return fmt.Errorf("service '%s' can't run before the docker-compose is loaded", h.Service)
+		}
+		contextName := h.Context
+		if contextName == "" {
+			contextName = f.deployContext.Name
+		}
+		name = "docker"
+		args = []string{"--context", contextName, "compose", "-f", composePath}
+		if contextName == f.deployContext.Name {
+			args = append(args, f.projectArgs()...)
+		}
+		args = append(args, "run", "--rm")
+		// The variables are passed to the container, their values come from the environment
+		for _, kv := range env {
+			key, _, _ := strings.Cut(kv, "=")
+			args = append(args, "-e", key)
+		}
+		args = append(append(args, h.Service), h.Args...)
+	}
+
+	outf("=> Command: %s %s\n", name, strings.Join(args, " "))
+	start := time.Now()
+	cmd := commandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out()
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	emitCommand(name, args, start, err)
+	return err
+}
+
+// hookEnv returns the XPD_* variables describing the run
+func (f *flow) hookEnv(stage string) []string {
+	composePath := f.newComposePath
+	if composePath == "" {
+		composePath = f.absComposeFile
+	}
+	images := make([]string, 0, len(f.record.Images))
+	for svc, img := range f.record.Images {
+		images = append(images, svc+"="+img)
+	}
+	sort.Strings(images)
+
+	return []string{
+		"XPD_STAGE=" + stage,
+		"XPD_RUN_ID=" + f.record.ID,
+		"XPD_PROFILE=" + f.record.Profile,
+		"XPD_BUILD_CONTEXT=" + f.buildContext.Name,
+		"XPD_DEPLOY_CONTEXT=" + f.deployContext.Name,
+		"XPD_REGISTRY=" + f.registry,
+		"XPD_COMPOSE_FILE=" + composePath,
+		"XPD_SERVICES=" + strings.Join(f.record.Services, ","),
+		"XPD_TAG=" + f.tagChoice,
+		"XPD_IMAGES=" + strings.Join(images, ","),
+	}
+}
+
+// runFailureHooks executes the on-failure hooks after a failed step.
+// Their own failure is only reported, the error of the flow is kept.
+func (f *flow) runFailureHooks(step string, stepErr error) {
+	if len(f.hooks[HookOnFailure]) == 0 {
+		return
+	}
+	env := []string{
+		"XPD_FAILED_STEP=" + step,
+		"XPD_ERROR=" + stepErr.Error(),
+		"XPD_EXIT_CODE=" + fmt.Sprint(ExitCode(stepErr)),
+	}
+	if err := f.runHooksContext(appCtx, HookOnFailure, env); err != nil {
+		outln(err)
+	}
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+func TestLoadHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile map[string][]config.Hook
+		flags   []string
+		want    map[string]int
+		wantErr string
+	}{
+		{name: "none"},
+		{
+			name:    "the flags come after the profile",
+			profile: map[string][]config.Hook{HookPreDeploy: {{Service: "migrate"}}},
+			flags:   []string{"pre-deploy=./check.sh", "post-deploy=curl -f https://shop.example.com/health"},
+			want:    map[string]int{HookPreDeploy: 2, HookPostDeploy: 1},
+		},
+		{name: "unknown stage", flags: []string{"after-deploy=true"}, wantErr: "Invalid hook stage 'after-deploy'"},
+		{name: "no command", flags: []string{"pre-build= "}, wantErr: "expected stage=command"},
+		{name: "no stage", flags: []string{"make test"}, wantErr: "expected stage=command"},
+		{
+			name:    "command and service",
+			profile: map[string][]config.Hook{HookPostBuild: {{Command: "true", Service: "tests"}}},
+			wantErr: "exactly one of command or service",
+		},
+	}
+	saved := runFlowHooks
+	defer func() { runFlowHooks = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runFlowHooks = tt.flags
+			f := &flow{profile: config.Profile{Hooks: tt.profile}}
+			err := f.loadHooks()
+			if tt.wantErr != "" {
+				var inputErr *InputError
+				if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadHooks error = %v, want an input error %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadHooks: %v", err)
+			}
+			if (f.hooks == nil) != (tt.want == nil) || len(f.hooks) != len(tt.want) {
+				t.Fatalf("hooks = %v, want %v", f.hooks, tt.want)
+			}
+			for stage, n := range tt.want {
+				if len(f.hooks[stage]) != n {
+					t.Errorf("%d %s hooks, want %d", len(f.hooks[stage]), stage, n)
+				}
+			}
+			if tt.profile != nil && f.hooks[HookPreDeploy][0].Service != "migrate" {
+				t.Errorf("the hooks of the profile don't come first: %+v", f.hooks[HookPreDeploy])
+			}
+		})
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	discardStdout(t)
+	dir := t.TempDir()
+	trace := filepath.Join(dir, "trace")
+	f := &flow{
+		ctx:            context.Background(),
+		record:         &history.Record{ID: "run-1", Services: []string{"api", "web"}, Images: map[string]string{"web": "shop/web:1.2", "api": "shop/api:1.2"}},
+		deployContext:  config.DockerContext{Name: "prod"},
+		absComposeFile: "/app/docker-compose.yml",
+		tagChoice:      "1.2",
+		hooks: map[string][]config.Hook{
+			HookPostDeploy: {
+				{Command: `echo "$XPD_STAGE $XPD_RUN_ID $XPD_SERVICES $XPD_TAG $XPD_IMAGES" >> ` + shellQuote(trace)},
+				{Command: "exit 3", ContinueOnError: true},
+				{Command: "echo second >> " + shellQuote(trace)},
+			},
+			HookPreDeploy: {
+				{Command: "exit 4"},
+				{Command: "echo never >> " + shellQuote(trace)},
+			},
+			HookOnFailure: {
+				{Command: `echo "$XPD_FAILED_STEP $XPD_EXIT_CODE $XPD_ERROR" >> ` + shellQuote(trace)},
+			},
+		},
+	}
+
+	if err := f.runHooks(HookPostDeploy); err != nil {
+		t.Errorf("continue_on_error hook stopped the flow: %v", err)
+	}
+	err := f.runHooks(HookPreDeploy)
+	var hookErr *HookError
+	if !errors.As(err, &hookErr) || !strings.Contains(err.Error(), "pre-deploy hook failed") {
+		t.Errorf("runHooks error = %v, want a hook error", err)
+	}
+	if err := f.runHooks(HookPreBuild); err != nil {
+		t.Errorf("stage without hooks: %v", err)
+	}
+	f.runFailureHooks("deploy", deployErrorf("docker compose up failed"))
+
+	checkFile(t, dir, "trace", "post-deploy run-1 api,web 1.2 api=shop/api:1.2,web=shop/web:1.2\n"+
+		"second\n"+
+		"deploy 9 docker compose up failed\n")
+}
+
+func TestRunServiceHook(t *testing.T) {
+	discardStdout(t)
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args")
+	fakeDocker(t, `echo "$@" > `+shellQuote(log))
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": "services: {}\n"})
+	f := &flow{
+		ctx:            context.Background(),
+		record:         &history.Record{ID: "run-1"},
+		deployContext:  config.DockerContext{Name: "prod"},
+		composeFile:    filepath.Join(dir, "docker-compose.yml"),
+		absComposeFile: filepath.Join(dir, "docker-compose.yml"),
+		newComposePath: filepath.Join(dir, "deleted-tagged.yml"),
+		profile:        config.Profile{Project: "shop"},
+	}
+
+	// The generated compose was deleted: the original one is used
+	if err := f.runHook(f.ctx, config.Hook{Service: "migrate", Args: []string{"up"}}, []string{"XPD_TAG=1.2"}); err != nil {
+		t.Fatalf("runHook: %v", err)
+	}
+	want := "--context prod compose -f " + f.composeFile + " -p shop run --rm -e XPD_TAG migrate up\n"
+	checkFile(t, dir, "args", want)
+
+	// On another context, the project of the deployment is not used
+	if err := f.runHook(f.ctx, config.Hook{Service: "tests", Context: "ci"}, nil); err != nil {
+		t.Fatalf("runHook: %v", err)
+	}
+	checkFile(t, dir, "args", "--context ci compose -f "+f.composeFile+" run --rm tests\n")
+
+	f.composeFile, f.newComposePath = "", ""
+	if err := f.runHook(f.ctx, config.Hook{Service: "migrate"}, nil); err == nil {
+		t.Error("service hook ran before the compose file was loaded")
+	}
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/lint"
+)
+
+var (
+	lintProfile    string
+	lintProduction bool
+	lintPush       bool
+	lintStrict     bool
+)
+
+func init() {
+	LintCmd.Flags().StringVarP(&lintProfile, "profile", "p", "", "Lint the compose file of this profile, with its lint settings")
+	LintCmd.Flags().BoolVar(&lintProduction, "production", false, "Report the latest tags as errors")
+	LintCmd.Flags().BoolVar(&lintPush, "push", false, "The images are pushed: the built services need an image name (default with a profile that has a registry)")
+	LintCmd.Flags().BoolVar(&lintStrict, "strict", false, "Fail on warnings too")
+}
+
+// LintCmd checks a compose file before deploying it
+var LintCmd = &cobra.Command{
+	Use:   "lint [COMPOSE_FILE]",
+	Short: "Check a compose file against the compose schema and the deployment rules",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := "docker-compose.yml"
+		opts := lint.Options{Push: lintPush, Production: lintProduction}
+		var settings *config.LintConfig
+		if lintProfile != "" {
+			p, ok := config.FindProfile(lintProfile)
+			if !ok {
+				return configErrorf("Profile '%s' not found in the configuration.", lintProfile)
+			}
+			if p.ComposeFile != "" {
+				path = p.ComposeFile
+			}
+			opts.Push = opts.Push || p.Registry != ""
+			settings = p.Lint
+		}
+		if len(args) == 1 {
+			path = args[0]
+		}
+
+		findings, err := lintCompose(appCtx, path, opts, settings)
+		if err != nil {
+			return err
+		}
+		printFindings(findings)
+		emitResult(map[string]interface{}{
+			"compose_file": path,
+			"findings":     findings,
+			"errors":       lint.Count(findings, lint.SeverityError),
+			"warnings":     lint.Count(findings, lint.SeverityWarning),
+		})
+		return lintResult(findings, lintStrict)
+	},
+}
+
+// lint checks the compose file before anything is built
+func (f *flow) lint() error {
+	if runFlowNoLint {
+		outln("Lint skipped (--no-lint).")
+		return f.skip()
+	}
+	outln("==> Checking the docker-compose...")
+	opts := lint.Options{Push: f.registry != "", Tag: f.tagChoice}
+	findings, err := lintCompose(f.ctx, f.absComposeFile, opts, f.profile.Lint)
+	if err != nil {
+		return err
+	}
+	printFindings(findings)
+	return lintResult(findings, false)
+}
+
+// lintCompose runs the rules and the schema validation of docker compose,
+// then applies the settings of the profile
+func lintCompose(ctx context.Context, path string, opts lint.Options, settings *config.LintConfig) ([]lint.Finding, error) {
+	if settings != nil {
+		if err := lint.Validate(settings.Rules); err != nil {
+			return nil, configErrorf("Invalid lint settings: %w", err)
+		}
+		opts.Production = opts.Production || settings.Production
+	}
+	findings, err := lint.Check(path, opts)
+	if err != nil {
+		return nil, composeErrorf("Unable to read the docker-compose: %w", err)
+	}
+	// docker compose knows the full schema (and the interpolation of the variables)
+	if lint.Count(findings, lint.SeverityError) == 0 {
+		findings = append(findings, composeConfigFindings(ctx, path)...)
+	}
+	if settings != nil {
+		return lint.Apply(findings, settings.Rules), nil
+	}
+	lint.Sort(findings)
+	return findings, nil
+}
+
+// composeConfigFindings validates the file with "docker compose config":
+// a failure is a schema error, the warnings (obsolete version, unset variable...) are kept
+func composeConfigFindings(ctx context.Context, path string) []lint.Finding {
+	args := []string{"compose", "-f", path, "config", "-q"}
+	start := time.Now()
+	var stderr bytes.Buffer
+	cmd := commandContext(ctx, "docker", args...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	emitCommand("docker", args, start, err)
+
+	var findings []lint.Finding
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		severity := lint.SeverityWarning
+		if err != nil && !strings.Contains(strings.ToLower(line), "warn") {
+			severity = lint.SeverityError
+		}
+		findings = append(findings, lint.Finding{Rule: lint.RuleSchema, Severity: severity, Message: line})
+	}
+	if err != nil && lint.Count(findings, lint.SeverityError) == 0 {
+		findings = append(findings, lint.Finding{Rule: lint.RuleSchema, Severity: lint.SeverityError, Message: "docker compose config failed: " + err.Error()})
+	}
+	return findings
+}
+
+// printFindings prints one line per finding
+func printFindings(findings []lint.Finding) {
+	for _, f := range findings {
+		target := ""
+		if f.Service != "" {
+			target = f.Service + ": "
+		}
+		outf("%-7s [%s] %s%s\n", f.Severity, f.Rule, target, f.Message)
+	}
+	errs, warnings := lint.Count(findings, lint.SeverityError), lint.Count(findings, lint.SeverityWarning)
+	if len(findings) == 0 {
+		outln("No problem found.")
+	} else {
+		outf("%d error(s), %d warning(s).\n", errs, warnings)
+	}
+}
+
+// lintResult fails on errors (and on warnings when strict)
+func lintResult(findings []lint.Finding, strict bool) error {
+	errs, warnings := lint.Count(findings, lint.SeverityError), lint.Count(findings, lint.SeverityWarning)
+	if errs > 0 || (strict && warnings > 0) {
+		return composeErrorf("The docker-compose has %d error(s) and %d warning(s).", errs, warnings)
+	}
+	return nil
+}
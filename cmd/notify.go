@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+	"github.com/xpdemon/ac-deploy/notify"
+)
+
+// notifyStartStep is the step before which the start notification is sent:
+// the inputs are resolved and nothing has been built yet
+const notifyStartStep = "prune"
+
+var testWebhookProfile string
+
+func init() {
+	TestWebhookCmd.Flags().StringVarP(&testWebhookProfile, "profile", "p", "", "Also send to the webhooks of this profile")
+}
+
+// TestWebhookCmd sends a test notification to the configured webhooks
+var TestWebhookCmd = &cobra.Command{
+	Use:   "test-webhook",
+	Short: "Send a test notification to the configured webhooks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hooks := config.Cfg.Webhooks
+		if testWebhookProfile != "" {
+			p, ok := config.FindProfile(testWebhookProfile)
+			if !ok {
+				return configErrorf("Profile '%s' not found in the configuration.", testWebhookProfile)
+			}
+			hooks = append(append([]config.Webhook{}, hooks...), p.Webhooks...)
+		}
+		if len(hooks) == 0 {
+			return configErrorf("No webhooks are configured.")
+		}
+
+		n := notify.Notification{
+			Event:   notify.EventTest,
+			Time:    time.Now(),
+			RunID:   history.NewID(),
+			Profile: testWebhookProfile,
+		}
+		results := make(map[string]string)
+		failed := 0
+		for _, hook := range hooks {
+			if err := notify.Validate(hook); err != nil {
+				return configErrorf("Invalid webhook %s: %w", webhookName(hook), err)
+			}
+			if err := notify.Send(appCtx, hook, n); err != nil {
+				outf("Webhook %s: %v\n", webhookName(hook), err)
+				results[webhookName(hook)] = err.Error()
+				failed++
+				continue
+			}
+			outf("Webhook %s: OK\n", webhookName(hook))
+			results[webhookName(hook)] = "ok"
+		}
+		emitResult(map[string]interface{}{"webhooks": results})
+		if failed > 0 {
+			return fmt.Errorf("%d webhook(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+// loadWebhooks collects the global webhooks, those of the profile and the --webhook flags
+func (f *flow) loadWebhooks() error {
+	for _, raw := range runFlowWebhooks {
+		hook := config.Webhook{URL: raw}
+		if format, url, ok := strings.Cut(raw, "="); ok && !strings.Contains(format, "/") {
+			hook = config.Webhook{Format: format, URL: url}
+		}
+		f.profile.Webhooks = append(f.profile.Webhooks, hook)
+	}
+
+	f.webhooks = append(append([]config.Webhook{}, config.Cfg.Webhooks...), f.profile.Webhooks...)
+	for _, hook := range f.webhooks {
+		if err := notify.Validate(hook); err != nil {
+			return configErrorf("Invalid webhook %s: %w", webhookName(hook), err)
+		}
+	}
+	return nil
+}
+
+// notify sends a notification to the webhooks subscribed to the event.
+// A failing webhook is reported but never fails the flow.
+func (f *flow) notify(event, failedStep string, err error) {
+	n := notify.Notification{
+		Event:         event,
+		Time:          time.Now(),
+		RunID:         f.record.ID,
+		Status:        f.record.Status,
+		Profile:       f.record.Profile,
+		BuildContext:  f.buildContext.Name,
+		DeployContext: f.deployContext.Name,
+		Registry:      f.registry,
+		ComposeFile:   f.absComposeFile,
+		Services:      f.record.Services,
+		Tag:           f.tagChoice,
+		Images:        f.record.Images,
+		FailedStep:    failedStep,
+	}
+	if event == notify.EventStart {
+		n.Status = ""
+	} else {
+		n.Duration = time.Since(f.record.StartedAt).Seconds()
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+
+	for _, hook := range f.webhooks {
+		if !notify.Wants(hook, event) {
+			continue
+		}
+		// Not bound to the run: the notification of a cancelled run is still delivered
+		if sendErr := notify.Send(context.Background(), hook, n); sendErr != nil {
+			outf("Unable to notify webhook %s: %v\n", webhookName(hook), sendErr)
+		}
+	}
+	f.notified = true
+}
+
+// webhookName returns the name of a webhook, or its host when it has none
+func webhookName(hook config.Webhook) string {
+	if hook.Name != "" {
+		return hook.Name
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(hook.URL, "https://"), "http://")
+	host, _, _ = strings.Cut(host, "/")
+	return host
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// Output formats
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// Event types
+const (
+	EventStepStarted     = "step_started"
+	EventStepFinished    = "step_finished"
+	EventCommandExecuted = "command_executed"
+	EventResult          = "result"
+)
+
+// Event is one entry of the machine-readable event stream (--output json)
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Command string    `json:"command,omitempty"`
+	RunID   string    `json:"run_id,omitempty"`
+	Step    string    `json:"step,omitempty"`
+	// Status is "success", "failed" or "skipped" for finished steps
+	Status   string                 `json:"status,omitempty"`
+	Args     []string               `json:"args,omitempty"`
+	ExitCode *int                   `json:"exit_code,omitempty"`
+	Duration int64                  `json:"duration_ms,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	Data     map[string]interface{} `json:"data,omitempty"`
+}
+
+var (
+	outputFormat = OutputText
+	// currentCommand is the name of the running sub-command, added to every event
+	currentCommand string
+	// eventListeners receive every emitted event, whatever the output format
+	eventListeners []func(Event)
+)
+
+// AddOutputFlag registers the global --output flag on the root command
+func AddOutputFlag(root *cobra.Command) {
+	root.PersistentFlags().StringVarP(&outputFormat, "output", "o", OutputText, "Output format: text or json (JSON events on stdout, messages on stderr)")
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if outputFormat != OutputText && outputFormat != OutputJSON {
+			return inputErrorf("invalid output format '%s' (expected text or json)", outputFormat)
+		}
+		currentCommand = cmd.Name()
+		config.Output = out()
+		history.Output = out()
+		appCtx = cmd.Context()
+		return nil
+	}
+}
+
+// jsonOutput reports whether the events are written on stdout
+func jsonOutput() bool {
+	return outputFormat == OutputJSON
+}
+
+// out returns the writer for human-readable messages.
+// In JSON mode stdout is reserved to the events, so messages go to stderr.
+func out() io.Writer {
+	if jsonOutput() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// outf prints a formatted human-readable message
+func outf(format string, a ...interface{}) {
+	fmt.Fprintf(out(), format, a...)
+}
+
+// outln prints a human-readable message followed by a new line
+func outln(a ...interface{}) {
+	fmt.Fprintln(out(), a...)
+}
+
+// emit sends an event to the listeners and, in JSON mode, writes it on stdout
+func emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Command == "" {
+		e.Command = currentCommand
+	}
+	for _, listener := range eventListeners {
+		listener(e)
+	}
+	if !jsonOutput() {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode event: %v\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// emitResult emits the final result of a command
+func emitResult(data map[string]interface{}) {
+	emit(Event{Type: EventResult, Data: data})
+}
+
+// emitCommand emits the execution of an external command with its exit code and duration
+func emitCommand(name string, args []string, start time.Time, err error) {
+	exitCode := 0
+	if err != nil {
+		exitCode = -1 // the command could not be started
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		}
+	}
+	e := Event{
+		Type:     EventCommandExecuted,
+		Args:     append([]string{name}, args...),
+		ExitCode: &exitCode,
+		Duration: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	emit(e)
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// captureEvents collects the emitted events until the end of the test
+func captureEvents(t *testing.T) *[]Event {
+	t.Helper()
+	var events []Event
+	saved := eventListeners
+	eventListeners = append(slices.Clip(saved), func(e Event) { events = append(events, e) })
+	t.Cleanup(func() { eventListeners = saved })
+	return &events
+}
+
+func TestJSONOutput(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStdout, savedFormat, savedCommand := os.Stdout, outputFormat, currentCommand
+	defer func() { os.Stdout, outputFormat, currentCommand = savedStdout, savedFormat, savedCommand }()
+	os.Stdout, outputFormat, currentCommand = w, OutputJSON, "run-flow"
+
+	// The messages go to stderr, stdout only holds the events
+	if out() != os.Stderr {
+		t.Error("out() is not stderr in JSON mode")
+	}
+	emit(Event{Type: EventStepStarted, RunID: "20250101-120000.000", Step: "build"})
+	emitResult(map[string]interface{}{"status": "success"})
+	w.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("stdout = %q, want 2 events", data)
+	}
+	var events []Event
+	for _, line := range lines {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	if e := events[0]; e.Type != EventStepStarted || e.Step != "build" || e.Command != "run-flow" || e.Time.IsZero() {
+		t.Errorf("first event = %+v", e)
+	}
+	if e := events[1]; e.Type != EventResult || e.Data["status"] != "success" {
+		t.Errorf("second event = %+v", e)
+	}
+	// The empty fields are left out
+	if strings.Contains(lines[0], "exit_code") || strings.Contains(lines[0], "data") {
+		t.Errorf("event %s has empty fields", lines[0])
+	}
+}
+
+func TestEmitCommand(t *testing.T) {
+	events := captureEvents(t)
+	start := time.Now()
+	emitCommand("sh", []string{"-c", "exit 0"}, start, exec.Command("sh", "-c", "exit 0").Run())
+	emitCommand("sh", []string{"-c", "exit 3"}, start, exec.Command("sh", "-c", "exit 3").Run())
+	emitCommand("missing-command", nil, start, exec.Command("missing-command-xpdemon").Run())
+
+	want := []int{0, 3, -1}
+	if len(*events) != len(want) {
+		t.Fatalf("%d events, want %d", len(*events), len(want))
+	}
+	for i, e := range *events {
+		if e.Type != EventCommandExecuted || e.ExitCode == nil || *e.ExitCode != want[i] {
+			t.Errorf("event %d = %+v, want exit code %d", i, e, want[i])
+		}
+		if (e.Error != "") != (want[i] != 0) {
+			t.Errorf("event %d error = %q", i, e.Error)
+		}
+	}
+	if got := (*events)[1].Args; !slices.Equal(got, []string{"sh", "-c", "exit 3"}) {
+		t.Errorf("args = %v", got)
+	}
+}
+
+func TestRunStepEvents(t *testing.T) {
+	events := captureEvents(t)
+	f := &flow{record: &history.Record{ID: "run-1"}}
+	steps := []flowStep{
+		{"build", func() error { return nil }},
+		{"push", f.skip},
+		{"deploy", func() error { return deployErrorf("docker compose up failed") }},
+	}
+	for _, step := range steps {
+		f.runStep(step)
+	}
+
+	var got []string
+	for _, e := range *events {
+		if e.RunID != "run-1" {
+			t.Errorf("event %+v has no run ID", e)
+		}
+		got = append(got, e.Type+":"+e.Step+":"+e.Status)
+	}
+	want := []string{
+		"step_started:build:", "step_finished:build:success",
+		"step_started:push:", "step_finished:push:skipped",
+		"step_started:deploy:", "step_finished:deploy:failed",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	if last := (*events)[len(*events)-1]; !strings.Contains(last.Error, "docker compose up failed") {
+		t.Errorf("failed step error = %q", last.Error)
+	}
+
+	var stepErr *DeployError
+	if err := f.runStep(steps[2]); !errors.As(err, &stepErr) {
+		t.Errorf("runStep error = %T, want the error of the step", err)
+	}
+}
@@ -0,0 +1,514 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xpdemon/ac-deploy/compose"
+)
+
+// Preflight checks, run on the deploy context before deploying
+const (
+	CheckVersions  = "versions"
+	CheckDisk      = "disk"
+	CheckPorts     = "ports"
+	CheckResources = "resources"
+	CheckRegistry  = "registry"
+)
+
+var preflightChecks = []string{CheckVersions, CheckDisk, CheckPorts, CheckResources, CheckRegistry}
+
+// Status of a preflight check
+const (
+	preflightOK      = "ok"
+	preflightWarning = "warning"
+	preflightFailed  = "error"
+)
+
+// preflightDiskReserve is the free space that must remain on the deploy host
+// once the missing images are pulled
+const preflightDiskReserve = 1 << 30
+
+// preflightResult is the outcome of one check
+type preflightResult struct {
+	Check   string
+	Status  string
+	Message string
+}
+
+// versionRequirement is a compose feature that needs a recent Docker Engine or Docker Compose
+type versionRequirement struct {
+	feature string
+	engine  string
+	compose string
+	used    func(raw map[string]interface{}) bool
+}
+
+var versionRequirements = []versionRequirement{
+	{feature: "env_file with path/required", compose: "2.24.0", used: func(raw map[string]interface{}) bool {
+		return anyService(raw, func(svc map[string]interface{}) bool {
+			items, _ := svc["env_file"].([]interface{})
+			for _, item := range items {
+				if _, ok := item.(map[string]interface{}); ok {
+					return true
+				}
+			}
+			return false
+		})
+	}},
+	{feature: "depends_on with required", compose: "2.20.0", used: func(raw map[string]interface{}) bool {
+		return anyService(raw, func(svc map[string]interface{}) bool {
+			deps, _ := svc["depends_on"].(map[string]interface{})
+			for _, dep := range deps {
+				if d, ok := dep.(map[string]interface{}); ok && d["required"] != nil {
+					return true
+				}
+			}
+			return false
+		})
+	}},
+	{feature: "include", compose: "2.20.0", used: func(raw map[string]interface{}) bool {
+		return raw["include"] != nil
+	}},
+	{feature: "healthcheck start_interval", engine: "25.0.0", used: func(raw map[string]interface{}) bool {
+		return anyService(raw, func(svc map[string]interface{}) bool {
+			hc, _ := svc["healthcheck"].(map[string]interface{})
+			return hc["start_interval"] != nil
+		})
+	}},
+}
+
+var versionRegexp = regexp.MustCompile(`(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// validatePreflightSkip checks the names of the skipped checks
+func validatePreflightSkip(skip []string) error {
+	for _, name := range skip {
+		if name != "all" && !slices.Contains(preflightChecks, name) {
+			return fmt.Errorf("unknown preflight check '%s' (expected one of %s, or all)", name, strings.Join(preflightChecks, ", "))
+		}
+	}
+	return nil
+}
+
+// preflight verifies that the deploy context can run the deployment:
+// versions, free disk space, host ports, external networks/volumes and registry access.
+// Every check runs, then the run fails if one of them failed.
+func (f *flow) preflight() error {
+	var checks []string
+	for _, check := range preflightChecks {
+		if !slices.Contains(f.preflightSkip, check) && !slices.Contains(f.preflightSkip, "all") {
+			checks = append(checks, check)
+		}
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	outf("==> Preflight checks on '%s'...\n", f.deployContext.Name)
+
+	run := map[string]func() []preflightResult{
+		CheckVersions:  f.checkVersions,
+		CheckDisk:      f.checkDisk,
+		CheckPorts:     f.checkPorts,
+		CheckResources: f.checkResources,
+		CheckRegistry:  f.checkRegistry,
+	}
+	failed := 0
+	for _, check := range checks {
+		for _, r := range run[check]() {
+			outf("  %-7s %-9s %s\n", r.Status, r.Check, r.Message)
+			if r.Status == preflightFailed {
+				failed++
+			}
+		}
+		if f.ctx.Err() != nil {
+			return f.ctx.Err()
+		}
+	}
+	if failed > 0 {
+		return preflightErrorf("%d preflight error(s) on '%s' (--skip-preflight CHECK to bypass)", failed, f.deployContext.Name)
+	}
+	return nil
+}
+
+// probe runs a command bounded by the context probe timeout and returns its trimmed output
+func (f *flow) probe(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(f.ctx, contextProbeTimeout)
+	defer cancel()
+	output, err := commandOutput(ctx, name, args...)
+	return strings.TrimSpace(output), err
+}
+
+// probeDocker runs a docker command on the deploy context
+func (f *flow) probeDocker(args ...string) (string, error) {
+	return f.probe("docker", append([]string{"--context", f.deployContext.Name}, args...)...)
+}
+
+// checkVersions compares the versions of the engine and of compose with the features of the file
+func (f *flow) checkVersions() []preflightResult {
+	result := func(status, format string, a ...interface{}) []preflightResult {
+		return []preflightResult{{Check: CheckVersions, Status: status, Message: fmt.Sprintf(format, a...)}}
+	}
+	engine, err := f.probeDocker("version", "--format", "{{.Server.Version}}")
+	if err != nil || versionRegexp.FindString(engine) == "" {
+		return result(preflightWarning, "unable to read the version of the Docker Engine")
+	}
+	// compose runs on this machine, as a plugin of the docker CLI
+	composeVersion, err := f.probe("docker", "compose", "version", "--short")
+	if err != nil || versionRegexp.FindString(composeVersion) == "" {
+		return result(preflightWarning, "unable to read the version of Docker Compose")
+	}
+	engine, composeVersion = versionRegexp.FindString(engine), versionRegexp.FindString(composeVersion)
+
+	data, err := os.ReadFile(f.newComposePath)
+	if err != nil {
+		return result(preflightWarning, "unable to read the docker-compose: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return result(preflightWarning, "unable to read the docker-compose: %v", err)
+	}
+
+	var results []preflightResult
+	for _, req := range versionRequirements {
+		if !req.used(raw) {
+			continue
+		}
+		if req.engine != "" && !versionAtLeast(engine, req.engine) {
+			results = append(results, result(preflightFailed, "%s needs Docker Engine %s or later (the deploy host runs %s)", req.feature, req.engine, engine)...)
+		}
+		if req.compose != "" && !versionAtLeast(composeVersion, req.compose) {
+			results = append(results, result(preflightFailed, "%s needs Docker Compose %s or later (this machine runs %s)", req.feature, req.compose, composeVersion)...)
+		}
+	}
+	if len(results) > 0 {
+		return results
+	}
+	return result(preflightOK, "Docker Engine %s, Docker Compose %s", engine, composeVersion)
+}
+
+// checkDisk compares the free space of the Docker root directory with the size of the
+// images that are not on the deploy host yet (measured on the build context)
+func (f *flow) checkDisk() []preflightResult {
+	result := func(status, format string, a ...interface{}) []preflightResult {
+		return []preflightResult{{Check: CheckDisk, Status: status, Message: fmt.Sprintf(format, a...)}}
+	}
+	remote, err := remoteShell(f.deployContext.Host)
+	if err != nil {
+		return result(preflightWarning, "free space not checked: %v", err)
+	}
+	root, err := f.probeDocker("info", "--format", "{{.DockerRootDir}}")
+	if err != nil || !strings.HasPrefix(root, "/") {
+		root = "/var/lib/docker"
+	}
+	name, args := remote("df -Pk " + shellQuote(root) + " | tail -n 1")
+	output, err := f.probe(name, args...)
+	fields := strings.Fields(output)
+	if err != nil || len(fields) < 4 {
+		return result(preflightWarning, "unable to read the free space of %s", root)
+	}
+	availableKB, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return result(preflightWarning, "unable to read the free space of %s", root)
+	}
+	available := availableKB * 1024
+
+	// Images already on the deploy host take no more space
+	present := make(map[string]bool)
+	if list, err := f.probeDocker("image", "ls", "--format", "{{.Repository}}:{{.Tag}}"); err == nil {
+		for _, image := range strings.Fields(list) {
+			present[image] = true
+		}
+	}
+	var needed int64
+	var missing, unknown []string
+	for _, image := range sortedValues(f.record.Images) {
+		if present[image] || present[image+":latest"] || slices.Contains(missing, image) {
+			continue
+		}
+		missing = append(missing, image)
+		size, err := f.probe("docker", "--context", f.buildContext.Name, "image", "inspect", "--format", "{{.Size}}", image)
+		n, convErr := strconv.ParseInt(size, 10, 64)
+		if err != nil || convErr != nil {
+			unknown = append(unknown, image)
+			continue
+		}
+		needed += n
+	}
+
+	if available < needed+preflightDiskReserve {
+		return result(preflightFailed, "%s free on %s, the %d missing image(s) need about %s (plus %s kept free)",
+			humanSize(available), root, len(missing), humanSize(needed), humanSize(preflightDiskReserve))
+	}
+	results := result(preflightOK, "%s free on %s, %d image(s) to pull (about %s)", humanSize(available), root, len(missing), humanSize(needed))
+	if len(unknown) > 0 {
+		results = append(results, result(preflightWarning, "size unknown for %s (not on the build context)", strings.Join(unknown, ", "))...)
+	}
+	return results
+}
+
+// hostPort is a published port: the empty ip means every interface
+type hostPort struct {
+	ip    string
+	port  int
+	proto string
+}
+
+// checkPorts finds the host ports of the services already used by the containers of other projects
+func (f *flow) checkPorts() []preflightResult {
+	result := func(status, format string, a ...interface{}) preflightResult {
+		return preflightResult{Check: CheckPorts, Status: status, Message: fmt.Sprintf(format, a...)}
+	}
+	wanted := make(map[string][]hostPort)
+	count := 0
+	for _, svc := range f.allServices {
+		if len(f.targetServices) > 0 && !slices.Contains(f.targetServices, svc.Name) {
+			continue
+		}
+		for _, p := range svc.Ports {
+			ports, err := parseHostPorts(p.HostIP, p.Published, p.Protocol)
+			if err != nil {
+				return []preflightResult{result(preflightWarning, "%s: %v", svc.Name, err)}
+			}
+			wanted[svc.Name] = append(wanted[svc.Name], ports...)
+			count += len(ports)
+		}
+	}
+	if count == 0 {
+		return []preflightResult{result(preflightOK, "no fixed host port")}
+	}
+
+	output, err := f.probeDocker("ps", "--format", `{{.Names}}|{{.Label "com.docker.compose.project"}}|{{.Ports}}`)
+	if err != nil {
+		return []preflightResult{result(preflightWarning, "unable to list the running containers")}
+	}
+	// The containers of this project are replaced by the deployment.
+	// In blue-green, only the inactive color is: the active one keeps its ports until the switch.
+	own := f.projectName()
+	if f.strategy == StrategyBlueGreen {
+		base := f.blueGreenProject()
+		state, err := f.loadBlueGreenState(base)
+		if err != nil {
+			return []preflightResult{result(preflightWarning, "unable to read the blue-green state: %v", err)}
+		}
+		own = base + "-" + otherColor(state.Active)
+	}
+
+	var results []preflightResult
+	reported := make(map[string]bool) // IPv4 and IPv6 bindings of the same port
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(line, "|", 3)
+		if len(parts) != 3 || parts[1] == own {
+			continue
+		}
+		owner := "not managed by compose"
+		if parts[1] != "" {
+			owner = "project '" + parts[1] + "'"
+		}
+		for _, used := range dockerPsPorts(parts[2]) {
+			for _, svc := range sortedKeys(wanted) {
+				for _, p := range wanted[svc] {
+					key := fmt.Sprintf("%s/%s/%d/%s", parts[0], svc, p.port, p.proto)
+					if p.port == used.port && p.proto == used.proto && (p.ip == "" || used.ip == "" || p.ip == used.ip) && !reported[key] {
+						reported[key] = true
+						results = append(results, result(preflightFailed, "host port %d/%s of '%s' is used by the container '%s' (%s)", p.port, p.proto, svc, parts[0], owner))
+					}
+				}
+			}
+		}
+	}
+	if len(results) > 0 {
+		return results
+	}
+	return []preflightResult{result(preflightOK, "%d host port(s) free", count)}
+}
+
+// parseHostPorts expands a published port or range ("8080", "8000-8005")
+func parseHostPorts(ip, published, proto string) ([]hostPort, error) {
+	if proto == "" {
+		proto = "tcp"
+	}
+	if ip == "0.0.0.0" || ip == "::" || ip == "[::]" {
+		ip = ""
+	}
+	first, last, isRange := strings.Cut(published, "-")
+	start, err := strconv.Atoi(first)
+	end := start
+	if err == nil && isRange {
+		end, err = strconv.Atoi(last)
+	}
+	if err != nil || start <= 0 || end < start || end > 65535 {
+		return nil, fmt.Errorf("invalid host port '%s'", published)
+	}
+	var ports []hostPort
+	for port := start; port <= end; port++ {
+		ports = append(ports, hostPort{ip: ip, port: port, proto: proto})
+	}
+	return ports, nil
+}
+
+// dockerPsPorts parses the ports column of docker ps:
+// "0.0.0.0:8080->80/tcp, :::8080->80/tcp, 5432/tcp"
+func dockerPsPorts(column string) []hostPort {
+	var ports []hostPort
+	for _, item := range strings.Split(column, ",") {
+		host, container, ok := strings.Cut(strings.TrimSpace(item), "->")
+		if !ok {
+			continue // exposed, not published
+		}
+		_, proto, _ := strings.Cut(container, "/")
+		i := strings.LastIndex(host, ":")
+		if i < 0 {
+			continue
+		}
+		parsed, err := parseHostPorts(host[:i], host[i+1:], proto)
+		if err == nil {
+			ports = append(ports, parsed...)
+		}
+	}
+	return ports
+}
+
+// checkResources verifies that the external networks and volumes exist on the deploy context
+func (f *flow) checkResources() []preflightResult {
+	networks, volumes, err := compose.ParseExternals(f.newComposePath)
+	if err != nil {
+		return []preflightResult{{Check: CheckResources, Status: preflightWarning, Message: fmt.Sprintf("unable to read the docker-compose: %v", err)}}
+	}
+	if len(networks)+len(volumes) == 0 {
+		return []preflightResult{{Check: CheckResources, Status: preflightOK, Message: "no external network or volume"}}
+	}
+
+	var results []preflightResult
+	for _, kind := range []struct {
+		name  string
+		names []string
+	}{{"network", networks}, {"volume", volumes}} {
+		if len(kind.names) == 0 {
+			continue
+		}
+		output, err := f.probeDocker(kind.name, "ls", "--format", "{{.Name}}")
+		if err != nil {
+			results = append(results, preflightResult{Check: CheckResources, Status: preflightWarning, Message: fmt.Sprintf("unable to list the %ss", kind.name)})
+			continue
+		}
+		existing := strings.Fields(output)
+		for _, name := range kind.names {
+			if !slices.Contains(existing, name) {
+				results = append(results, preflightResult{Check: CheckResources, Status: preflightFailed,
+					Message: fmt.Sprintf("external %s '%s' not found (docker %s create %s)", kind.name, name, kind.name, name)})
+			}
+		}
+	}
+	if len(results) > 0 {
+		return results
+	}
+	return []preflightResult{{Check: CheckResources, Status: preflightOK,
+		Message: fmt.Sprintf("%d external network(s) and %d volume(s) found", len(networks), len(volumes))}}
+}
+
+// checkRegistry verifies that the deploy host reaches the registry it pulls the images from
+// (any HTTP answer of /v2/, 401 included, means reachable)
+func (f *flow) checkRegistry() []preflightResult {
+	result := func(status, format string, a ...interface{}) []preflightResult {
+		return []preflightResult{{Check: CheckRegistry, Status: status, Message: fmt.Sprintf(format, a...)}}
+	}
+	if f.registry == "" || !f.record.Pushed {
+		return result(preflightOK, "no image pulled from a registry")
+	}
+	remote, err := remoteShell(f.deployContext.Host)
+	if err != nil {
+		return result(preflightWarning, "registry access not checked: %v", err)
+	}
+	host := registryHost(f.registry)
+	curl := "curl -s -o /dev/null -w '%{http_code}' --max-time 10 "
+	name, args := remote("command -v curl >/dev/null 2>&1 || { echo none; exit 0; }; " +
+		curl + shellQuote("https://"+host+"/v2/") + " || " + curl + shellQuote("http://"+host+"/v2/") + "; true")
+	output, err := f.probe(name, args...)
+	switch {
+	case err != nil:
+		return result(preflightWarning, "unable to reach the deploy host: %v", err)
+	case output == "none":
+		return result(preflightWarning, "registry access not checked (curl not installed on the deploy host)")
+	case len(output) < 3 || strings.HasSuffix(output, "000"):
+		return result(preflightFailed, "the deploy host can't reach the registry %s", host)
+	}
+	return result(preflightOK, "%s reachable (HTTP %s)", host, output[len(output)-3:])
+}
+
+// registryHost returns the host of a registry ("my-registry.com/user" => "my-registry.com").
+// A Docker Hub namespace has no host.
+func registryHost(registry string) string {
+	host, _, _ := strings.Cut(registry, "/")
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "registry-1.docker.io"
+	}
+	return host
+}
+
+// versionAtLeast compares two "major.minor[.patch]" versions
+func versionAtLeast(have, want string) bool {
+	a, b := versionRegexp.FindStringSubmatch(have), versionRegexp.FindStringSubmatch(want)
+	if a == nil || b == nil {
+		return false
+	}
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(a[i])
+		y, _ := strconv.Atoi(b[i])
+		if x != y {
+			return x > y
+		}
+	}
+	return true
+}
+
+// humanSize formats a number of bytes (1.5GB)
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// anyService reports whether one of the services of a raw compose file matches
+func anyService(raw map[string]interface{}, match func(svc map[string]interface{}) bool) bool {
+	services, _ := raw["services"].(map[string]interface{})
+	for _, svc := range services {
+		if m, ok := svc.(map[string]interface{}); ok && match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedValues returns the values of a map, ordered by key
+func sortedValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		values = append(values, m[k])
+	}
+	return values
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+func TestParseHostPorts(t *testing.T) {
+	tests := []struct {
+		ip, published, proto string
+		want                 []hostPort
+		wantErr              bool
+	}{
+		{published: "8080", want: []hostPort{{port: 8080, proto: "tcp"}}},
+		{ip: "127.0.0.1", published: "53", proto: "udp", want: []hostPort{{ip: "127.0.0.1", port: 53, proto: "udp"}}},
+		{ip: "0.0.0.0", published: "80", want: []hostPort{{port: 80, proto: "tcp"}}},
+		{ip: "::", published: "80", want: []hostPort{{port: 80, proto: "tcp"}}},
+		{published: "8000-8002", want: []hostPort{{port: 8000, proto: "tcp"}, {port: 8001, proto: "tcp"}, {port: 8002, proto: "tcp"}}},
+		{published: "${PORT}", wantErr: true},
+		{published: "0", wantErr: true},
+		{published: "70000", wantErr: true},
+		{published: "8005-8000", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseHostPorts(tt.ip, tt.published, tt.proto)
+		if (err != nil) != tt.wantErr || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseHostPorts(%q, %q, %q) = %v, %v, want %v", tt.ip, tt.published, tt.proto, got, err, tt.want)
+		}
+	}
+}
+
+func TestDockerPsPorts(t *testing.T) {
+	tests := []struct {
+		column string
+		want   []hostPort
+	}{
+		{"", nil},
+		{"5432/tcp", nil},
+		{"0.0.0.0:8080->80/tcp, :::8080->80/tcp", []hostPort{{port: 8080, proto: "tcp"}, {port: 8080, proto: "tcp"}}},
+		{"127.0.0.1:53->53/udp, 9000/tcp", []hostPort{{ip: "127.0.0.1", port: 53, proto: "udp"}}},
+		{"[::1]:8443->443/tcp", []hostPort{{ip: "[::1]", port: 8443, proto: "tcp"}}},
+		{"0.0.0.0:7000-7001->7000-7001/tcp", []hostPort{{port: 7000, proto: "tcp"}, {port: 7001, proto: "tcp"}}},
+	}
+	for _, tt := range tests {
+		if got := dockerPsPorts(tt.column); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("dockerPsPorts(%q) = %v, want %v", tt.column, got, tt.want)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		have, want string
+		ok         bool
+	}{
+		{"2.24.0", "2.24.0", true},
+		{"v2.29.7-desktop.1", "2.24.0", true},
+		{"2.23.3", "2.24.0", false},
+		{"27.3", "25.0.0", true},
+		{"24.0.9", "25.0.0", false},
+		{"2.100.0", "2.24.0", true},
+		{"dev", "2.24.0", false},
+	}
+	for _, tt := range tests {
+		if got := versionAtLeast(tt.have, tt.want); got != tt.ok {
+			t.Errorf("versionAtLeast(%q, %q) = %v, want %v", tt.have, tt.want, got, tt.ok)
+		}
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"my-registry.com/user": "my-registry.com",
+		"my-registry.com":      "my-registry.com",
+		"localhost:5000/team":  "localhost:5000",
+		"localhost":            "localhost",
+		"xpdemon":              "registry-1.docker.io",
+	}
+	for registry, want := range tests {
+		if got := registryHost(registry); got != want {
+			t.Errorf("registryHost(%q) = %q, want %q", registry, got, want)
+		}
+	}
+}
+
+func TestHumanSize(t *testing.T) {
+	tests := map[int64]string{512: "512B", 1536: "1.5KB", 1 << 30: "1.0GB", 5 * 1 << 40: "5.0TB"}
+	for n, want := range tests {
+		if got := humanSize(n); got != want {
+			t.Errorf("humanSize(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestValidatePreflightSkip(t *testing.T) {
+	if err := validatePreflightSkip([]string{CheckDisk, CheckRegistry, "all"}); err != nil {
+		t.Errorf("validatePreflightSkip: %v", err)
+	}
+	if err := validatePreflightSkip([]string{"dns"}); err == nil || !strings.Contains(err.Error(), "'dns'") {
+		t.Errorf("validatePreflightSkip with an unknown check = %v", err)
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	discardStdout(t)
+	fakeDocker(t, `printf '%s\n' 'shop-web-1|shop|0.0.0.0:80->80/tcp' 'blog-web-1|blog|0.0.0.0:8080->80/tcp, :::8080->80/tcp' 'dns||127.0.0.1:53->53/udp'`)
+	f := &flow{
+		ctx:            context.Background(),
+		deployContext:  config.DockerContext{Name: "prod"},
+		absComposeFile: "/app/docker-compose.yml",
+		profile:        config.Profile{Project: "shop"},
+		allServices: []compose.Service{
+			{Name: "web", Ports: []compose.PortConfig{{Published: "80"}, {Published: "8080"}}},
+			{Name: "dns", Ports: []compose.PortConfig{{HostIP: "127.0.0.2", Published: "53", Protocol: "udp"}}},
+		},
+	}
+	results := f.checkPorts()
+	// Port 80 is held by the project itself, 53 is bound to another address
+	if len(results) != 1 || results[0].Status != preflightFailed ||
+		results[0].Message != "host port 8080/tcp of 'web' is used by the container 'blog-web-1' (project 'blog')" {
+		t.Errorf("checkPorts = %+v", results)
+	}
+
+	f.targetServices = []string{"dns"}
+	if results := f.checkPorts(); len(results) != 1 || results[0].Status != preflightOK {
+		t.Errorf("checkPorts of the target services = %+v", results)
+	}
+}
+
+func TestCheckVersions(t *testing.T) {
+	discardStdout(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": `services:
+  api:
+    env_file: [{path: .env, required: false}]
+    healthcheck:
+      start_interval: 1s
+`})
+	f := &flow{ctx: context.Background(), deployContext: config.DockerContext{Name: "prod"}, newComposePath: filepath.Join(dir, "docker-compose.yml")}
+
+	fakeDocker(t, `case "$*" in *"compose version"*) echo 2.29.1 ;; *) echo 27.1.1 ;; esac`)
+	if results := f.checkVersions(); len(results) != 1 || results[0].Status != preflightOK {
+		t.Errorf("checkVersions = %+v", results)
+	}
+
+	fakeDocker(t, `case "$*" in *"compose version"*) echo v2.21.0 ;; *) echo 24.0.7 ;; esac`)
+	var messages []string
+	for _, r := range f.checkVersions() {
+		messages = append(messages, r.Status+": "+r.Message)
+	}
+	want := []string{
+		"error: env_file with path/required needs Docker Compose 2.24.0 or later (this machine runs 2.21.0)",
+		"error: healthcheck start_interval needs Docker Engine 25.0.0 or later (the deploy host runs 24.0.7)",
+	}
+	if strings.Join(messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("checkVersions = %q, want %q", messages, want)
+	}
+}
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// loadProfile applies the profile given with --profile (or the answers of the run
+// given with --resume) and the buildx flags
+func (f *flow) loadProfile() error {
+	if runFlowResume != "" {
+		if err := f.loadResume(); err != nil {
+			return err
+		}
+	} else if runFlowProfile != "" {
+		p, ok := config.FindProfile(runFlowProfile)
+		if !ok {
+			return configErrorf("Profile '%s' not found in the configuration.", runFlowProfile)
+		}
+		f.profile = *p
+		f.record.Profile = p.Name
+		outf("==> Using profile '%s'\n", p.Name)
+	}
+	if err := f.lockProfile(); err != nil {
+		return err
+	}
+
+	// Buildx is enabled by the profile or by the flags, the flags win
+	if f.profile.Buildx != nil {
+		bx := *f.profile.Buildx
+		f.buildx = &bx
+	}
+	if runFlowBuildx || len(runFlowPlatforms) > 0 || len(runFlowBuildxNodes) > 0 {
+		if f.buildx == nil {
+			f.buildx = &config.BuildxConfig{}
+		}
+		if len(runFlowPlatforms) > 0 {
+			f.buildx.Platforms = runFlowPlatforms
+		}
+		if len(runFlowBuildxNodes) > 0 {
+			f.buildx.Nodes = runFlowBuildxNodes
+		}
+	}
+
+	// Build cache: the flags override the profile
+	if f.profile.BuildCache != nil {
+		f.cache = *f.profile.BuildCache
+	}
+	if len(runFlowCacheFrom) > 0 {
+		f.cache.CacheFrom = runFlowCacheFrom
+	}
+	if len(runFlowCacheTo) > 0 {
+		f.cache.CacheTo = runFlowCacheTo
+	}
+	if runFlowPruneUntil != "" {
+		f.cache.PruneUntil = runFlowPruneUntil
+	}
+	if runFlowPruneKeep != "" {
+		f.cache.PruneKeepStorage = runFlowPruneKeep
+	}
+	if runFlowCacheReport {
+		f.cache.Report = true
+	}
+
+	// Retries: global configuration < profile < flags
+	if err := f.loadRetry(); err != nil {
+		return err
+	}
+
+	// Hooks: the --hook flags are added to the hooks of the profile
+	if err := f.loadHooks(); err != nil {
+		return err
+	}
+	if err := f.loadWebhooks(); err != nil {
+		return err
+	}
+	if err := f.loadProject(); err != nil {
+		return err
+	}
+	if err := f.loadDeployConfig(); err != nil {
+		return err
+	}
+
+	// Step timeouts: the flags override the profile, step by step
+	return f.loadTimeouts()
+}
+
+// lockProfile prevents two runs of the same profile from deploying at the same time
+func (f *flow) lockProfile() error {
+	if f.record.Profile == "" {
+		return nil
+	}
+	unlock, err := history.Lock(profileLockName(f.record.Profile))
+	var locked *history.LockedError
+	if errors.As(err, &locked) {
+		return lockedErrorf("Profile '%s' is already being run by the process %d.", f.record.Profile, locked.PID)
+	}
+	if err != nil {
+		return configErrorf("Unable to lock the profile '%s': %w", f.record.Profile, err)
+	}
+	f.unlock = unlock
+	return nil
+}
+
+// profileLockName is the name of the lock held by the runs of a profile
+func profileLockName(profile string) string {
+	return "profile-" + profile
+}
+
+// loadRetry resolves the retry policy of the network operations
+func (f *flow) loadRetry() error {
+	if runFlowRetries != 0 || runFlowRetryDelay != 0 {
+		r := config.RetryConfig{}
+		if f.profile.Retry != nil {
+			r = *f.profile.Retry
+		}
+		if runFlowRetries != 0 {
+			r.Attempts = runFlowRetries
+		}
+		if runFlowRetryDelay != 0 {
+			r.Delay = runFlowRetryDelay.String()
+		}
+		f.profile.Retry = &r
+	}
+
+	policy, err := defaultRetryPolicy()
+	if err == nil {
+		policy, err = policy.with(f.profile.Retry)
+	}
+	if err != nil {
+		return inputErrorf("Invalid retry settings: %w", err)
+	}
+	f.retry = policy
+	return nil
+}
+
+// loadTimeouts parses the step timeouts of the profile and of --timeout
+func (f *flow) loadTimeouts() error {
+	specs := make(map[string]string)
+	for step, d := range f.profile.Timeouts {
+		specs[step] = d
+	}
+	for step, d := range runFlowTimeouts {
+		specs[step] = d
+	}
+
+	f.timeouts = make(map[string]time.Duration)
+	for step, spec := range specs {
+		if !slices.Contains(f.steps, step) {
+			return inputErrorf("Invalid timeout: unknown step '%s' (expected one of %s)", step, strings.Join(f.steps, ", "))
+		}
+		d, err := time.ParseDuration(spec)
+		if err != nil || d <= 0 {
+			return inputErrorf("Invalid timeout for step '%s': '%s' is not a positive duration (e.g., 10m)", step, spec)
+		}
+		f.timeouts[step] = d
+	}
+	if len(specs) > 0 {
+		f.profile.Timeouts = specs
+	}
+	return nil
+}
+
+// saveProfile stores the answers of the run when --save-profile is set.
+// The tag is not stored: it usually changes with every deployment.
+func (f *flow) saveProfile() error {
+	if runFlowSaveProfile == "" {
+		return f.skip()
+	}
+
+	p := f.answers()
+	p.Name = runFlowSaveProfile
+	config.SetProfile(p)
+	if err := config.SaveConfig(); err != nil {
+		return configErrorf("Error while saving the profile: %w", err)
+	}
+	outf("Profile '%s' saved.\n", p.Name)
+	return nil
+}
+
+// answers returns the answers of the run as an unnamed profile.
+// The tag is not included: it usually changes with every deployment.
+func (f *flow) answers() config.Profile {
+	p := config.Profile{
+		BuildContext:     f.buildContext.Name,
+		DeployContext:    f.deployContext.Name,
+		Registry:         f.registry,
+		ComposeFile:      f.absComposeFile,
+		Prefix:           f.prefixChoice,
+		Services:         f.targetServices,
+		Buildx:           f.buildx,
+		Project:          f.profile.Project,
+		ProjectDirectory: f.profile.ProjectDirectory,
+		Ship:             f.profile.Ship,
+		SyncDelete:       f.profile.SyncDelete,
+		// Build inputs are stored as given (sources only, never secret values)
+		BuildArgs:      append(append([]string{}, f.profile.BuildArgs...), runFlowBuildArgs...),
+		BuildArgFiles:  append(append([]string{}, f.profile.BuildArgFiles...), runFlowBuildArgFiles...),
+		BuildSecrets:   append(append([]string{}, f.profile.BuildSecrets...), runFlowBuildSecrets...),
+		SecretEnvFiles: append(append([]string{}, f.profile.SecretEnvFiles...), runFlowSecretEnvFiles...),
+		Timeouts:       f.profile.Timeouts,
+		Retry:          f.profile.Retry,
+		Hooks:          f.hooks,
+		Webhooks:       f.profile.Webhooks,
+		Deploy:         f.profile.Deploy,
+		Lint:           f.profile.Lint,
+	}
+	if f.changedOnly {
+		// Selected by --changed for this run only: the profile keeps deploying every service
+		p.Services = nil
+	}
+	if !f.cache.IsZero() {
+		cache := f.cache
+		p.BuildCache = &cache
+	}
+	return p
+}
+
+// chooseContext returns the registered context with the given name,
+// or asks the user to pick one when name is empty
+func chooseContext(label, name string) (config.DockerContext, error) {
+	if name != "" {
+		ctx, ok := config.FindContext(name)
+		if !ok {
+			return config.DockerContext{}, configErrorf("context '%s' is not registered", name)
+		}
+		outf("Context for %s: %s\n", label, ctx.Name)
+		return ctx, nil
+	}
+
+	if interactive() {
+		items := make([]string, len(config.Cfg.DockerContexts))
+		for i, c := range config.Cfg.DockerContexts {
+			items[i] = fmt.Sprintf("%s (host=%s)", c.Name, c.Host)
+		}
+		idx, err := selectOne("Context for "+label, items)
+		if err != nil {
+			return config.DockerContext{}, inputErrorf("%w", err)
+		}
+		return config.Cfg.DockerContexts[idx], nil
+	}
+	idx, err := parseIndex(readLine(fmt.Sprintf("Choose the index of the context for %s: ", label)), len(config.Cfg.DockerContexts))
+	if err != nil {
+		return config.DockerContext{}, inputErrorf("%w", err)
+	}
+	return config.Cfg.DockerContexts[idx], nil
+}
@@ -0,0 +1,393 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// progressTailLines is the number of output lines printed when a command fails
+const progressTailLines = 20
+
+// progressRefresh is the redraw period of the status lines on a terminal
+const progressRefresh = 500 * time.Millisecond
+
+// runLog receives the full output of the commands of the current run (nil outside run-flow)
+var runLog *lockedWriter
+
+// lockedWriter serializes the writes of the stdout and stderr copies of the commands
+type lockedWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	path string
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// logWriter returns the writer of the run log (io.Discard when there is none)
+func logWriter() io.Writer {
+	if runLog == nil {
+		return io.Discard
+	}
+	return runLog
+}
+
+// logf writes a line in the run log
+func logf(format string, a ...interface{}) {
+	fmt.Fprintf(logWriter(), format, a...)
+}
+
+// openRunLog starts the log of a run and returns the function closing it
+func (f *flow) openRunLog() func() {
+	file, err := history.CreateLog(f.record.ID)
+	if err != nil {
+		outf("Unable to create the log of the run: %v\n", err)
+		return func() {}
+	}
+	runLog = &lockedWriter{w: file, path: file.Name()}
+	f.record.LogFile = file.Name()
+	logf("# run-flow %s, started at %s\n", f.record.ID, f.record.StartedAt.Format(time.RFC3339))
+	return func() {
+		runLog = nil
+		file.Close()
+	}
+}
+
+// Status of a service in the progress lines
+const (
+	progressWaiting = "waiting"
+	progressActive  = "active"
+	progressDone    = "done"
+	progressFailed  = "failed"
+	progressStopped = "stopped"
+)
+
+// serviceProgress is the state of one service
+type serviceProgress struct {
+	status string
+	detail string
+	// errored is set when an error line was attributed to the service
+	errored    bool
+	start, end time.Time
+}
+
+var (
+	// buildkitStepRegexp matches the header of a BuildKit step: "#12 [api 3/5] RUN npm ci"
+	buildkitStepRegexp = regexp.MustCompile(`^#(\d+) \[([^\s\]]+)\s*([^\]]*)\] ?(.*)$`)
+	// buildkitLineRegexp matches any line of a BuildKit step: "#12 0.532 added 120 packages"
+	buildkitLineRegexp = regexp.MustCompile(`^#(\d+) (.*)$`)
+)
+
+// progress renders the output of a build or a push as one status line per service:
+// redrawn in place on a terminal, printed on every change otherwise.
+// The raw output goes to the run log, and its tail is printed on failure.
+type progress struct {
+	mu sync.Mutex
+	// verbs are the words of the active and done states ("building", "built")
+	active, done string
+	services     []string
+	states       map[string]*serviceProgress
+	// owners maps the BuildKit step IDs to the services
+	owners map[string]string
+	// images maps the services to their image, to attribute the push lines
+	images  map[string]string
+	partial []byte
+	tail    []string
+	live    bool
+	lines   int
+	width   int
+	stop    chan struct{}
+}
+
+// newProgress starts the status lines of the services
+func newProgress(active, done string, services []string, images map[string]string) *progress {
+	p := &progress{
+		active:   active,
+		done:     done,
+		services: services,
+		states:   make(map[string]*serviceProgress, len(services)),
+		owners:   make(map[string]string),
+		images:   images,
+		live:     interactive(),
+		stop:     make(chan struct{}),
+	}
+	for _, name := range services {
+		p.states[name] = &serviceProgress{status: progressWaiting}
+	}
+	if p.live {
+		// The first drawing waits for the output: the command line is printed first
+		p.width = terminalWidth()
+		go func() {
+			ticker := time.NewTicker(progressRefresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					p.mu.Lock()
+					p.render()
+					p.mu.Unlock()
+				case <-p.stop:
+					return
+				}
+			}
+		}()
+	}
+	return p
+}
+
+// Write receives the output of the command (stdout and stderr)
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexAny(p.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		p.handle(string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// handle updates the state of the service a line belongs to
+func (p *progress) handle(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	p.tail = append(p.tail, line)
+	if len(p.tail) > progressTailLines {
+		p.tail = p.tail[1:]
+	}
+
+	service, detail := p.attribute(line)
+	st, ok := p.states[service]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if st.status == progressWaiting {
+		st.status, st.start = progressActive, now
+		if !p.live {
+			outf("  %s: %s...\n", service, p.active)
+		}
+	}
+	st.end = now
+	if detail != "" {
+		st.detail = detail
+	}
+	if strings.Contains(line, "ERROR") || strings.Contains(line, "error:") {
+		st.errored = true
+	}
+}
+
+// attribute returns the service of a line, and the detail shown in its status line
+func (p *progress) attribute(line string) (string, string) {
+	// BuildKit (build, bake): the step headers name the service, the other lines carry the step ID
+	if m := buildkitStepRegexp.FindStringSubmatch(line); m != nil {
+		if _, ok := p.states[m[2]]; ok {
+			p.owners[m[1]] = m[2]
+			return m[2], strings.TrimSpace(m[3] + " " + m[4])
+		}
+		return p.owners[m[1]], ""
+	}
+	if m := buildkitLineRegexp.FindStringSubmatch(line); m != nil {
+		return p.owners[m[1]], ""
+	}
+	// Push: the lines name the service or its image
+	for _, field := range strings.Fields(line) {
+		field = strings.TrimRight(field, ":")
+		if _, ok := p.states[field]; ok {
+			return field, line
+		}
+	}
+	for service, image := range p.images {
+		repo := image
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			repo = image[:i]
+		}
+		if _, ok := p.states[service]; ok && repo != "" && strings.Contains(line, repo) {
+			return service, line
+		}
+	}
+	return "", ""
+}
+
+// finish sets the final state of the services (with the size of their image when known),
+// and prints the tail of the output when the command failed
+func (p *progress) finish(err error, sizes map[string]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live {
+		close(p.stop)
+	}
+	if len(p.partial) > 0 {
+		p.handle(string(p.partial))
+		p.partial = nil
+	}
+	now := time.Now()
+	for _, name := range p.services {
+		st := p.states[name]
+		switch {
+		case err == nil:
+			if st.status == progressWaiting {
+				st.start, st.end = now, now // nothing to do (cached, already in the registry)
+			}
+			st.status = progressDone
+			st.detail = ""
+			if size, ok := sizes[name]; ok {
+				st.detail = humanSize(size)
+			}
+		case st.errored:
+			st.status = progressFailed
+		case st.status == progressActive:
+			st.status = progressStopped
+		}
+	}
+
+	if p.live {
+		p.render()
+		p.lines = 0 // the final lines stay
+	} else {
+		for _, name := range p.services {
+			outf("  %s\n", p.statusLine(name))
+		}
+	}
+	if err != nil && len(p.tail) > 0 {
+		outf("Last lines of the output:\n")
+		for _, line := range p.tail {
+			outf("  | %s\n", line)
+		}
+		if runLog != nil {
+			outf("Full log: %s\n", runLog.path)
+		}
+	}
+}
+
+// render redraws the status lines (terminal only)
+func (p *progress) render() {
+	if !p.live {
+		return
+	}
+	var b strings.Builder
+	if p.lines > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA\r%s", p.lines, ansiClearDown)
+	}
+	for _, name := range p.services {
+		b.WriteString("  " + truncate(p.statusLine(name), p.width-3) + "\n")
+	}
+	fmt.Fprint(out(), b.String())
+	p.lines = len(p.services)
+}
+
+// statusLine formats the state of a service: "✔ api  built  12.3s  245.1MB"
+func (p *progress) statusLine(name string) string {
+	width := 0
+	for _, s := range p.services {
+		width = max(width, len(s))
+	}
+	st := p.states[name]
+	symbol, status, elapsed := "·", progressWaiting, ""
+	switch st.status {
+	case progressActive:
+		symbol, status = "…", p.active
+		elapsed = time.Since(st.start).Round(time.Second).String()
+	case progressDone:
+		symbol, status = "✔", p.done
+		elapsed = st.end.Sub(st.start).Round(100 * time.Millisecond).String()
+	case progressFailed, progressStopped:
+		symbol, status = "✖", st.status
+		elapsed = st.end.Sub(st.start).Round(100 * time.Millisecond).String()
+	}
+	return strings.TrimRight(fmt.Sprintf("%s %-*s  %-8s %7s  %s", symbol, width, name, status, elapsed, st.detail), " ")
+}
+
+// runProgress runs a build or push command of the flow behind the status lines of its services
+// (the raw output with --verbose). The push is retried on transient failures.
+func (f *flow) runProgress(kind string, services []string, retry bool, args ...string) error {
+	if runFlowVerbose {
+		if retry {
+			_, err := runCommandRetry(f.ctx, f.retry, "docker", args...)
+			return err
+		}
+		return runCommandContext(f.ctx, "docker", args...)
+	}
+
+	active, done := "building", "built"
+	if kind == "push" {
+		active, done = "pushing", "pushed"
+	}
+	p := newProgress(active, done, services, f.record.Images)
+	newCmd := func(ctx context.Context) *exec.Cmd {
+		cmd := commandContext(ctx, "docker", args...)
+		// One line per event, without the terminal animations of BuildKit
+		cmd.Env = append(os.Environ(), "BUILDKIT_PROGRESS=plain")
+		cmd.Stdout = p
+		cmd.Stderr = p
+		return cmd
+	}
+	var err error
+	if retry {
+		_, err = runWithRetry(f.ctx, f.retry, 0, newCmd)
+	} else {
+		_, err = runAttempt(f.ctx, 0, newCmd, 1, 1)
+	}
+
+	if err == nil && kind == "build" {
+		f.measureImages(services)
+	}
+	p.finish(err, f.imageSizes)
+	return err
+}
+
+// progressServices returns the services of a build or push: the built services,
+// or every target service with a build section
+func (f *flow) progressServices() []string {
+	if f.buildServices != nil {
+		return f.buildServices
+	}
+	var names []string
+	for _, svc := range f.allServices {
+		if svc.HasBuild() && (len(f.targetServices) == 0 || slices.Contains(f.targetServices, svc.Name)) {
+			names = append(names, svc.Name)
+		}
+	}
+	return names
+}
+
+// measureImages reads the size of the built images on the build context
+func (f *flow) measureImages(services []string) {
+	if f.imageSizes == nil {
+		f.imageSizes = make(map[string]int64)
+	}
+	for _, name := range services {
+		image := f.record.Images[name]
+		if image == "" {
+			continue
+		}
+		size, err := f.probe("docker", "--context", f.buildContext.Name, "image", "inspect", "--format", "{{.Size}}", image)
+		if err != nil {
+			continue
+		}
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			f.imageSizes[name] = n
+		}
+	}
+}
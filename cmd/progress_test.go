@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// captureStdout redirects the standard output to a file until the end of the test,
+// and returns the function reading what was written
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = file
+	t.Cleanup(func() {
+		os.Stdout = saved
+		file.Close()
+	})
+	return func() string {
+		data, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+func TestProgressBuild(t *testing.T) {
+	output := captureStdout(t)
+	p := newProgress("building", "built", []string{"api", "web", "worker"}, nil)
+	fmt.Fprint(p, "#1 [internal] load build definition\n")
+	fmt.Fprint(p, "#5 [api 2/4] RUN npm ci\n#6 [web 1/3] FROM node:22\n")
+	// A line split over two writes, and lines carrying only the step ID
+	fmt.Fprint(p, "#5 0.532 added 120 pack")
+	fmt.Fprint(p, "ages\n#6 ERROR: failed to resolve source metadata\n")
+
+	if st := p.states["api"]; st.status != progressActive || st.detail != "2/4 RUN npm ci" || st.errored {
+		t.Errorf("api = %+v", st)
+	}
+	if st := p.states["web"]; st.status != progressActive || !st.errored {
+		t.Errorf("web = %+v", st)
+	}
+	if st := p.states["worker"]; st.status != progressWaiting {
+		t.Errorf("worker = %+v", st)
+	}
+
+	p.finish(errors.New("exit status 1"), nil)
+	if got := p.states["api"].status; got != progressStopped {
+		t.Errorf("api status = %s, want %s", got, progressStopped)
+	}
+	if got := p.states["web"].status; got != progressFailed {
+		t.Errorf("web status = %s, want %s", got, progressFailed)
+	}
+	if got := p.states["worker"].status; got != progressWaiting {
+		t.Errorf("worker status = %s, want %s", got, progressWaiting)
+	}
+	text := output()
+	for _, want := range []string{
+		"  api: building...\n",
+		"  web: building...\n",
+		"✖ web     failed",
+		"· worker  waiting",
+		"Last lines of the output:\n  | #1 [internal] load build definition\n",
+		"  | #6 ERROR: failed to resolve source metadata\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output misses %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestProgressPush(t *testing.T) {
+	output := captureStdout(t)
+	images := map[string]string{"api": "reg.example.com/shop/api:1.2", "web": "reg.example.com:5000/shop/web:1.2"}
+	p := newProgress("pushing", "pushed", []string{"api", "web", "db"}, images)
+	for i := 0; i < progressTailLines+5; i++ {
+		fmt.Fprintf(p, "The push refers to repository [reg.example.com:5000/shop/web] %d\n", i)
+	}
+	fmt.Fprint(p, " api Pushed\r")
+
+	if st := p.states["web"]; st.status != progressActive || !strings.Contains(st.detail, "shop/web") {
+		t.Errorf("web = %+v", st)
+	}
+	if st := p.states["api"]; st.status != progressActive || st.detail != "api Pushed" {
+		t.Errorf("api = %+v", st)
+	}
+	if len(p.tail) != progressTailLines {
+		t.Errorf("%d tail lines, want %d", len(p.tail), progressTailLines)
+	}
+
+	p.finish(nil, map[string]int64{"api": 1536})
+	for _, name := range []string{"api", "web", "db"} {
+		if st := p.states[name]; st.status != progressDone {
+			t.Errorf("%s = %+v, want done", name, st)
+		}
+	}
+	if got := p.statusLine("api"); !strings.HasPrefix(got, "✔ api  pushed") || !strings.HasSuffix(got, "1.5KB") {
+		t.Errorf("statusLine(api) = %q", got)
+	}
+	if strings.Contains(output(), "Last lines") {
+		t.Error("the tail of the output is printed after a success")
+	}
+}
+
+func TestProgressServices(t *testing.T) {
+	services := []compose.Service{{Name: "api", BuildContext: "/app/api"}, {Name: "db"}, {Name: "web", BuildContext: "/app/web"}}
+	f := &flow{allServices: services}
+	if got := f.progressServices(); strings.Join(got, ",") != "api,web" {
+		t.Errorf("progressServices = %v", got)
+	}
+	f.targetServices = []string{"db", "web"}
+	if got := f.progressServices(); strings.Join(got, ",") != "web" {
+		t.Errorf("progressServices of the target services = %v", got)
+	}
+	f.buildServices = []string{}
+	if got := f.progressServices(); len(got) != 0 {
+		t.Errorf("progressServices without build = %v", got)
+	}
+}
+
+func TestRunLog(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	f := &flow{record: &history.Record{ID: "20261019-120000.000"}}
+	closeLog := f.openRunLog()
+	if _, err := commandOutput(context.Background(), "sh", "-c", "echo out"); err != nil {
+		t.Fatal(err)
+	}
+	logf("## step %s\n", "build")
+	closeLog()
+	logf("after the run\n")
+
+	data, err := os.ReadFile(f.record.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(data)
+	for _, want := range []string{"# run-flow 20261019-120000.000", "=> Command: sh -c echo out\n", "out\n", "## step build\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("the run log misses %q:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "after the run") {
+		t.Error("the log is written after it is closed")
+	}
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ship modes of the relative directories of the compose file
+const (
+	ShipNone = "none"
+	ShipTar  = "tar"
+	ShipSync = "sync"
+)
+
+var shipModes = []string{ShipNone, ShipTar, ShipSync}
+
+var (
+	// projectNameRegexp is the format of a compose project name
+	projectNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	// projectNameInvalidChars are removed from the directory name by docker compose
+	projectNameInvalidChars = regexp.MustCompile(`[^a-z0-9_-]`)
+)
+
+// loadProject resolves the project name, the project directory and the ship mode
+// of the profile and of the flags (the flags win)
+func (f *flow) loadProject() error {
+	if runFlowProjectName != "" {
+		f.profile.Project = runFlowProjectName
+	}
+	if runFlowProjectDir != "" {
+		f.profile.ProjectDirectory = runFlowProjectDir
+	}
+	if runFlowShip != "" {
+		f.profile.Ship = runFlowShip
+	}
+	if runFlowSyncDelete {
+		f.profile.SyncDelete = true
+	}
+
+	p := f.profile
+	if p.Project != "" && !projectNameRegexp.MatchString(p.Project) {
+		return inputErrorf("Invalid project name '%s': lowercase letters, digits, '-' and '_' only", p.Project)
+	}
+	if p.ProjectDirectory != "" && !path.IsAbs(p.ProjectDirectory) {
+		return inputErrorf("Invalid project directory '%s': an absolute path of the deploy host is expected", p.ProjectDirectory)
+	}
+	if p.Ship != "" && !slices.Contains(shipModes, p.Ship) {
+		return inputErrorf("Invalid ship mode '%s' (expected one of %s)", p.Ship, strings.Join(shipModes, ", "))
+	}
+	return nil
+}
+
+// projectName returns the compose project name on the deploy context
+func (f *flow) projectName() string {
+	if f.profile.Project != "" {
+		return f.profile.Project
+	}
+	// Same default as docker compose: the directory of the compose file
+	return defaultProjectName(f.absComposeFile)
+}
+
+// defaultProjectName returns the project name docker compose derives from the compose file
+func defaultProjectName(composeFile string) string {
+	name := strings.ToLower(filepath.Base(filepath.Dir(composeFile)))
+	return strings.TrimLeft(projectNameInvalidChars.ReplaceAllString(name, ""), "_-")
+}
+
+// projectDirectory returns the directory of the deploy host where the relative paths
+// are resolved: the one of the profile, or <projects_dir>/<project> of the deploy context
+// when files are shipped ("" = resolved on the local machine)
+func (f *flow) projectDirectory() string {
+	if f.profile.ProjectDirectory != "" {
+		return f.profile.ProjectDirectory
+	}
+	if f.shipMode() != ShipNone && f.deployContext.ProjectsDir != "" {
+		return path.Join(f.deployContext.ProjectsDir, f.projectName())
+	}
+	return ""
+}
+
+// shipMode returns how the relative directories reach the deploy host
+func (f *flow) shipMode() string {
+	if f.profile.Ship == "" {
+		return ShipNone
+	}
+	return f.profile.Ship
+}
+
+// projectArgs returns the project flags of the compose commands on the deploy context
+func (f *flow) projectArgs() []string {
+	var args []string
+	if f.profile.Project != "" {
+		args = append(args, "-p", f.profile.Project)
+	}
+	return append(args, projectDirectoryArgs(f.projectDirectory(), f.absComposeFile)...)
+}
+
+// projectDirectoryArgs returns the flags setting the project directory of a compose command.
+// docker compose looks for the default .env in the project directory, but on the local machine:
+// the .env next to the compose file is passed explicitly so that its variables are still used.
+func projectDirectoryArgs(dir, composeFile string) []string {
+	if dir == "" {
+		return nil
+	}
+	args := []string{"--project-directory", dir}
+	envFile := filepath.Join(filepath.Dir(composeFile), ".env")
+	if info, err := os.Stat(envFile); err == nil && info.Mode().IsRegular() {
+		args = append(args, "--env-file", envFile)
+	}
+	return args
+}
+
+// projectNameArgs returns the project flag of the compose commands on the build context:
+// the images without an "image" key are named after the project
+func (f *flow) projectNameArgs() []string {
+	if f.profile.Project == "" {
+		return nil
+	}
+	return []string{"-p", f.profile.Project}
+}
+
+// shipProject sends the relative directories of the compose file to the project directory
+// of the deploy host: bind mounts and build contexts with tar, changed bind mounts with sync
+func (f *flow) shipProject() error {
+	if f.shipMode() == ShipNone {
+		return nil
+	}
+	dir := f.projectDirectory()
+	if dir == "" {
+		return configErrorf("Shipping files requires a project directory (project_directory, or projects_dir on the context '%s').", f.deployContext.Name)
+	}
+	if f.shipMode() == ShipSync {
+		return f.syncBindMounts(dir)
+	}
+	paths, err := f.shippedPaths(true)
+	if err != nil {
+		return composeErrorf("Unable to ship the project files: %w", err)
+	}
+	if len(paths) == 0 {
+		outln("No relative directory to ship.")
+		return nil
+	}
+
+	// 1) The archive is extracted in the project directory, through SSH for a remote host
+	remote, err := remoteShell(f.deployContext.Host)
+	if err != nil {
+		return contextErrorf("Unable to ship the project files to '%s': %w", f.deployContext.Name, err)
+	}
+	outf("==> Shipping %s to %s:%s...\n", strings.Join(paths, ", "), f.deployContext.Name, dir)
+	extract := fmt.Sprintf("mkdir -p %s && tar -xzf - -C %s", shellQuote(dir), shellQuote(dir))
+	name, args := remote(extract)
+
+	// 2) tar | ssh
+	tarArgs := append([]string{"-czf", "-", "-C", filepath.Dir(f.absComposeFile)}, paths...)
+	if err := runPipe(f.ctx, "tar", tarArgs, name, args); err != nil {
+		return deployErrorf("Error shipping the project files: %w", err)
+	}
+	return nil
+}
+
+// shippedPaths returns the relative bind mounts (and build contexts) of the compose file,
+// relative to its directory. A path outside of this directory can't be shipped.
+func (f *flow) shippedPaths(buildContexts bool) ([]string, error) {
+	baseDir := filepath.Dir(f.absComposeFile)
+	seen := make(map[string]bool)
+	for _, svc := range f.allServices {
+		candidates := append([]string{}, svc.BindMounts...)
+		if buildContexts && svc.IsLocal() {
+			rel, err := filepath.Rel(baseDir, svc.BuildContext)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, rel)
+		}
+		for _, p := range candidates {
+			p = filepath.Clean(p)
+			if p == ".." || strings.HasPrefix(p, "../") {
+				return nil, fmt.Errorf("'%s' of service '%s' is outside of the directory of the compose file", p, svc.Name)
+			}
+			if _, err := os.Stat(filepath.Join(baseDir, p)); err != nil {
+				return nil, fmt.Errorf("'%s' of service '%s': %w", p, svc.Name, err)
+			}
+			seen[p] = true
+		}
+	}
+
+	// The whole directory includes everything else, a parent includes its children
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var result []string
+	for _, p := range paths {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if last == "." || strings.HasPrefix(p, last+"/") {
+				continue
+			}
+		}
+		result = append(result, p)
+	}
+	return result, nil
+}
+
+// remoteShell returns a function building the command that runs a shell command on the
+// host of a Docker context: through ssh for ssh://, locally for unix:// (same machine)
+func remoteShell(host string) (func(command string) (string, []string), error) {
+	switch {
+	case host == "" || strings.HasPrefix(host, "unix://"):
+		return func(command string) (string, []string) {
+			return "sh", []string{"-c", command}
+		}, nil
+	case strings.HasPrefix(host, "ssh://"):
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, err
+		}
+		target := u.Hostname()
+		if u.User != nil {
+			target = u.User.Username() + "@" + target
+		}
+		sshArgs := []string{"-o", "BatchMode=yes"}
+		if port := u.Port(); port != "" {
+			sshArgs = append(sshArgs, "-p", port)
+		}
+		sshArgs = append(sshArgs, target)
+		return func(command string) (string, []string) {
+			return "ssh", append(append([]string{}, sshArgs...), command)
+		}, nil
+	}
+	return nil, fmt.Errorf("host '%s' is not reachable through SSH (ssh:// or unix:// expected)", host)
+}
+
+// runPipe runs "from | to"
+func runPipe(ctx context.Context, fromName string, fromArgs []string, toName string, toArgs []string) error {
+	outf("=> Command: %s %s | %s %s\n", fromName, strings.Join(fromArgs, " "), toName, strings.Join(toArgs, " "))
+	logf("=> Command: %s %s | %s %s\n", fromName, strings.Join(fromArgs, " "), toName, strings.Join(toArgs, " "))
+	start := time.Now()
+	from := commandContext(ctx, fromName, fromArgs...)
+	to := commandContext(ctx, toName, toArgs...)
+	from.Stderr = io.MultiWriter(os.Stderr, logWriter())
+	to.Stdout = io.MultiWriter(out(), logWriter())
+	to.Stderr = io.MultiWriter(os.Stderr, logWriter())
+
+	// The parent closes its copies of the pipe once both commands hold theirs:
+	// when "to" exits early (ssh refused), "from" gets EPIPE instead of blocking on a full pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	from.Stdout = w
+	to.Stdin = r
+	if err := to.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return fmt.Errorf("%s: %w", toName, err)
+	}
+	if err := from.Start(); err != nil {
+		r.Close()
+		w.Close()
+		to.Wait()
+		return fmt.Errorf("%s: %w", fromName, err)
+	}
+	r.Close()
+	w.Close()
+
+	// The first failure is the cause, the other command usually fails because of it
+	type result struct {
+		name string
+		args []string
+		err  error
+	}
+	results := make(chan result, 2)
+	wait := func(cmd *exec.Cmd, name string, args []string) {
+		results <- result{name, args, cmd.Wait()}
+	}
+	go wait(from, fromName, fromArgs)
+	go wait(to, toName, toArgs)
+	var first error
+	for range 2 {
+		res := <-results
+		emitCommand(res.name, res.args, start, res.err)
+		if res.err != nil && first == nil {
+			first = fmt.Errorf("%s: %w", res.name, res.err)
+		}
+	}
+	return first
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+func TestProjectName(t *testing.T) {
+	tests := []struct {
+		project, composeFile string
+		want                 string
+	}{
+		{"shop", "/home/me/app/docker-compose.yml", "shop"},
+		{"", "/home/me/app/docker-compose.yml", "app"},
+		{"", "/home/me/My Shop.v2/docker-compose.yml", "myshopv2"},
+		{"", "/home/me/__app-1/docker-compose.yml", "app-1"},
+	}
+	for _, tt := range tests {
+		f := &flow{absComposeFile: tt.composeFile, profile: config.Profile{Project: tt.project}}
+		if got := f.projectName(); got != tt.want {
+			t.Errorf("projectName(%q, %q) = %q, want %q", tt.project, tt.composeFile, got, tt.want)
+		}
+	}
+}
+
+func TestProjectDirectory(t *testing.T) {
+	tests := []struct {
+		name        string
+		profile     config.Profile
+		projectsDir string
+		wantShip    string
+		wantDir     string
+	}{
+		{"nothing", config.Profile{}, "/srv", ShipNone, ""},
+		{"explicit directory", config.Profile{ProjectDirectory: "/opt/shop"}, "/srv", ShipNone, "/opt/shop"},
+		{"explicit directory wins", config.Profile{ProjectDirectory: "/opt/shop", Ship: ShipTar}, "/srv", ShipTar, "/opt/shop"},
+		{"shipped to projects_dir", config.Profile{Project: "shop", Ship: ShipSync}, "/srv/xpdemon", ShipSync, "/srv/xpdemon/shop"},
+		{"projects_dir without shipping", config.Profile{Project: "shop", Ship: ShipNone}, "/srv/xpdemon", ShipNone, ""},
+		{"shipped without projects_dir", config.Profile{Ship: ShipTar}, "", ShipTar, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flow{
+				absComposeFile: "/home/me/app/docker-compose.yml",
+				profile:        tt.profile,
+				deployContext:  config.DockerContext{Name: "prod", ProjectsDir: tt.projectsDir},
+			}
+			if got := f.shipMode(); got != tt.wantShip {
+				t.Errorf("shipMode = %q, want %q", got, tt.wantShip)
+			}
+			if got := f.projectDirectory(); got != tt.wantDir {
+				t.Errorf("projectDirectory = %q, want %q", got, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestProjectArgs(t *testing.T) {
+	dir := t.TempDir()
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	f := &flow{absComposeFile: composeFile, profile: config.Profile{Project: "shop"}}
+	if got, want := f.projectArgs(), []string{"-p", "shop"}; !slices.Equal(got, want) {
+		t.Errorf("projectArgs without a project directory = %v, want %v", got, want)
+	}
+
+	f.profile.ProjectDirectory = "/srv/shop"
+	if got, want := f.projectArgs(), []string{"-p", "shop", "--project-directory", "/srv/shop"}; !slices.Equal(got, want) {
+		t.Errorf("projectArgs without .env = %v, want %v", got, want)
+	}
+
+	// The local .env is passed, docker compose would look for it in the remote directory
+	writeFiles(t, dir, map[string]string{".env": "TAG=1.0\n"})
+	want := []string{"-p", "shop", "--project-directory", "/srv/shop", "--env-file", filepath.Join(dir, ".env")}
+	if got := f.projectArgs(); !slices.Equal(got, want) {
+		t.Errorf("projectArgs with .env = %v, want %v", got, want)
+	}
+	if got := projectDirectoryArgs("", composeFile); got != nil {
+		t.Errorf("projectDirectoryArgs without a directory = %v, want nil", got)
+	}
+}
+
+func TestShippedPaths(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docker-compose.yml":  "services: {}\n",
+		"conf/nginx.conf":     "",
+		"conf/sites/a.conf":   "",
+		"data/db":             "",
+		"api/Dockerfile":      "",
+		"my files/it's a.txt": "",
+	})
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	tests := []struct {
+		name          string
+		services      []compose.Service
+		buildContexts bool
+		want          []string
+		wantErr       string
+	}{
+		{
+			name: "bind mounts, a parent includes its children",
+			services: []compose.Service{
+				{Name: "web", BindMounts: []string{"./conf/sites", "./conf", "data/"}},
+				{Name: "api", BindMounts: []string{"./my files/it's a.txt"}, BuildContext: filepath.Join(dir, "api")},
+			},
+			want: []string{"conf", "data", "my files/it's a.txt"},
+		},
+		{
+			name:          "build contexts",
+			services:      []compose.Service{{Name: "api", BindMounts: []string{"./conf"}, BuildContext: filepath.Join(dir, "api")}},
+			buildContexts: true,
+			want:          []string{"api", "conf"},
+		},
+		{
+			name:          "the whole directory",
+			services:      []compose.Service{{Name: "api", BindMounts: []string{"./conf"}, BuildContext: dir}},
+			buildContexts: true,
+			want:          []string{"."},
+		},
+		{
+			name:     "outside of the directory",
+			services: []compose.Service{{Name: "web", BindMounts: []string{"../shared"}}},
+			wantErr:  "outside of the directory of the compose file",
+		},
+		{
+			name:     "missing path",
+			services: []compose.Service{{Name: "web", BindMounts: []string{"./missing"}}},
+			wantErr:  "'missing' of service 'web'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flow{absComposeFile: composeFile, allServices: tt.services}
+			got, err := f.shippedPaths(tt.buildContexts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("shippedPaths error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("shippedPaths: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("shippedPaths = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
 
 	"github.com/xpdemon/ac-deploy/config"
@@ -12,16 +10,20 @@ import (
 var AddRegistryCmd = &cobra.Command{
 	Use:   "add-registry",
 	Short: "Add a Docker registry to the list",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		registry := readLine("URL/Host of the registry (e.g., docker.io/myuser): ")
+		if registry == "" {
+			return inputErrorf("Registry is required, cancellation.")
+		}
 		config.Cfg.DockerRegistries = append(config.Cfg.DockerRegistries, registry)
 		// Save
 		err := config.SaveConfig()
+		emitResult(map[string]interface{}{"registry": registry, "added": err == nil})
 		if err != nil {
-			fmt.Printf("Error during save: %v\n", err)
-		} else {
-			fmt.Printf("Registry '%s' added.\n", registry)
+			return configErrorf("Error during save: %w", err)
 		}
+		outf("Registry '%s' added.\n", registry)
+		return nil
 	},
 }
 
@@ -29,31 +31,36 @@ var AddRegistryCmd = &cobra.Command{
 var LoginRegistryCmd = &cobra.Command{
 	Use:   "login-registry",
 	Short: "Log in to an existing Docker registry",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		if len(config.Cfg.DockerRegistries) == 0 {
-			fmt.Println("No registries are registered. Use `xpdemon-deploy add-registry`.")
-			return
+			return configErrorf("No registries are registered. Use `xpdemon-deploy add-registry`.")
 		}
 
-		// Display available registries
-		fmt.Println("Available registries:")
-		for i, r := range config.Cfg.DockerRegistries {
-			fmt.Printf("  [%d] %s\n", i, r)
-		}
-
-		idx := readLine("Choose the index of the registry to log in to: ")
-		selectedIndex := strToInt(idx)
-		if selectedIndex < 0 || selectedIndex >= len(config.Cfg.DockerRegistries) {
-			fmt.Println("Invalid index.")
-			return
+		var selectedIndex int
+		var err error
+		if interactive() {
+			if selectedIndex, err = selectOne("Registry to log in to", config.Cfg.DockerRegistries); err != nil {
+				return inputErrorf("%w", err)
+			}
+		} else {
+			// Display available registries
+			outln("Available registries:")
+			for i, r := range config.Cfg.DockerRegistries {
+				outf("  [%d] %s\n", i, r)
+			}
+			idx := readLine("Choose the index of the registry to log in to: ")
+			if selectedIndex, err = parseIndex(idx, len(config.Cfg.DockerRegistries)); err != nil {
+				return inputErrorf("Invalid registry: %w", err)
+			}
 		}
 
 		registry := config.Cfg.DockerRegistries[selectedIndex]
-		err := dockerLogin(registry)
+		err = dockerLogin(registry)
+		emitResult(map[string]interface{}{"registry": registry, "logged_in": err == nil})
 		if err != nil {
-			fmt.Printf("Error logging in to '%s': %v\n", registry, err)
-			return
+			return registryErrorf("Error logging in to '%s': %w", registry, err)
 		}
-		fmt.Printf("Logged in to registry: %s\n", registry)
+		outf("Logged in to registry: %s\n", registry)
+		return nil
 	},
 }
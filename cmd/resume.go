@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// replayedSteps are executed again when a run is resumed, even if they were completed:
+// they only set up the state of the process (flags, environment of the secrets).
+var replayedSteps = map[string]bool{
+	"profile":      true,
+	"build-inputs": true,
+}
+
+// loadResume loads the run given with --resume, whose answers replace the profile
+func (f *flow) loadResume() error {
+	if runFlowProfile != "" {
+		return inputErrorf("--resume can't be used with --profile: the answers of the resumed run are reused.")
+	}
+	prev, err := findResumableRun(runFlowResume)
+	if err != nil {
+		return err
+	}
+
+	f.resume = prev
+	f.profile = prev.Checkpoint.Inputs
+	// The webhooks are not checkpointed (their URLs and headers hold tokens): they come from the profile
+	if p, ok := config.FindProfile(prev.Profile); ok && prev.Profile != "" {
+		f.profile.Webhooks = p.Webhooks
+	}
+	f.record.Profile = prev.Profile
+	f.record.ResumedFrom = prev.ID
+	outf("==> Resuming run %s (completed steps: %s)\n", prev.ID, strings.Join(prev.Checkpoint.Completed, ", "))
+	return nil
+}
+
+// findResumableRun returns the failed or cancelled run with the given ID ("last" = the most recent one)
+func findResumableRun(id string) (*history.Record, error) {
+	if id == "last" {
+		records, err := history.List()
+		if err != nil {
+			return nil, configErrorf("Unable to read the run history: %w", err)
+		}
+		for i := range records {
+			if records[i].Status != history.StatusSuccess && records[i].Checkpoint != nil {
+				return &records[i], nil
+			}
+		}
+		return nil, inputErrorf("No failed run to resume.")
+	}
+
+	prev, err := history.Load(id)
+	if err != nil {
+		return nil, inputErrorf("Unable to resume: %w", err)
+	}
+	if prev.Status == history.StatusSuccess {
+		return nil, inputErrorf("Run '%s' succeeded, there is nothing to resume.", id)
+	}
+	if prev.Checkpoint == nil {
+		return nil, inputErrorf("Run '%s' has no checkpoint, it can't be resumed.", id)
+	}
+	return prev, nil
+}
+
+// resumes reports whether a step is restored from the resumed run instead of being executed
+func (f *flow) resumes(step string) bool {
+	if f.resume == nil || replayedSteps[step] || !f.resume.Checkpoint.IsCompleted(step) {
+		return false
+	}
+	// The generated compose may have been deleted since: generate it again
+	if generated := f.resume.Checkpoint.GeneratedCompose; step == "generate" && generated != f.resume.ComposeFile {
+		if _, err := os.Stat(generated); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// restore sets the state produced by a step completed in the resumed run
+func (f *flow) restore(step string) error {
+	prev := f.resume
+	cp := prev.Checkpoint
+
+	switch step {
+	case "contexts":
+		var ok bool
+		if f.buildContext, ok = config.FindContext(prev.BuildContext); !ok {
+			return configErrorf("Build context '%s' of run %s is no longer registered.", prev.BuildContext, prev.ID)
+		}
+		if f.deployContext, ok = config.FindContext(prev.DeployContext); !ok {
+			return configErrorf("Deploy context '%s' of run %s is no longer registered.", prev.DeployContext, prev.ID)
+		}
+		f.record.BuildContext = prev.BuildContext
+		f.record.DeployContext = prev.DeployContext
+	case "registry":
+		f.registry = prev.Registry
+		f.record.Registry = prev.Registry
+	case "compose":
+		f.composeFile = prev.ComposeFile
+		f.absComposeFile = prev.ComposeFile
+		var err error
+		if f.allServices, err = compose.ParseServices(prev.ComposeFile); err != nil {
+			return composeErrorf("Error parsing docker-compose services: %w", err)
+		}
+		f.record.ComposeFile = prev.ComposeFile
+	case "services":
+		var err error
+		if f.selectedServices, err = servicesByName(f.allServices, prev.Services); err != nil {
+			return composeErrorf("The services of run %s changed: %w", prev.ID, err)
+		}
+		f.targetServices = cp.TargetServices
+		f.buildServices = cp.BuildServices
+		f.changedOnly = cp.ChangedOnly
+		f.record.Services = prev.Services
+	case "tag":
+		f.tagChoice = cp.Tag
+		f.prefixChoice = cp.Inputs.Prefix
+	case "changes":
+		f.record.Hashes = prev.Hashes
+		f.reusedImages = cp.ReusedImages
+		f.buildServices = cp.BuildServices
+		f.skipBuild = cp.SkipBuild
+	case "generate":
+		f.newComposePath = cp.GeneratedCompose
+		f.record.Images = prev.Images
+	case "push":
+		f.record.Pushed = prev.Pushed
+	case "deploy":
+		f.record.Deployed = prev.Deployed
+	}
+	outf("Step '%s' already completed by run %s, skipped.\n", step, prev.ID)
+	return f.skip()
+}
+
+// checkpoint saves the state of the run after a completed step
+func (f *flow) checkpoint(step string) {
+	cp := f.record.Checkpoint
+	if cp == nil {
+		cp = &history.Checkpoint{}
+		f.record.Checkpoint = cp
+	}
+	cp.Completed = append(cp.Completed, step)
+	cp.Inputs = f.answers()
+	// The webhook URLs and headers hold tokens, a resumed run reloads them from the profile
+	cp.Inputs.Webhooks = nil
+	cp.Tag = f.tagChoice
+	cp.GeneratedCompose = f.newComposePath
+	cp.TargetServices = f.targetServices
+	cp.BuildServices = f.buildServices
+	cp.SkipBuild = f.skipBuild
+	cp.ChangedOnly = f.changedOnly
+	cp.ReusedImages = f.reusedImages
+	f.saveRecord()
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// setTestConfig replaces the configuration until the end of the test
+func setTestConfig(t *testing.T, cfg config.AppConfig) {
+	t.Helper()
+	saved := config.Cfg
+	config.Cfg = cfg
+	t.Cleanup(func() { config.Cfg = saved })
+}
+
+func TestCheckpointWithoutWebhooks(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	hook := config.Webhook{URL: "https://hooks.slack.com/services/T0/B0/token", Headers: map[string]string{"Authorization": "Bearer token"}}
+	setTestConfig(t, config.AppConfig{Profiles: []config.Profile{{Name: "prod", Webhooks: []config.Webhook{hook}}}})
+
+	f := &flow{
+		record:  &history.Record{ID: "20261019-120000.000", Profile: "prod", Status: history.StatusFailed, ComposeFile: "/app/docker-compose.yml"},
+		profile: config.Profile{Project: "shop", Webhooks: []config.Webhook{hook}},
+	}
+	f.checkpoint("profile")
+	data, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".xpdemon-deploy", "history", f.record.ID+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "token") {
+		t.Fatalf("the checkpoint holds the webhooks:\n%s", data)
+	}
+
+	// The resumed run gets the webhooks of the profile back
+	saved := runFlowResume
+	runFlowResume = f.record.ID
+	defer func() { runFlowResume = saved }()
+	resumed := &flow{record: &history.Record{}}
+	if err := resumed.loadResume(); err != nil {
+		t.Fatalf("loadResume: %v", err)
+	}
+	if resumed.profile.Project != "shop" || len(resumed.profile.Webhooks) != 1 || resumed.profile.Webhooks[0].URL != hook.URL {
+		t.Errorf("resumed profile = %+v", resumed.profile)
+	}
+	if !slices.Equal(resumed.resume.Checkpoint.Completed, []string{"profile"}) {
+		t.Errorf("completed steps = %v", resumed.resume.Checkpoint.Completed)
+	}
+}
+
+func TestFindResumableRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	cp := &history.Checkpoint{Completed: []string{"contexts"}}
+	records := []*history.Record{
+		{ID: "failed-old", StartedAt: start, Status: history.StatusFailed, Checkpoint: cp},
+		{ID: "cancelled", StartedAt: start.Add(time.Hour), Status: history.StatusCancelled, Checkpoint: cp},
+		{ID: "no-checkpoint", StartedAt: start.Add(2 * time.Hour), Status: history.StatusFailed},
+		{ID: "success", StartedAt: start.Add(3 * time.Hour), Status: history.StatusSuccess, Checkpoint: cp},
+	}
+	for _, rec := range records {
+		if err := history.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		id      string
+		want    string
+		wantErr string
+	}{
+		{id: "last", want: "cancelled"},
+		{id: "failed-old", want: "failed-old"},
+		{id: "success", wantErr: "nothing to resume"},
+		{id: "no-checkpoint", wantErr: "has no checkpoint"},
+		{id: "unknown", wantErr: "not found"},
+	}
+	for _, tt := range tests {
+		rec, err := findResumableRun(tt.id)
+		if tt.wantErr != "" {
+			var inputErr *InputError
+			if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findResumableRun(%q) error = %v, want an input error %q", tt.id, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || rec.ID != tt.want {
+			t.Errorf("findResumableRun(%q) = %v, %v, want %s", tt.id, rec, err, tt.want)
+		}
+	}
+}
+
+func TestResumes(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose-tagged.yml": "services: {}\n"})
+	generated := filepath.Join(dir, "docker-compose-tagged.yml")
+	f := &flow{resume: &history.Record{
+		ComposeFile: filepath.Join(dir, "docker-compose.yml"),
+		Checkpoint: &history.Checkpoint{
+			Completed:        []string{"profile", "contexts", "build-inputs", "compose", "generate", "build"},
+			GeneratedCompose: generated,
+		},
+	}}
+	tests := []struct {
+		step string
+		want bool
+	}{
+		{"profile", false},      // replayed
+		{"build-inputs", false}, // replayed
+		{"contexts", true},
+		{"generate", true},
+		{"build", true},
+		{"push", false}, // the step that failed
+		{"deploy", false},
+	}
+	for _, tt := range tests {
+		if got := f.resumes(tt.step); got != tt.want {
+			t.Errorf("resumes(%s) = %v, want %v", tt.step, got, tt.want)
+		}
+	}
+
+	// The generated compose file was deleted: it is generated again
+	os.Remove(generated)
+	if f.resumes("generate") {
+		t.Error("resumes(generate) = true without the generated compose file")
+	}
+	// The original compose file is used as is
+	f.resume.Checkpoint.GeneratedCompose = f.resume.ComposeFile
+	if !f.resumes("generate") {
+		t.Error("resumes(generate) = false when the original compose file is used")
+	}
+
+	if (&flow{}).resumes("contexts") {
+		t.Error("resumes = true without a resumed run")
+	}
+}
+
+func TestRestore(t *testing.T) {
+	discardStdout(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": "services:\n  api:\n    build: ./api\n  db:\n    image: postgres:16\n"})
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	setTestConfig(t, config.AppConfig{DockerContexts: []config.DockerContext{{Name: "build"}, {Name: "prod"}}})
+
+	prev := &history.Record{
+		ID:            "failed-run",
+		BuildContext:  "build",
+		DeployContext: "prod",
+		Registry:      "reg.example.com",
+		ComposeFile:   composeFile,
+		Services:      []string{"api"},
+		Hashes:        map[string]string{"api": "abc"},
+		Checkpoint: &history.Checkpoint{
+			Tag:            "1.2",
+			Inputs:         config.Profile{Prefix: "shop"},
+			TargetServices: []string{"api"},
+			BuildServices:  []string{"api"},
+		},
+	}
+	f := &flow{resume: prev, record: &history.Record{}}
+	for _, step := range []string{"contexts", "registry", "compose", "services", "tag", "changes"} {
+		f.skipped = false
+		if err := f.restore(step); err != nil {
+			t.Fatalf("restore(%s): %v", step, err)
+		}
+		if !f.skipped {
+			t.Errorf("restore(%s) did not skip the step", step)
+		}
+	}
+	if f.buildContext.Name != "build" || f.deployContext.Name != "prod" || f.registry != "reg.example.com" {
+		t.Errorf("contexts = %s, %s, registry = %s", f.buildContext.Name, f.deployContext.Name, f.registry)
+	}
+	if len(f.selectedServices) != 1 || f.selectedServices[0].Name != "api" || !slices.Equal(f.buildServices, []string{"api"}) {
+		t.Errorf("services = %+v, build services = %v", f.selectedServices, f.buildServices)
+	}
+	if f.tagChoice != "1.2" || f.prefixChoice != "shop" || f.record.Hashes["api"] != "abc" {
+		t.Errorf("tag = %s, prefix = %s, hashes = %v", f.tagChoice, f.prefixChoice, f.record.Hashes)
+	}
+
+	// The state of the resumed run no longer matches the configuration
+	setTestConfig(t, config.AppConfig{DockerContexts: []config.DockerContext{{Name: "build"}}})
+	if err := f.restore("contexts"); err == nil || !strings.Contains(err.Error(), "'prod' of run failed-run") {
+		t.Errorf("restore with a removed context = %v", err)
+	}
+	prev.Services = []string{"api", "worker"}
+	if err := f.restore("services"); err == nil {
+		t.Error("restore with a removed service succeeded")
+	}
+}
@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Default retry settings of the network operations
+const (
+	defaultRetryAttempts = 3
+	defaultRetryDelay    = 2 * time.Second
+	defaultRetryMaxDelay = 30 * time.Second
+	// retryOutputTail is the amount of output kept to classify a failure
+	retryOutputTail = 64 * 1024
+)
+
+// permanentFailures are the docker messages of failures that won't go away by retrying.
+// They are checked before the transient ones ("pull access denied" wins over a later "EOF"),
+// so they are the full messages of docker and ssh: a bare "not found" or "denied" also
+// shows up in the output of flaky pushes and pulls.
+var permanentFailures = []string{
+	"unauthorized: authentication required",
+	"unauthorized: incorrect username or password",
+	"incorrect username or password",
+	"pull access denied",
+	"requested access to the resource is denied",
+	"insufficient_scope",
+	"manifest invalid",
+	"manifest unknown",
+	"name unknown",
+	"invalid reference format",
+	"repository does not exist",
+	"no such image",
+	"host key verification failed",
+	"permission denied (publickey",
+}
+
+// transientFailures are the docker messages of network and server-side failures
+var transientFailures = []string{
+	"timeout",
+	"timed out",
+	"deadline exceeded",
+	"connection reset",
+	"connection refused",
+	"connection closed",
+	"broken pipe",
+	"no route to host",
+	"network is unreachable",
+	"no such host",
+	"temporary failure",
+	"tls handshake",
+	"unexpected eof",
+	"500 internal server error",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+	"too many requests",
+	"toomanyrequests",
+	"blob upload unknown",
+}
+
+// retryPolicy is the resolved retry configuration
+type retryPolicy struct {
+	attempts int
+	delay    time.Duration
+	maxDelay time.Duration
+}
+
+// defaultRetryPolicy returns the built-in defaults overridden by the global "retry" configuration
+func defaultRetryPolicy() (retryPolicy, error) {
+	p := retryPolicy{
+		attempts: defaultRetryAttempts,
+		delay:    defaultRetryDelay,
+		maxDelay: defaultRetryMaxDelay,
+	}
+	return p.with(config.Cfg.Retry)
+}
+
+// with returns the policy overridden by the non-empty fields of c
+func (p retryPolicy) with(c *config.RetryConfig) (retryPolicy, error) {
+	if c == nil {
+		return p, nil
+	}
+	if c.Attempts < 0 {
+		return p, fmt.Errorf("retry attempts must be at least 1, got %d", c.Attempts)
+	}
+	if c.Attempts > 0 {
+		p.attempts = c.Attempts
+	}
+	for _, field := range []struct {
+		spec  string
+		value *time.Duration
+	}{{c.Delay, &p.delay}, {c.MaxDelay, &p.maxDelay}} {
+		if field.spec == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.spec)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid retry delay '%s' (e.g., 2s)", field.spec)
+		}
+		*field.value = d
+	}
+	return p, nil
+}
+
+// backoff returns the wait before the given retry (1 = first retry), doubled every time
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := p.delay
+	for i := 1; i < retry && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if p.maxDelay > 0 && d > p.maxDelay {
+		d = p.maxDelay
+	}
+	return d
+}
+
+// classifyFailure tells whether a failed command is worth retrying, from its output.
+// Unknown failures are permanent: only the known network/server errors are retried.
+func classifyFailure(output string) (transient bool, reason string) {
+	lower := strings.ToLower(output)
+	for _, pattern := range permanentFailures {
+		if strings.Contains(lower, pattern) {
+			return false, pattern
+		}
+	}
+	for _, pattern := range transientFailures {
+		if strings.Contains(lower, pattern) {
+			return true, pattern
+		}
+	}
+	return false, "unknown failure"
+}
+
+// runCommandRetry is runCommandContext with retries on transient failures.
+// It returns the output of the last attempt.
+func runCommandRetry(ctx context.Context, policy retryPolicy, name string, args ...string) (string, error) {
+	return runWithRetry(ctx, policy, 0, func(ctx context.Context) *exec.Cmd {
+		return commandContext(ctx, name, args...)
+	})
+}
+
+// runWithRetry runs the command built by newCmd until it succeeds, fails permanently
+// or the attempts are exhausted. Each attempt is bounded by attemptTimeout when it is set
+// (an expired attempt is a transient failure). The output is shown and kept to classify the failure.
+func runWithRetry(ctx context.Context, policy retryPolicy, attemptTimeout time.Duration, newCmd func(context.Context) *exec.Cmd) (string, error) {
+	attempts := max(policy.attempts, 1)
+	for attempt := 1; ; attempt++ {
+		output, err := runAttempt(ctx, attemptTimeout, newCmd, attempt, attempts)
+		if err == nil || ctx.Err() != nil {
+			return output, err
+		}
+
+		transient, reason := classifyFailure(output)
+		if errors.Is(err, context.DeadlineExceeded) {
+			transient, reason = true, "attempt timed out"
+		}
+		if !transient {
+			return output, fmt.Errorf("%w (not retried: %s)", err, reason)
+		}
+		if attempt >= attempts {
+			return output, fmt.Errorf("%w (%s, gave up after %d attempt(s))", err, reason, attempts)
+		}
+
+		wait := policy.backoff(attempt)
+		outf("Attempt %d/%d failed (%s), retrying in %s...\n", attempt, attempts, reason, wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return output, err
+		}
+	}
+}
+
+// runAttempt executes one attempt and returns its (tail of) output
+func runAttempt(ctx context.Context, timeout time.Duration, newCmd func(context.Context) *exec.Cmd, attempt, attempts int) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := newCmd(ctx)
+	args := cmd.Args[1:]
+	line := fmt.Sprintf("=> Command: %s %s\n", cmd.Args[0], strings.Join(args, " "))
+	if attempt > 1 {
+		line = fmt.Sprintf("=> Command (attempt %d/%d): %s %s\n", attempt, attempts, cmd.Args[0], strings.Join(args, " "))
+	}
+	outf("%s", line)
+	logf("%s", line)
+
+	// The writers set by newCmd (e.g., a progress renderer) replace the terminal
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = out()
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	tail := &tailBuffer{max: retryOutputTail}
+	cmd.Stdout = io.MultiWriter(stdout, tail, logWriter())
+	cmd.Stderr = io.MultiWriter(stderr, tail, logWriter())
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", err, ctx.Err())
+	}
+	emitCommand(cmd.Args[0], args, start, err)
+	return tail.String(), err
+}
+
+// tailBuffer keeps the last max bytes written to it.
+// It is shared by stdout and stderr, which os/exec copies from two goroutines.
+type tailBuffer struct {
+	mu   sync.Mutex
+	max  int
+	data []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = b.data[len(b.data)-b.max:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.data)
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		transient bool
+	}{
+		{"empty", "", false},
+		{"unknown", "Error: something unexpected happened", false},
+		{"unauthorized", "unauthorized: authentication required", false},
+		{"bad credentials", "Error response from daemon: Get https://reg.io/v2/: incorrect username or password", false},
+		{"manifest unknown", "manifest unknown: manifest unknown", false},
+		{"invalid reference", `invalid reference format: repository name must be lowercase`, false},
+		{"host key", "Host key verification failed.", false},
+		{"connection reset", "read tcp 10.0.0.2:443: read: connection reset by peer", true},
+		{"timeout", "net/http: TLS handshake timeout", true},
+		{"no such host", "dial tcp: lookup reg.io: no such host", true},
+		{"unexpected EOF", "error pushing layer: unexpected EOF", true},
+		{"bad gateway", "received unexpected HTTP status: 502 Bad Gateway", true},
+		{"rate limit", "toomanyrequests: You have reached your pull rate limit", true},
+		{"case insensitive", "CONNECTION REFUSED", true},
+		{"pull access denied", "Error response from daemon: pull access denied for app, repository does not exist or may require 'docker login'", false},
+		{"push denied", "denied: requested access to the resource is denied", false},
+		{"ssh key refused", "user@host: Permission denied (publickey,password).", false},
+		{"flaky push with not found", "blob sha256:abc not found, retrying\nerror: unexpected EOF", true},
+		{"flaky pull with denied", "layer download denied by the mirror: 503 Service Unavailable", true},
+		{"flaky push with unauthorized", "failed to fetch oauth token: unexpected status: 401 Unauthorized: read: connection reset by peer", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transient, reason := classifyFailure(tt.output)
+			if transient != tt.transient {
+				t.Errorf("classifyFailure(%q) = %v (%s), want %v", tt.output, transient, reason, tt.transient)
+			}
+		})
+	}
+}
+
+func TestRunAttemptOutputTail(t *testing.T) {
+	discardStdout(t)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	// stdout and stderr are copied concurrently into the same tail,
+	// stdout is shorter than the tail so that it can't push the last stderr line out
+	script := `for i in $(seq 8000); do [ $i -gt 2000 ] || echo "out $i"; echo "err $i" >&2; done; echo "connection reset by peer" >&2; exit 1`
+	newCmd := func(ctx context.Context) *exec.Cmd {
+		cmd := exec.CommandContext(ctx, "sh", "-c", script)
+		cmd.Stdout, cmd.Stderr = devNull, devNull
+		return cmd
+	}
+	output, err := runAttempt(context.Background(), 0, newCmd, 1, 1)
+	if err == nil {
+		t.Fatal("runAttempt succeeded")
+	}
+	if len(output) != retryOutputTail || !strings.Contains(output, "connection reset by peer\n") {
+		t.Errorf("tail has %d bytes, want %d with the end of stderr", len(output), retryOutputTail)
+	}
+	if transient, _ := classifyFailure(output); !transient {
+		t.Errorf("failure is not transient")
+	}
+}
@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Deploy strategies
+const (
+	StrategyRecreate  = "recreate"
+	StrategyRolling   = "rolling"
+	StrategyBlueGreen = "blue-green"
+)
+
+var deployStrategies = []string{StrategyRecreate, StrategyRolling, StrategyBlueGreen}
+
+const (
+	defaultHealthTimeout = 2 * time.Minute
+	healthPollInterval   = 2 * time.Second
+)
+
+// loadDeployConfig resolves the deploy strategy of the profile and of the flags
+func (f *flow) loadDeployConfig() error {
+	if runFlowStrategy != "" || runFlowHealthTimeout != "" || len(runFlowSkipPreflight) > 0 {
+		d := config.DeployConfig{}
+		if f.profile.Deploy != nil {
+			d = *f.profile.Deploy
+		}
+		if runFlowStrategy != "" {
+			d.Strategy = runFlowStrategy
+		}
+		if runFlowHealthTimeout != "" {
+			d.HealthTimeout = runFlowHealthTimeout
+		}
+		if len(runFlowSkipPreflight) > 0 {
+			d.Preflight = &config.PreflightConfig{Skip: runFlowSkipPreflight}
+		}
+		f.profile.Deploy = &d
+	}
+
+	f.strategy = StrategyRecreate
+	f.healthTimeout = defaultHealthTimeout
+	if d := f.profile.Deploy; d != nil {
+		if d.Strategy != "" {
+			if !slices.Contains(deployStrategies, d.Strategy) {
+				return inputErrorf("Invalid deploy strategy '%s' (expected one of %s)", d.Strategy, strings.Join(deployStrategies, ", "))
+			}
+			f.strategy = d.Strategy
+		}
+		if d.HealthTimeout != "" {
+			timeout, err := time.ParseDuration(d.HealthTimeout)
+			if err != nil || timeout <= 0 {
+				return inputErrorf("Invalid health timeout '%s' (e.g., 2m)", d.HealthTimeout)
+			}
+			f.healthTimeout = timeout
+		}
+		if d.BlueGreen != nil {
+			if err := validateBlueGreen(d.BlueGreen); err != nil {
+				return inputErrorf("Invalid blue-green configuration: %w", err)
+			}
+			f.blueGreen = *d.BlueGreen
+		}
+		if d.Preflight != nil {
+			if err := validatePreflightSkip(d.Preflight.Skip); err != nil {
+				return inputErrorf("Invalid preflight configuration: %w", err)
+			}
+			f.preflightSkip = d.Preflight.Skip
+		}
+	}
+	if f.blueGreen.Previous == "" {
+		f.blueGreen.Previous = PreviousStop
+	}
+	return nil
+}
+
+// composeArgs returns the docker arguments of a compose command on the deploy context
+func (f *flow) composeArgs(args ...string) []string {
+	base := append([]string{"--context", f.deployContext.Name, "compose", "-f", f.newComposePath}, f.projectArgs()...)
+	return append(base, args...)
+}
+
+// rollingDeploy updates the services one by one, dependencies first:
+// new containers are started next to the old ones, and the old ones are only
+// removed once the new ones are healthy.
+func (f *flow) rollingDeploy() error {
+	names := f.targetServices
+	if len(names) == 0 {
+		names = serviceNames(f.allServices)
+	}
+	order, err := compose.DeployOrder(f.allServices, names)
+	if err != nil {
+		return composeErrorf("Unable to order the services: %w", err)
+	}
+	outf("==> Rolling update of %s...\n", strings.Join(order, ", "))
+
+	byName := servicesByNameMap(f.allServices)
+	for _, name := range order {
+		if err := f.rollService(byName[name]); err != nil {
+			return deployErrorf("Rolling update of '%s' failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// rollService updates one service: scale up, wait for health, remove the old containers
+func (f *flow) rollService(svc compose.Service) error {
+	old, err := f.serviceContainers(svc.Name)
+	if err != nil {
+		return err
+	}
+	if len(old) == 0 {
+		outf("==> '%s' is not running, starting it...\n", svc.Name)
+		return runCommandContext(f.ctx, "docker", f.composeArgs("up", "-d", "--no-build", "--no-deps", svc.Name)...)
+	}
+	if !svc.IsScalable() {
+		outf("==> '%s' has a fixed container name or host port, it is recreated in place...\n", svc.Name)
+		return runCommandContext(f.ctx, "docker", f.composeArgs("up", "-d", "--no-build", "--no-deps", svc.Name)...)
+	}
+
+	// 1) Scale up: the old containers are kept, the new ones use the new image
+	replicas := max(svc.Replicas, len(old))
+	outf("==> Starting %d new container(s) of '%s' next to the %d old one(s)...\n", replicas, svc.Name, len(old))
+	err = runCommandContext(f.ctx, "docker", f.composeArgs(
+		"up", "-d", "--no-build", "--no-deps", "--no-recreate",
+		"--scale", fmt.Sprintf("%s=%d", svc.Name, len(old)+replicas),
+		svc.Name,
+	)...)
+	if err != nil {
+		return err
+	}
+	all, err := f.serviceContainers(svc.Name)
+	if err != nil {
+		return err
+	}
+	var fresh []string
+	for _, id := range all {
+		if !slices.Contains(old, id) {
+			fresh = append(fresh, id)
+		}
+	}
+	if len(fresh) == 0 {
+		return fmt.Errorf("no new container was started")
+	}
+
+	// 2) Wait for the new containers, remove them if they never get healthy
+	if err := f.waitHealthy(svc.Name, fresh); err != nil {
+		outf("==> Removing the new containers of '%s', the old ones keep running...\n", svc.Name)
+		f.removeContainers(fresh)
+		return err
+	}
+
+	// 3) Remove the old containers, then bring the scale back to its value
+	outf("==> Removing the %d old container(s) of '%s'...\n", len(old), svc.Name)
+	if err := runCommandContext(f.ctx, "docker", append([]string{"--context", f.deployContext.Name, "stop"}, old...)...); err != nil {
+		return err
+	}
+	if err := runCommandContext(f.ctx, "docker", append([]string{"--context", f.deployContext.Name, "rm"}, old...)...); err != nil {
+		return err
+	}
+	return runCommandContext(f.ctx, "docker", f.composeArgs(
+		"up", "-d", "--no-build", "--no-deps", "--no-recreate",
+		"--scale", fmt.Sprintf("%s=%d", svc.Name, replicas),
+		svc.Name,
+	)...)
+}
+
+// serviceContainers returns the IDs of the running containers of a service
+func (f *flow) serviceContainers(service string) ([]string, error) {
+	output, err := commandOutput(f.ctx, "docker", f.composeArgs("ps", "-q", service)...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the containers of '%s': %w", service, err)
+	}
+	return strings.Fields(output), nil
+}
+
+// waitHealthy waits until the containers are healthy (running, for those without healthcheck).
+// An exited or unhealthy container fails immediately.
+func (f *flow) waitHealthy(service string, ids []string) error {
+	outf("Waiting for %d container(s) of '%s' to be healthy (timeout %s)...\n", len(ids), service, f.healthTimeout)
+	deadline := time.Now().Add(f.healthTimeout)
+	args := append([]string{
+		"--context", f.deployContext.Name, "inspect",
+		"--format", "{{.Id}} {{.State.Status}} {{if .State.Health}}{{.State.Health.Status}}{{end}}",
+	}, ids...)
+
+	for {
+		output, err := commandOutput(f.ctx, "docker", args...)
+		if err != nil {
+			return fmt.Errorf("unable to inspect the containers: %w", err)
+		}
+		ready := 0
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			id, status, health := shortID(fields[0]), fields[1], ""
+			if len(fields) > 2 {
+				health = fields[2]
+			}
+			switch {
+			case status == "exited" || status == "dead":
+				return fmt.Errorf("container %s %s", id, status)
+			case health == "unhealthy":
+				return fmt.Errorf("container %s is unhealthy", id)
+			case status == "running" && (health == "" || health == "healthy"):
+				ready++
+			}
+		}
+		if ready == len(ids) {
+			outf("Container(s) of '%s' healthy.\n", service)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the containers are not healthy after %s", f.healthTimeout)
+		}
+		select {
+		case <-time.After(healthPollInterval):
+		case <-f.ctx.Done():
+			return f.ctx.Err()
+		}
+	}
+}
+
+// removeContainers force-removes containers of the deploy context.
+// It is not bound to the step, so that it also cleans up after a cancellation.
+func (f *flow) removeContainers(ids []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), contextProbeTimeout)
+	defer cancel()
+	args := append([]string{"--context", f.deployContext.Name, "rm", "-f"}, ids...)
+	if err := runCommandContext(ctx, "docker", args...); err != nil {
+		outf("Unable to remove the containers: %v\n", err)
+	}
+}
+
+// servicesByNameMap indexes services by name
+func servicesByNameMap(services []compose.Service) map[string]compose.Service {
+	byName := make(map[string]compose.Service, len(services))
+	for _, svc := range services {
+		byName[svc.Name] = svc
+	}
+	return byName
+}
+
+// shortID returns the 12-character form of a container ID
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
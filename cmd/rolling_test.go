@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+func TestLoadDeployConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		deploy       *config.DeployConfig
+		strategy     string
+		wantStrategy string
+		wantTimeout  time.Duration
+		wantErr      string
+	}{
+		{name: "defaults", wantStrategy: StrategyRecreate, wantTimeout: defaultHealthTimeout},
+		{
+			name:         "from the profile",
+			deploy:       &config.DeployConfig{Strategy: StrategyRolling, HealthTimeout: "30s"},
+			wantStrategy: StrategyRolling,
+			wantTimeout:  30 * time.Second,
+		},
+		{
+			name:         "the flag wins",
+			deploy:       &config.DeployConfig{Strategy: StrategyRolling, HealthTimeout: "30s"},
+			strategy:     StrategyRecreate,
+			wantStrategy: StrategyRecreate,
+			wantTimeout:  30 * time.Second,
+		},
+		{name: "unknown strategy", strategy: "canary", wantErr: "Invalid deploy strategy 'canary'"},
+		{name: "invalid health timeout", deploy: &config.DeployConfig{HealthTimeout: "soon"}, wantErr: "Invalid health timeout"},
+	}
+	saved := runFlowStrategy
+	defer func() { runFlowStrategy = saved }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runFlowStrategy = tt.strategy
+			f := &flow{profile: config.Profile{Deploy: tt.deploy}}
+			err := f.loadDeployConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadDeployConfig error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadDeployConfig: %v", err)
+			}
+			if f.strategy != tt.wantStrategy || f.healthTimeout != tt.wantTimeout {
+				t.Errorf("strategy = %s, health timeout = %s, want %s, %s", f.strategy, f.healthTimeout, tt.wantStrategy, tt.wantTimeout)
+			}
+			if tt.deploy != nil && tt.strategy != "" && tt.deploy.Strategy != StrategyRolling {
+				t.Error("the flag changed the profile of the configuration")
+			}
+		})
+	}
+}
+
+// fakeSwarm is a docker command keeping the containers of the service "api" in a file:
+// "up --scale api=N" starts containers up to N, "stop"/"rm" remove them and "inspect"
+// reports them with the health written in the file "health"
+func fakeSwarm(t *testing.T, containers ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"containers": strings.Join(containers, "\n") + "\n", "health": "healthy"})
+	fakeDocker(t, `dir=`+shellQuote(dir)+`
+echo "$*" >> "$dir/log"
+case "$*" in
+*" ps -q api") cat "$dir/containers" ;;
+*"--scale api="*)
+	want=${*##*--scale api=}; want=${want%% *}
+	n=$(grep -c . "$dir/containers")
+	while [ "$n" -lt "$want" ]; do n=$((n+1)); echo "new$n" >> "$dir/containers"; done ;;
+*" inspect "*)
+	for id; do case "$id" in new*|old*) echo "$id running $(cat "$dir/health")" ;; esac; done ;;
+*" stop "*|*" rm "*)
+	for id; do case "$id" in new*|old*) grep -v "^$id\$" "$dir/containers" > "$dir/kept"; mv "$dir/kept" "$dir/containers" ;; esac; done ;;
+esac`)
+	return dir
+}
+
+func TestRollService(t *testing.T) {
+	discardStdout(t)
+	f := &flow{
+		ctx:            context.Background(),
+		deployContext:  config.DockerContext{Name: "prod"},
+		newComposePath: "/app/docker-compose-tagged.yml",
+		absComposeFile: "/app/docker-compose.yml",
+		healthTimeout:  time.Second,
+	}
+	api := compose.Service{Name: "api", Replicas: 1}
+
+	// The new container is healthy: the old one is replaced
+	dir := fakeSwarm(t, "old1")
+	if err := f.rollService(api); err != nil {
+		t.Fatalf("rollService: %v", err)
+	}
+	checkFile(t, dir, "containers", "new2\n")
+	data, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var steps []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.Contains(line, "--scale"):
+			steps = append(steps, "scale "+fields[len(fields)-2])
+		case fields[2] != "compose" && fields[2] != "inspect":
+			steps = append(steps, strings.Join(fields[2:], " "))
+		}
+	}
+	want := []string{"scale api=2", "stop old1", "rm old1", "scale api=1"}
+	if strings.Join(steps, ", ") != strings.Join(want, ", ") {
+		t.Errorf("docker commands = %v, want %v", steps, want)
+	}
+
+	// The new container is unhealthy: it is removed and the old one keeps running
+	dir = fakeSwarm(t, "old1")
+	writeFiles(t, dir, map[string]string{"health": "unhealthy"})
+	err = f.rollService(api)
+	if err == nil || !strings.Contains(err.Error(), "new2 is unhealthy") {
+		t.Errorf("rollService error = %v, want an unhealthy container", err)
+	}
+	checkFile(t, dir, "containers", "old1\n")
+
+	// The new container never gets healthy before the timeout
+	dir = fakeSwarm(t, "old1")
+	writeFiles(t, dir, map[string]string{"health": "starting"})
+	f.healthTimeout = time.Nanosecond
+	if err := f.rollService(api); err == nil || !strings.Contains(err.Error(), "not healthy after 1ns") {
+		t.Errorf("rollService error = %v, want a health timeout", err)
+	}
+	checkFile(t, dir, "containers", "old1\n")
+}
+
+func TestRollServiceInPlace(t *testing.T) {
+	discardStdout(t)
+	f := &flow{ctx: context.Background(), deployContext: config.DockerContext{Name: "prod"}, newComposePath: "/app/docker-compose-tagged.yml"}
+	for _, svc := range []compose.Service{
+		{Name: "api", ContainerName: "shop-api", Replicas: 1},
+		{Name: "api", PublishedPorts: []string{"80"}, Replicas: 1},
+	} {
+		dir := fakeSwarm(t, "old1")
+		if err := f.rollService(svc); err != nil {
+			t.Fatalf("rollService: %v", err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "--scale") || !strings.Contains(string(data), "up -d --no-build --no-deps api") {
+			t.Errorf("%+v was not recreated in place:\n%s", svc, data)
+		}
+	}
+
+	// A container exiting during the health check fails at once
+	f.healthTimeout = time.Minute
+	fakeDocker(t, `for id; do :; done; echo "$id exited"`)
+	start := time.Now()
+	err := f.waitHealthy("api", []string{"abcdef0123456789"})
+	if err == nil || !strings.Contains(err.Error(), "abcdef012345 exited") {
+		t.Errorf("waitHealthy error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > healthPollInterval {
+		t.Errorf("waitHealthy returned after %s", elapsed)
+	}
+}
@@ -1,222 +1,865 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/xpdemon/ac-deploy/compose"
 	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+	"github.com/xpdemon/ac-deploy/notify"
 	"gopkg.in/yaml.v3"
 )
 
+// Flags of run-flow
+var (
+	runFlowServices    []string
+	runFlowChangedOnly bool
+	runFlowForceBuild  bool
+	runFlowProfile     string
+	runFlowSaveProfile string
+	runFlowBuildx      bool
+	runFlowPlatforms   []string
+	runFlowBuildxNodes []string
+	runFlowCacheFrom   []string
+	runFlowCacheTo     []string
+	runFlowPruneUntil  string
+	runFlowPruneKeep   string
+	runFlowCacheReport bool
+
+	runFlowBuildArgs      []string
+	runFlowBuildArgFiles  []string
+	runFlowBuildSecrets   []string
+	runFlowSecretEnvFiles []string
+
+	runFlowTimeouts   map[string]string
+	runFlowRetries    int
+	runFlowRetryDelay time.Duration
+	runFlowResume     string
+	runFlowHooks      []string
+	runFlowWebhooks   []string
+
+	runFlowStrategy      string
+	runFlowHealthTimeout string
+
+	runFlowProjectName string
+	runFlowProjectDir  string
+	runFlowShip        string
+	runFlowSyncDelete  bool
+
+	runFlowNoLint        bool
+	runFlowSkipPreflight []string
+	runFlowVerbose       bool
+
+	runFlowYes     bool
+	runFlowTrigger string
+)
+
+func init() {
+	RunFlowCmd.Flags().StringSliceVarP(&runFlowServices, "services", "s", nil, "Only build, push and deploy these services (comma-separated)")
+	RunFlowCmd.Flags().BoolVar(&runFlowChangedOnly, "changed", false, "Only build, push and deploy the services whose build context changed since the last deployment")
+	RunFlowCmd.Flags().BoolVar(&runFlowForceBuild, "force-build", false, "Build and push every selected service, even when its build context is unchanged")
+	RunFlowCmd.Flags().StringVarP(&runFlowProfile, "profile", "p", "", "Use the answers stored in this profile")
+	RunFlowCmd.Flags().StringVar(&runFlowSaveProfile, "save-profile", "", "Save the answers of this run as a profile")
+	RunFlowCmd.Flags().StringVar(&runFlowResume, "resume", "", "Resume a failed or cancelled run from its failed step (run ID, or \"last\")")
+	RunFlowCmd.Flags().BoolVar(&runFlowBuildx, "buildx", false, "Build multi-platform images with docker buildx (images are pushed during the build)")
+	RunFlowCmd.Flags().StringSliceVar(&runFlowPlatforms, "platforms", nil, "Platforms to build with buildx (default: the platform of the deploy context)")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowCacheFrom, "cache-from", nil, "Build cache source injected in every built service, {service} is replaced (e.g., type=registry,ref=my-registry.com/cache:{service})")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowCacheTo, "cache-to", nil, "Build cache destination injected in every built service, {service} is replaced")
+	RunFlowCmd.Flags().StringVar(&runFlowPruneUntil, "prune-until", "", "Prune the builder cache older than this duration before the build (e.g., 24h)")
+	RunFlowCmd.Flags().StringVar(&runFlowPruneKeep, "prune-keep-storage", "", "Prune the builder cache down to this size before the build (e.g., 10GB)")
+	RunFlowCmd.Flags().BoolVar(&runFlowCacheReport, "cache-report", false, "Print the size of the build cache before and after the build")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowBuildArgs, "build-arg", nil, "Build argument KEY=VALUE, or service:KEY=VALUE for a single service (repeatable)")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowBuildArgFiles, "build-arg-file", nil, "Env file whose variables are passed as build arguments to every service (repeatable)")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowBuildSecrets, "secret", nil, "BuildKit secret id=NAME,src=PATH or id=NAME,env=VAR, optionally prefixed with service: (repeatable)")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowSecretEnvFiles, "secret-env-file", nil, "Env file loaded in the environment to feed the env= secrets (repeatable)")
+	RunFlowCmd.Flags().StringToStringVar(&runFlowTimeouts, "timeout", nil, "Maximum duration of a step, step=duration (e.g., push=10m,deploy=5m)")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowHooks, "hook", nil, "Shell command run at a stage, stage=command (e.g., pre-deploy='./migrate.sh'), repeatable")
+	RunFlowCmd.Flags().StringArrayVar(&runFlowWebhooks, "webhook", nil, "Webhook notified of the start, success and failure of the run: URL, or slack=URL / discord=URL (repeatable)")
+	RunFlowCmd.Flags().StringVar(&runFlowProjectName, "project-name", "", "Compose project name on the deploy context (default: the directory of the compose file)")
+	RunFlowCmd.Flags().StringSliceVar(&runFlowSkipPreflight, "skip-preflight", nil, "Preflight checks not run before deploying: versions, disk, ports, resources, registry, or all")
+	RunFlowCmd.Flags().BoolVarP(&runFlowVerbose, "verbose", "v", false, "Show the raw output of the build and push instead of a status line per service")
+	RunFlowCmd.Flags().BoolVar(&runFlowNoLint, "no-lint", false, "Skip the checks of the compose file before the build")
+	RunFlowCmd.Flags().StringVar(&runFlowProjectDir, "project-directory", "", "Directory of the deploy host where the relative paths of the compose file are resolved")
+	RunFlowCmd.Flags().StringVar(&runFlowShip, "ship", "", "Send the relative paths of the compose file to the project directory before the deployment: none (default), tar (bind mounts and build contexts) or sync (changed files of the bind mounts)")
+	RunFlowCmd.Flags().BoolVar(&runFlowSyncDelete, "sync-delete", false, "With --ship sync, also delete the files of the deploy host that are missing locally (like rsync --delete)")
+	RunFlowCmd.Flags().StringVar(&runFlowStrategy, "strategy", "", "Deploy strategy: recreate (default, docker compose up), rolling (new containers first, old ones removed once healthy) or blue-green")
+	RunFlowCmd.Flags().StringVar(&runFlowHealthTimeout, "health-timeout", "", "Maximum wait for the new containers to be healthy with the rolling and blue-green strategies (default 2m)")
+	RunFlowCmd.Flags().IntVar(&runFlowRetries, "retries", 0, "Number of attempts of the push, pull and context probe operations on transient failures (default 3, 1 = no retry)")
+	RunFlowCmd.Flags().DurationVar(&runFlowRetryDelay, "retry-delay", 0, "Wait before the first retry, doubled at every retry (default 2s)")
+	RunFlowCmd.Flags().BoolVar(&runFlowYes, "yes", false, "Run without prompts: push and deploy, no prune, keep the generated compose file, ENTER for the other questions")
+	RunFlowCmd.Flags().StringVar(&runFlowTrigger, "trigger", "", "What started the run, stored in its history record (set by watch)")
+	RunFlowCmd.Flags().MarkHidden("trigger")
+	RunFlowCmd.Flags().StringSliceVar(&runFlowBuildxNodes, "buildx-nodes", nil, "Additional Docker contexts used as buildx nodes (name or name=platform)")
+}
+
 var RunFlowCmd = &cobra.Command{
 	Use:   "run-flow",
 	Short: "Execute the complete flow: choose contexts, build, push, deploy",
-	Run: func(cmd *cobra.Command, args []string) {
-		// 1) Check if there are contexts
-		if len(config.Cfg.DockerContexts) == 0 {
-			fmt.Println("No Docker contexts are registered. Use `xpdemon-deploy add-context`.")
-			return
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f := &flow{}
+		return f.run()
+	},
+}
+
+// flow holds the state of one run-flow execution
+type flow struct {
+	record *history.Record
+	// ctx is the context of the running step (cancelled on SIGINT/SIGTERM or when the step times out)
+	ctx context.Context
+	// steps are the names of the steps, timeouts their optional maximum duration
+	steps    []string
+	timeouts map[string]time.Duration
+	// retry applies to the network operations (push, pull, context probes)
+	retry retryPolicy
+	// resume is the failed run resumed with --resume (nil otherwise)
+	resume *history.Record
+	// hooks are run at the stages of the flow (stage => hooks)
+	hooks map[string][]config.Hook
+	// webhooks are notified of the start and of the end of the run
+	webhooks []config.Webhook
+	notified bool
+	// strategy is the deploy strategy, healthTimeout bounds the health checks of the new containers
+	strategy      string
+	healthTimeout time.Duration
+	blueGreen     config.BlueGreenConfig
+	// preflightSkip lists the preflight checks not run (profile or --skip-preflight)
+	preflightSkip []string
+	// skipped is set by a step that had nothing to do
+	skipped bool
+	// done is set by a step to end the flow successfully (nothing left to do)
+	done bool
+	// profile holds the stored answers (empty profile when --profile is not set)
+	profile config.Profile
+	// buildx is set when the images are built with docker buildx
+	buildx *config.BuildxConfig
+	// cache holds the build cache strategy (profile + flags)
+	cache config.BuildCacheConfig
+	// buildArgs / buildSecrets are injected in the build section of the services
+	buildArgs    []buildArg
+	buildSecrets []buildSecret
+
+	buildContext  config.DockerContext
+	deployContext config.DockerContext
+	registry      string
+
+	composeFile    string
+	absComposeFile string
+	newComposePath string
+
+	allServices      []compose.Service
+	selectedServices []compose.Service
+	// targetServices is passed to docker compose (empty = every service)
+	targetServices []string
+	// buildServices is passed to build/push, nil when nothing has to be built
+	buildServices []string
+	// changedOnly is set when the services are the ones changed since the last deployment (--changed)
+	changedOnly bool
+	skipBuild   bool
+
+	tagChoice    string
+	prefixChoice string
+	// reusedImages holds the previously pushed image of the unchanged services
+	reusedImages map[string]string
+	// imageSizes holds the size of the built images (service => bytes)
+	imageSizes map[string]int64
+	// unlock releases the lock of the profile (nil when the run has no profile)
+	unlock func()
+}
+
+// run executes the steps of the flow in order, stopping at the first failure
+func (f *flow) run() error {
+	f.record = &history.Record{
+		ID:        history.NewID(),
+		StartedAt: time.Now(),
+		Status:    history.StatusFailed,
+		Trigger:   runFlowTrigger,
+	}
+	// The lock of the profile is taken by the profile step
+	defer func() {
+		if f.unlock != nil {
+			f.unlock()
 		}
+	}()
 
-		// 2) Display the list of available contexts
-		fmt.Println("List of Docker contexts:")
-		for i, c := range config.Cfg.DockerContexts {
-			fmt.Printf("  [%d] %s (host=%s)\n", i, c.Name, c.Host)
+	steps := []flowStep{
+		{"profile", f.loadProfile},
+		{"contexts", f.chooseContexts},
+		{"registry", f.chooseRegistry},
+		{"compose", f.loadCompose},
+		{"services", f.chooseServices},
+		{"tag", f.chooseTagAndPrefix},
+		{"lint", f.lint},
+		{"build-inputs", f.loadBuildInputs},
+		{"changes", f.detectChanges},
+		{"generate", f.generateCompose},
+		{"prune", f.prune},
+		{"build", f.build},
+		{"push", f.push},
+		{"deploy", f.deploy},
+		{"save-profile", f.saveProfile},
+	}
+	for _, step := range steps {
+		f.steps = append(f.steps, step.name)
+	}
+	closeLog := f.openRunLog()
+	defer closeLog()
+	stopProgress := f.showProgress()
+	var err error
+	var failedStep string
+	for _, step := range steps {
+		if step.name == notifyStartStep {
+			f.notify(notify.EventStart, "", nil)
+		}
+		if f.resumes(step.name) {
+			name := step.name
+			step.fn = func() error { return f.restore(name) }
+		}
+		if err = f.runStep(step); err != nil {
+			failedStep = step.name
+			break
 		}
+		f.checkpoint(step.name)
+		if f.done {
+			break
+		}
+	}
+	stopProgress()
+	var cancelled *CancelledError
+	switch {
+	case err == nil:
+		f.record.Status = history.StatusSuccess
+	case errors.As(err, &cancelled):
+		f.record.Status = history.StatusCancelled
+		f.record.Error = err.Error()
+	default:
+		f.record.Error = err.Error()
+		f.runFailureHooks(failedStep, err)
+	}
+	f.record.FinishedAt = time.Now()
+	f.saveRecord()
+	// Only the runs that reached the build are notified (not the invalid inputs)
+	if f.notified {
+		if err == nil {
+			f.notify(notify.EventSuccess, "", nil)
+		} else {
+			f.notify(notify.EventFailure, failedStep, err)
+		}
+	}
+	emitResult(map[string]interface{}{
+		"run_id":       f.record.ID,
+		"status":       f.record.Status,
+		"services":     f.record.Services,
+		"images":       f.record.Images,
+		"pushed":       f.record.Pushed,
+		"deployed":     f.record.Deployed,
+		"error":        f.record.Error,
+		"resumed_from": f.record.ResumedFrom,
+	})
 
-		// 3) Select the context for BUILDER
-		buildIdxInput := readLine("Choose the index of the context for BUILDER: ")
-		buildIdx := strToInt(buildIdxInput)
-		if buildIdx < 0 || buildIdx >= len(config.Cfg.DockerContexts) {
-			fmt.Println("Invalid index for build context.")
-			return
+	// Optional cleanup of the generated file (if it still exists), without asking once cancelled
+	if f.newComposePath != "" && f.newComposePath != f.composeFile {
+		if _, statErr := os.Stat(f.newComposePath); statErr == nil {
+			if f.record.Status == history.StatusCancelled {
+				cleanupFile(f.newComposePath)
+			} else if cleanupChoice := ask("Do you want to delete the temporary docker-compose file? (y/n): ", "n"); strings.ToLower(cleanupChoice) == "y" {
+				cleanupFile(f.newComposePath)
+			}
 		}
-		buildContext := config.Cfg.DockerContexts[buildIdx]
+	}
+	return err
+}
+
+// flowStep is one named step of run-flow, returning an error to stop the flow
+type flowStep struct {
+	name string
+	fn   func() error
+}
+
+// runStep executes a step between step_started and step_finished events.
+// The step runs under f.ctx, bounded by its timeout; an interruption or an expired
+// timeout replaces the error of the step.
+func (f *flow) runStep(step flowStep) error {
+	emit(Event{Type: EventStepStarted, RunID: f.record.ID, Step: step.name})
+	logf("\n## step %s (%s)\n", step.name, time.Now().Format(time.RFC3339))
+	start := time.Now()
+	f.skipped = false
+
+	timeout := f.timeouts[step.name]
+	f.ctx = appCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		f.ctx, cancel = context.WithTimeout(appCtx, timeout)
+		defer cancel()
+	}
+	err := step.fn()
+	if ctxErr := contextError(f.ctx, step.name, timeout); ctxErr != nil {
+		err = ctxErr
+	}
+
+	e := Event{
+		Type:     EventStepFinished,
+		RunID:    f.record.ID,
+		Step:     step.name,
+		Status:   history.StatusSuccess,
+		Duration: time.Since(start).Milliseconds(),
+	}
+	var cancelled *CancelledError
+	if errors.As(err, &cancelled) {
+		e.Status = history.StatusCancelled
+		e.Error = err.Error()
+	} else if err != nil {
+		e.Status = history.StatusFailed
+		e.Error = err.Error()
+	} else if f.skipped {
+		e.Status = "skipped"
+	}
+	logf("## step %s %s in %dms\n", step.name, e.Status, e.Duration)
+	if e.Error != "" {
+		logf("%s\n", e.Error)
+	}
+	emit(e)
+	return err
+}
+
+// skip marks the current step as skipped (it had nothing to do) and continues the flow
+func (f *flow) skip() error {
+	f.skipped = true
+	return nil
+}
 
-		// 4) Select the context for DEPLOY
-		deployIdxInput := readLine("Choose the index of the context for DEPLOY (no-build): ")
-		deployIdx := strToInt(deployIdxInput)
-		if deployIdx < 0 || deployIdx >= len(config.Cfg.DockerContexts) {
-			fmt.Println("Invalid index for deploy context.")
-			return
+// cleanupGenerated deletes the generated compose file (never the original one)
+func (f *flow) cleanupGenerated() {
+	if f.newComposePath != f.composeFile {
+		cleanupFile(f.newComposePath)
+	}
+}
+
+// saveRecord stores the run in the history once the compose file is known
+func (f *flow) saveRecord() {
+	if f.record.ComposeFile == "" {
+		return
+	}
+	if err := history.Save(f.record); err != nil {
+		outf("Unable to save the run record: %v\n", err)
+	}
+}
+
+// chooseContexts selects the build and deploy contexts
+func (f *flow) chooseContexts() error {
+	// 1) Check if there are contexts
+	if len(config.Cfg.DockerContexts) == 0 {
+		return contextErrorf("No Docker contexts are registered. Use `xpdemon-deploy add-context`.")
+	}
+
+	// 2) Display the list of available contexts (the terminal UI shows its own list)
+	if !interactive() {
+		outln("List of Docker contexts:")
+		for i, c := range config.Cfg.DockerContexts {
+			outf("  [%d] %s (host=%s)\n", i, c.Name, c.Host)
 		}
-		deployContext := config.Cfg.DockerContexts[deployIdx]
+	}
 
-		// 5) Select the registry (optional)
-		var registry string
-		if len(config.Cfg.DockerRegistries) > 0 {
-			fmt.Println("Available registries:")
-			for i, r := range config.Cfg.DockerRegistries {
-				fmt.Printf("  [%d] %s\n", i, r)
-			}
-			regIdxInput := readLine("Choose the index of the registry to push to (or press ENTER to skip): ")
-			if regIdxInput != "" {
-				regIdx := strToInt(regIdxInput)
-				if regIdx >= 0 && regIdx < len(config.Cfg.DockerRegistries) {
-					registry = config.Cfg.DockerRegistries[regIdx]
-				}
+	// 3) Select the context for BUILDER
+	var err error
+	f.buildContext, err = chooseContext("BUILDER", f.profile.BuildContext)
+	if err != nil {
+		return fmt.Errorf("Invalid build context: %w", err)
+	}
+
+	// 4) Select the context for DEPLOY
+	f.deployContext, err = chooseContext("DEPLOY (no-build)", f.profile.DeployContext)
+	if err != nil {
+		return fmt.Errorf("Invalid deploy context: %w", err)
+	}
+
+	f.record.BuildContext = f.buildContext.Name
+	f.record.DeployContext = f.deployContext.Name
+	return nil
+}
+
+// chooseRegistry selects the registry to push to (optional)
+func (f *flow) chooseRegistry() error {
+	if f.profile.Registry != "" {
+		f.registry = f.profile.Registry
+		outf("Registry: %s\n", f.registry)
+	} else if len(config.Cfg.DockerRegistries) > 0 && interactive() && !runFlowYes {
+		items := append(append([]string{}, config.Cfg.DockerRegistries...), "(no registry: don't push)")
+		idx, err := selectOne("Registry to push to", items)
+		if err != nil {
+			return inputErrorf("%w", err)
+		}
+		if idx < len(config.Cfg.DockerRegistries) {
+			f.registry = config.Cfg.DockerRegistries[idx]
+		}
+	} else if len(config.Cfg.DockerRegistries) > 0 {
+		outln("Available registries:")
+		for i, r := range config.Cfg.DockerRegistries {
+			outf("  [%d] %s\n", i, r)
+		}
+		regIdxInput := ask("Choose the index of the registry to push to (or press ENTER to skip): ", "")
+		if regIdxInput != "" {
+			regIdx, err := parseIndex(regIdxInput, len(config.Cfg.DockerRegistries))
+			if err != nil {
+				return inputErrorf("Invalid registry: %w", err)
 			}
+			f.registry = config.Cfg.DockerRegistries[regIdx]
 		}
+	}
+	f.record.Registry = f.registry
+	return nil
+}
+
+// loadCompose asks for the docker-compose.yml and parses it
+func (f *flow) loadCompose() error {
+	// 6) Path to docker-compose.yml
+	f.composeFile = f.profile.ComposeFile
+	if f.composeFile == "" {
+		f.composeFile = readLine("Path to your docker-compose.yml: ")
+	}
+	if f.composeFile == "" {
+		return inputErrorf("Path to docker-compose.yml not specified, cancellation.")
+	}
+
+	// 7) Parse docker-compose.yml to detect images
+	images, err := compose.ParseComposeFile(f.composeFile)
+	if err != nil {
+		return composeErrorf("Error parsing docker-compose: %w", err)
+	}
+	outln("Images detected in this docker-compose:")
+	for _, img := range images {
+		outf("  - %s\n", img)
+	}
+
+	f.absComposeFile, err = filepath.Abs(f.composeFile)
+	if err != nil {
+		return composeErrorf("Unable to resolve the path of the docker-compose: %w", err)
+	}
+	f.allServices, err = compose.ParseServices(f.composeFile)
+	if err != nil {
+		return composeErrorf("Error parsing docker-compose services: %w", err)
+	}
+	f.record.ComposeFile = f.absComposeFile
+	return nil
+}
+
+// chooseServices selects the services to work on
+func (f *flow) chooseServices() error {
+	names := runFlowServices
+	if len(names) == 0 {
+		names = f.profile.Services
+	}
+	f.changedOnly = len(names) == 0 && runFlowChangedOnly
+	selected, err := selectServices(f.allServices, names, f.changedOnly)
+	if err != nil {
+		return inputErrorf("Invalid service selection: %w", err)
+	}
+	if len(selected) == 0 {
+		outln("No service to deploy, nothing to do.")
+		f.done = true
+		return nil
+	}
+	f.selectedServices = selected
+
+	// An empty list means "every service" for docker compose
+	if len(selected) < len(f.allServices) {
+		f.targetServices = serviceNames(selected)
+		outf("Selected services: %s\n", strings.Join(f.targetServices, ", "))
+	}
+	f.buildServices = f.targetServices
+	f.record.Services = serviceNames(selected)
+	return nil
+}
 
-		// 6) Path to docker-compose.yml
-		composeFile := readLine("Path to your docker-compose.yml: ")
-		if composeFile == "" {
-			fmt.Println("Path to docker-compose.yml not specified, cancellation.")
-			return
+// chooseTagAndPrefix asks for the tag and the image prefix
+func (f *flow) chooseTagAndPrefix() error {
+	// === 7.a) Ask the user for a tag ===
+	f.tagChoice = f.profile.Tag
+	if f.tagChoice == "" {
+		f.tagChoice = ask("Enter the tag to apply (replace only if tag=latest). Leave empty to not change: ", "")
+	}
+	if f.tagChoice != "" {
+		if err := validateTag(f.tagChoice); err != nil {
+			return inputErrorf("Invalid tag: %w", err)
 		}
+	}
 
-		// 7) Parse docker-compose.yml to detect images
-		images, err := compose.ParseComposeFile(composeFile)
+	// === 7.b) Offer a prefix (registry + user) if the user wants
+	if f.profile.Prefix != "" {
+		f.prefixChoice = f.profile.Prefix
+	} else if f.registry != "" {
+		// Offer to add a prefix like "registry.com/myuser"
+		f.prefixChoice = ask("Do you want to prefix the images with the selected registry (e.g., my-registry.com/user)? (Press ENTER to skip): ", "")
+	} else {
+		f.prefixChoice = ask("Do you want to prefix the images (e.g., my-registry.com/user)? (Press ENTER to skip): ", "")
+	}
+	return nil
+}
+
+// detectChanges hashes the build contexts and skips the build/push of the unchanged services
+func (f *flow) detectChanges() error {
+	salts := f.hashSalts()
+	if f.changedOnly {
+		outln("==> Selecting the services changed since the last deployment...")
+		changed, err := changedServices(f.selectedServices, f.absComposeFile, salts)
 		if err != nil {
-			fmt.Printf("Error parsing docker-compose: %v\n", err)
-			return
+			return buildErrorf("Error during change detection: %w", err)
 		}
-		fmt.Println("Images detected in this docker-compose:")
-		for _, img := range images {
-			fmt.Printf("  - %s\n", img)
+		if len(changed) == 0 {
+			outln("No service changed since the last deployment, nothing to do.")
+			f.done = true
+			return nil
 		}
+		f.selectedServices = changed
+		f.targetServices = serviceNames(changed)
+		f.buildServices = f.targetServices
+		f.record.Services = f.targetServices
+		outf("Selected services: %s\n", strings.Join(f.targetServices, ", "))
+	}
 
-		// === 7.a) Ask the user for a tag ===
-		tagChoice := readLine("Enter the tag to apply (replace only if tag=latest). Leave empty to not change: ")
-		if tagChoice != "" {
-			if err := validateTag(tagChoice); err != nil {
-				fmt.Printf("Invalid tag: %v\n", err)
-				return
-			}
+	outln("==> Detecting unchanged services...")
+	hashes, reused, err := detectUnchangedServices(f.selectedServices, f.absComposeFile, salts, runFlowForceBuild)
+	if err != nil {
+		return buildErrorf("Error during change detection: %w", err)
+	}
+	f.record.Hashes = hashes
+	f.reusedImages = reused
+	if len(reused) == 0 {
+		return nil
+	}
+
+	// Only build the services that changed
+	f.buildServices = nil
+	for _, svc := range f.selectedServices {
+		if _, ok := reused[svc.Name]; !ok {
+			f.buildServices = append(f.buildServices, svc.Name)
 		}
+	}
+	if len(f.buildServices) == 0 {
+		outln("All the selected services are unchanged, build and push are skipped.")
+		f.skipBuild = true
+	}
+	return nil
+}
 
-		// === 7.b) Offer a prefix (registry + user) if the user wants
-		prefixChoice := ""
-		if registry != "" {
-			// Offer to add a prefix like "registry.com/myuser"
-			prefixChoice = readLine("Do you want to prefix the images with the selected registry (e.g., my-registry.com/user)? (Press ENTER to skip): ")
-		} else {
-			prefixChoice = readLine("Do you want to prefix the images (e.g., my-registry.com/user)? (Press ENTER to skip): ")
+// hashSalts returns the build inputs mixed into the build context hash of each selected service:
+// its build arguments, and where its image is pushed and for which platforms it is built.
+// An image is only reused when all of them are unchanged.
+func (f *flow) hashSalts() map[string]string {
+	var target []string
+	if f.buildx != nil {
+		platforms := strings.Join(f.buildx.Platforms, ",")
+		if platforms == "" {
+			// Derived from the deploy context at build time
+			platforms = "context:" + f.deployContext.Name
 		}
+		target = append(target, "platforms="+platforms)
+	}
+	if f.registry != "" {
+		target = append(target, "registry="+f.registry)
+	}
+	if f.prefixChoice != "" {
+		target = append(target, "prefix="+f.prefixChoice)
+	}
 
-		var newComposePath string
-		if tagChoice != "" || prefixChoice != "" {
-			// 7.c) Generate a new compose if tag or prefix is requested
-			newComposePath, err = generateTaggedCompose(composeFile, tagChoice, prefixChoice)
-			if err != nil {
-				fmt.Printf("Error generating the modified docker-compose file: %v\n", err)
-				return
-			}
-			fmt.Printf("New docker-compose created: %s\n", newComposePath)
-		} else {
-			// No tag or prefix => use the original composeFile
-			newComposePath = composeFile
-		}
-
-		// 7.d) Optional step: Prune before build
-		pruneChoice := readLine("Do you want to prune the build context? (y/n): ")
-		if strings.ToLower(pruneChoice) == "y" {
-			// Can split into two questions for precise control:
-			pruneImagesChoice := readLine("   > Remove unused Docker images (docker image prune -a)? (y/n): ")
-			if strings.ToLower(pruneImagesChoice) == "y" {
-				fmt.Println("==> Executing docker image prune...")
-				err = runCommand(
-					"docker",
-					"--context", buildContext.Name,
-					"image", "prune",
-					"-a",
-					"-f", // to force without asking for confirmation
-				)
-				if err != nil {
-					fmt.Printf("Error pruning images: %v\n", err)
-				}
-			}
+	salts := make(map[string]string)
+	for _, svc := range f.selectedServices {
+		parts := append([]string(nil), target...)
+		if args := buildArgsHash(f.buildArgs, svc.Name); args != "" {
+			parts = append(parts, "args="+args)
+		}
+		salts[svc.Name] = strings.Join(parts, "\x00")
+	}
+	return salts
+}
 
-			pruneBuilderChoice := readLine("   > Remove Docker builder cache (docker builder prune)? (y/n): ")
-			if strings.ToLower(pruneBuilderChoice) == "y" {
-				fmt.Println("==> Executing docker builder prune...")
-				err = runCommand(
-					"docker",
-					"--context", buildContext.Name,
-					"builder", "prune",
-					"-f",
-				)
-				if err != nil {
-					fmt.Printf("Error pruning builder: %v\n", err)
-				}
-			}
+// generateCompose writes the -tagged compose file when the images have to be rewritten
+func (f *flow) generateCompose() error {
+	opts := composeOptions{
+		Tag:       f.tagChoice,
+		Prefix:    f.prefixChoice,
+		Images:    f.reusedImages,
+		CacheFrom: f.cache.CacheFrom,
+		CacheTo:   f.cache.CacheTo,
+		BuildArgs: f.buildArgs,
+		Secrets:   f.buildSecrets,
+	}
+	if f.shipMode() == ShipSync {
+		// The synchronized bind mounts are mounted from the project directory
+		if opts.MountDir = f.projectDirectory(); opts.MountDir == "" {
+			return configErrorf("Synchronizing the bind mounts requires a project directory (project_directory, or projects_dir on the context '%s').", f.deployContext.Name)
+		}
+	}
+	// The secret files are mounted from their copy on the deploy host
+	var err error
+	if opts.SecretFiles, err = f.secretPaths(); err != nil {
+		return err
+	}
+	if f.projectDirectory() != "" {
+		// docker compose would look for the env files in the project directory of the deploy host
+		opts.EnvFileDir = filepath.Dir(f.absComposeFile)
+	}
+	if opts.isEmpty() {
+		// No tag, prefix or reused image => use the original composeFile
+		f.newComposePath = f.composeFile
+	} else {
+		// 7.c) Generate a new compose if tag or prefix is requested
+		f.newComposePath, err = generateTaggedCompose(f.composeFile, opts)
+		if err != nil {
+			return composeErrorf("Error generating the modified docker-compose file: %w", err)
+		}
+		outf("New docker-compose created: %s\n", f.newComposePath)
+	}
+
+	// Remember the final image of every selected service
+	services, err := compose.ParseServices(f.newComposePath)
+	if err != nil {
+		return composeErrorf("Error parsing the generated docker-compose: %w", err)
+	}
+	selected := make(map[string]bool)
+	for _, name := range f.record.Services {
+		selected[name] = true
+	}
+	f.record.Images = make(map[string]string)
+	for _, svc := range services {
+		if svc.Image != "" && selected[svc.Name] {
+			f.record.Images[svc.Name] = svc.Image
 		}
+	}
+	return nil
+}
+
+// prune optionally cleans the build context before the build
+func (f *flow) prune() error {
+	// 7.d) Optional step: Prune before build
+	if f.skipBuild {
+		return f.skip()
+	}
+	f.reportBuildCache("before")
+
+	// Configured filters => prune the builder cache without asking
+	if f.cache.PruneUntil != "" || f.cache.PruneKeepStorage != "" {
+		outln("==> Executing filtered builder prune...")
+		if err := f.pruneBuilderCache(f.cache.PruneUntil, f.cache.PruneKeepStorage); err != nil {
+			outf("Error pruning builder: %v\n", err)
+		}
+		f.reportBuildCache("after prune")
+		return nil
+	}
 
-		// 8) Build
-		fmt.Println("==> Building images...")
-		err = runCommand(
+	pruneChoice := ask("Do you want to prune the build context? (y/n): ", "n")
+	if strings.ToLower(pruneChoice) != "y" {
+		return nil
+	}
+
+	// Can split into two questions for precise control:
+	pruneImagesChoice := readLine("   > Remove unused Docker images (docker image prune -a)? (y/n): ")
+	if strings.ToLower(pruneImagesChoice) == "y" {
+		outln("==> Executing docker image prune...")
+		err := runCommandContext(f.ctx,
 			"docker",
-			"--context", buildContext.Name,
-			"compose",
-			"-f", newComposePath,
-			"build",
+			"--context", f.buildContext.Name,
+			"image", "prune",
+			"-a",
+			"-f", // to force without asking for confirmation
 		)
 		if err != nil {
-			fmt.Printf("Error during build: %v\n", err)
-			cleanupFile(newComposePath) // Optional cleanup
-			return
-		}
-
-		// 9) Push
-		pushChoice := readLine("Do you want to push the images to the selected registry? (y/n): ")
-		if strings.ToLower(pushChoice) == "y" && registry != "" {
-			fmt.Println("==> Pushing images...")
-			err = runCommand(
-				"docker",
-				"--context", buildContext.Name,
-				"compose",
-				"-f", newComposePath,
-				"push",
-			)
-			if err != nil {
-				fmt.Printf("Error during push: %v\n", err)
-				cleanupFile(newComposePath) // Optional cleanup
-				return
-			}
+			outf("Error pruning images: %v\n", err)
 		}
+	}
 
-		// 10) Deploy
-		deployChoice := readLine("Do you want to deploy the images in no-build mode? (y/n): ")
-		if strings.ToLower(deployChoice) == "y" {
-			fmt.Println("==> Deploying in no-build mode...")
-			err = runCommand(
-				"docker",
-				"--context", deployContext.Name,
-				"compose",
-				"-f", newComposePath,
-				"up",
-				"-d",
-				"--no-build",
-			)
-			if err != nil {
-				fmt.Printf("Error during deployment: %v\n", err)
-			} else {
-				fmt.Println("Deployment completed successfully!")
-			}
-		} else {
-			fmt.Println("Deployment canceled.")
+	pruneBuilderChoice := readLine("   > Remove Docker builder cache (docker builder prune)? (y/n): ")
+	if strings.ToLower(pruneBuilderChoice) == "y" {
+		until := readLine("   > Only remove the cache older than (e.g., 24h, ENTER for all): ")
+		outln("==> Executing docker builder prune...")
+		if err := f.pruneBuilderCache(until, ""); err != nil {
+			outf("Error pruning builder: %v\n", err)
 		}
+	}
+	f.reportBuildCache("after prune")
+	return nil
+}
 
-		// Optional cleanup of the file
-		cleanupChoice := readLine("Do you want to delete the temporary docker-compose file? (y/n): ")
-		if strings.ToLower(cleanupChoice) == "y" {
-			cleanupFile(newComposePath)
+// build builds the images on the build context
+func (f *flow) build() error {
+	// 8) Build
+	if f.skipBuild {
+		return f.skip()
+	}
+	if err := f.runHooks(HookPreBuild); err != nil {
+		return err
+	}
+	if f.buildx != nil {
+		if err := f.buildxBuild(); err != nil {
+			return err
 		}
-	},
+		f.reportBuildCache("after build")
+		return f.runHooks(HookPostBuild)
+	}
+	outln("==> Building images...")
+	err := f.runProgress("build", f.progressServices(), false,
+		append(append([]string{
+			"--context", f.buildContext.Name,
+			"compose",
+			"-f", f.newComposePath,
+		}, f.projectNameArgs()...), append([]string{"build"}, f.buildServices...)...)...,
+	)
+	if err != nil {
+		f.cleanupGenerated() // Optional cleanup
+		return buildErrorf("Error during build: %w", err)
+	}
+	f.reportBuildCache("after build")
+	return f.runHooks(HookPostBuild)
+}
+
+// push pushes the built images to the registry
+func (f *flow) push() error {
+	// 9) Push
+	if f.skipBuild || f.buildx != nil {
+		// The reused images are already in the registry, buildx pushes while building
+		f.record.Pushed = true
+		return f.skip()
+	}
+	f.printSummary("Push")
+	pushChoice := ask("Do you want to push the images to the selected registry? (y/n): ", "y")
+	if strings.ToLower(pushChoice) != "y" || f.registry == "" {
+		return f.skip()
+	}
+	if err := f.runHooks(HookPrePush); err != nil {
+		return err
+	}
+	outln("==> Pushing images...")
+	err := f.runProgress("push", f.progressServices(), true,
+		append(append([]string{
+			"--context", f.buildContext.Name,
+			"compose",
+			"-f", f.newComposePath,
+		}, f.projectNameArgs()...), append([]string{"push"}, f.buildServices...)...)...,
+	)
+	if err != nil {
+		f.cleanupGenerated() // Optional cleanup
+		return pushErrorf("Error during push: %w", err)
+	}
+	f.record.Pushed = true
+	return nil
+}
+
+// deploy starts the services on the deploy context
+func (f *flow) deploy() error {
+	// 10) Deploy
+	f.printSummary("Deploy")
+	deployChoice := ask("Do you want to deploy the images in no-build mode? (y/n): ", "y")
+	if strings.ToLower(deployChoice) != "y" {
+		outln("Deployment canceled.")
+		return f.skip()
+	}
+	if err := f.preflight(); err != nil {
+		return err
+	}
+
+	// The secrets of the profile are also visible to the deploy hooks (migrations...)
+	if err := f.injectSecrets(); err != nil {
+		return err
+	}
+	if err := f.runHooks(HookPreDeploy); err != nil {
+		return err
+	}
+	if err := f.checkEnvFiles(); err != nil {
+		return err
+	}
+	if err := f.shipProject(); err != nil {
+		return err
+	}
+	if err := f.deliverSecrets(); err != nil {
+		return err
+	}
+
+	// Pull the pushed images first, so that a flaky registry link is retried.
+	// A run started by watch deploys the new digests of the pulled images: "up" would keep
+	// the ones already on the deploy host, so they are always pulled.
+	pushed := f.record.Pushed && f.registry != ""
+	if pushed || f.record.Trigger != "" {
+		outln("==> Pulling images...")
+		args := []string{"pull"}
+		if !pushed {
+			// The built images were not pushed, they only exist on the build context
+			args = append(args, "--ignore-buildable")
+		}
+		_, err := runCommandRetry(f.ctx, f.retry, "docker", f.composeArgs(append(args, f.targetServices...)...)...)
+		if err != nil {
+			return deployErrorf("Error pulling the images: %w", err)
+		}
+	}
+
+	if f.strategy == StrategyRolling || f.strategy == StrategyBlueGreen {
+		f.record.Project = f.projectName()
+		deploy := f.rollingDeploy
+		if f.strategy == StrategyBlueGreen {
+			deploy = f.blueGreenDeploy
+		}
+		if err := deploy(); err != nil {
+			return err
+		}
+		outln("Deployment completed successfully!")
+		f.record.Deployed = true
+		return f.runHooks(HookPostDeploy)
+	}
+
+	outln("==> Deploying in no-build mode...")
+	f.record.Project = f.projectName()
+	err := runCommandContext(f.ctx, "docker", f.composeArgs(append([]string{"up", "-d", "--no-build"}, f.targetServices...)...)...)
+	if err != nil {
+		return deployErrorf("Error during deployment: %w", err)
+	}
+	outln("Deployment completed successfully!")
+	f.record.Deployed = true
+	return f.runHooks(HookPostDeploy)
+}
+
+// composeOptions lists the rewrites applied by generateTaggedCompose
+type composeOptions struct {
+	// Tag replaces the "latest" tags
+	Tag string
+	// Prefix is prepended to every image (e.g., my-registry.com/user)
+	Prefix string
+	// Images forces the image of some services (service name => image)
+	Images map[string]string
+	// CacheFrom / CacheTo set the build cache sources and destinations ({service} is replaced)
+	CacheFrom []string
+	CacheTo   []string
+	// BuildArgs / Secrets are added to the build section of the services
+	BuildArgs []buildArg
+	Secrets   []buildSecret
+	// MountDir replaces the directory of the compose file in the relative bind mounts
+	MountDir string
+	// EnvFileDir makes the relative env files absolute (local directory of the compose file)
+	EnvFileDir string
+	// SecretFiles replaces the file of the secrets (secret name => path on the deploy host)
+	SecretFiles map[string]string
+}
+
+// isEmpty reports whether the options leave the compose file untouched
+func (o composeOptions) isEmpty() bool {
+	return o.Tag == "" && o.Prefix == "" && len(o.Images) == 0 &&
+		len(o.CacheFrom) == 0 && len(o.CacheTo) == 0 &&
+		len(o.BuildArgs) == 0 && len(o.Secrets) == 0 && o.MountDir == "" &&
+		o.EnvFileDir == "" && len(o.SecretFiles) == 0
 }
 
 // generateTaggedCompose reads the original docker-compose as a map[string]interface{}.
 // 1. Read the entire YAML content.
-// 2. ONLY modify the 'image' key for each service (based on prefix / tagChoice).
+// 2. ONLY modify the 'image' and 'build' keys of each service (based on the options).
 // 3. Rewrite a new complete docker-compose, keeping all fields intact.
-func generateTaggedCompose(originalPath, tagChoice, prefix string) (string, error) {
+func generateTaggedCompose(originalPath string, opts composeOptions) (string, error) {
 	// 1) Read the YAML file as binary
 	data, err := os.ReadFile(originalPath)
 	if err != nil {
@@ -246,54 +889,57 @@ func generateTaggedCompose(originalPath, tagChoice, prefix string) (string, erro
 			continue
 		}
 
-		// Check if 'image' exists
-		imageVal, ok := svcMap["image"].(string)
-		if !ok {
-			// No image => do not modify
-			continue
+		if reused, ok := opts.Images[svcName]; ok {
+			// Reused image => take it as-is
+			svcMap["image"] = reused
+		} else if imageVal, ok := svcMap["image"].(string); ok {
+			// Otherwise apply the prefix and the tag (no image => do not modify)
+			svcMap["image"] = rewriteImage(imageVal, opts.Tag, opts.Prefix)
 		}
 
-		// Split to extract the repo part and the tag part
-		// Example: "xpdemon/ac-wotlk-authserver:test"
-		// parts[0] = "xpdemon/ac-wotlk-authserver", parts[1] = "test"
-		parts := strings.SplitN(imageVal, ":", 2)
-		repoPart := parts[0]
-		var oldTag string
-		if len(parts) == 2 {
-			oldTag = parts[1]
+		// 4.e) Build cache import/export
+		if build := buildSection(svcMap); build != nil {
+			if len(opts.CacheFrom) > 0 {
+				build["cache_from"] = expandServicePlaceholder(opts.CacheFrom, svcName)
+			}
+			if len(opts.CacheTo) > 0 {
+				build["cache_to"] = expandServicePlaceholder(opts.CacheTo, svcName)
+			}
 		}
 
-		// 4.a) Add a prefix, if requested
-		//    If prefix = "my-registry.com/myuser" and repoPart = "mysql", => "my-registry.com/myuser/mysql"
-		//    Otherwise, if the image already contains "/", it's up to you how to concatenate.
-		if prefix != "" {
-			repoPart = fmt.Sprintf("%s/%s", prefix, repoPart)
+		// 4.f) Relative bind mounts => project directory of the deploy host
+		if opts.MountDir != "" {
+			rewriteBindMounts(svcMap, opts.MountDir)
 		}
 
-		// 4.b) Replace the tag ONLY if it was "latest" (and if tagChoice != "")
-		//     - oldTag == "latest" => replace with tagChoice
-		//     - oldTag != "latest" => leave as is
-		//     - no tag => do nothing
-		if oldTag == "latest" && tagChoice != "" {
-			oldTag = tagChoice
+		if opts.EnvFileDir != "" {
+			absEnvFiles(svcMap, opts.EnvFileDir)
 		}
-
-		// 4.c) Reconstruct the image
-		var newImage string
-		if oldTag == "" {
-			// No tag => keep just "repoPart"
-			newImage = repoPart
-		} else {
-			newImage = fmt.Sprintf("%s:%s", repoPart, oldTag)
+		if build := buildSection(svcMap); build != nil && len(opts.SecretFiles) > 0 {
+			for _, name := range secretNames(build["secrets"]) {
+				if _, ok := opts.SecretFiles[name]; ok {
+					return "", fmt.Errorf("secret '%s' is used by the build and by the containers of '%s', declare two secrets", name, svcName)
+				}
+			}
 		}
 
-		// 4.d) Update in the map
-		svcMap["image"] = newImage
+		// 4.g) Update in the map
 		servicesRaw[svcName] = svcMap
 	}
 
 	// 5) Reinstate the modified services section into raw
 	raw["services"] = servicesRaw
+	if len(opts.SecretFiles) > 0 {
+		topLevel, _ := raw["secrets"].(map[string]interface{})
+		for name, file := range opts.SecretFiles {
+			if secret, ok := topLevel[name].(map[string]interface{}); ok {
+				secret["file"] = file
+			}
+		}
+	}
+	if err := injectBuildInputs(raw, opts.BuildArgs, opts.Secrets); err != nil {
+		return "", err
+	}
 
 	// 6) Marshal back to YAML in a new file
 	newYaml, err := yaml.Marshal(raw)
@@ -317,6 +963,130 @@ func generateTaggedCompose(originalPath, tagChoice, prefix string) (string, erro
 	return newPath, nil
 }
 
+// rewriteImage applies the prefix and the tag to an image reference
+func rewriteImage(imageVal, tagChoice, prefix string) string {
+	// Split to extract the repo part and the tag part
+	// Example: "xpdemon/ac-wotlk-authserver:test"
+	// parts[0] = "xpdemon/ac-wotlk-authserver", parts[1] = "test"
+	parts := strings.SplitN(imageVal, ":", 2)
+	repoPart := parts[0]
+	var oldTag string
+	if len(parts) == 2 {
+		oldTag = parts[1]
+	}
+
+	// a) Add a prefix, if requested
+	//    If prefix = "my-registry.com/myuser" and repoPart = "mysql", => "my-registry.com/myuser/mysql"
+	//    Otherwise, if the image already contains "/", it's up to you how to concatenate.
+	if prefix != "" {
+		repoPart = fmt.Sprintf("%s/%s", prefix, repoPart)
+	}
+
+	// b) Replace the tag ONLY if it was "latest" (and if tagChoice != "")
+	//     - oldTag == "latest" => replace with tagChoice
+	//     - oldTag != "latest" => leave as is
+	//     - no tag => do nothing
+	if oldTag == "latest" && tagChoice != "" {
+		oldTag = tagChoice
+	}
+
+	// c) Reconstruct the image
+	if oldTag == "" {
+		// No tag => keep just "repoPart"
+		return repoPart
+	}
+	return fmt.Sprintf("%s:%s", repoPart, oldTag)
+}
+
+// rewriteBindMounts makes the relative bind mounts of a service absolute, from dir
+// (short syntax "./conf:/etc/app:ro" and long syntax {type: bind, source: ./conf})
+func rewriteBindMounts(svcMap map[string]interface{}, dir string) {
+	volumes, ok := svcMap["volumes"].([]interface{})
+	if !ok {
+		return
+	}
+	for i, v := range volumes {
+		switch vol := v.(type) {
+		case string:
+			source, rest, ok := strings.Cut(vol, ":")
+			if ok && compose.IsRelativePath(source) {
+				volumes[i] = path.Join(dir, source) + ":" + rest
+			}
+		case map[string]interface{}:
+			source, _ := vol["source"].(string)
+			if vol["type"] == "bind" && compose.IsRelativePath(source) {
+				vol["source"] = path.Join(dir, source)
+			}
+		}
+	}
+}
+
+// absEnvFiles makes the relative env files of a service absolute, from dir
+func absEnvFiles(svcMap map[string]interface{}, dir string) {
+	abs := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	switch envFiles := svcMap["env_file"].(type) {
+	case string:
+		svcMap["env_file"] = abs(envFiles)
+	case []interface{}:
+		for i, e := range envFiles {
+			switch entry := e.(type) {
+			case string:
+				envFiles[i] = abs(entry)
+			case map[string]interface{}:
+				if p, ok := entry["path"].(string); ok {
+					entry["path"] = abs(p)
+				}
+			}
+		}
+	}
+}
+
+// secretNames returns the names of a "secrets" list (short and long syntax)
+func secretNames(list interface{}) []string {
+	items, _ := list.([]interface{})
+	var names []string
+	for _, item := range items {
+		switch secret := item.(type) {
+		case string:
+			names = append(names, secret)
+		case map[string]interface{}:
+			if source, ok := secret["source"].(string); ok {
+				names = append(names, source)
+			}
+		}
+	}
+	return names
+}
+
+// buildSection returns the "build" map of a service, converting the short syntax
+// (build: ./dir) to the long one. It returns nil if the service is not built.
+func buildSection(svcMap map[string]interface{}) map[string]interface{} {
+	switch build := svcMap["build"].(type) {
+	case map[string]interface{}:
+		return build
+	case string:
+		long := map[string]interface{}{"context": build}
+		svcMap["build"] = long
+		return long
+	default:
+		return nil
+	}
+}
+
+// expandServicePlaceholder replaces {service} with the service name in every value
+func expandServicePlaceholder(values []string, svcName string) []string {
+	expanded := make([]string, 0, len(values))
+	for _, v := range values {
+		expanded = append(expanded, strings.ReplaceAll(v, "{service}", svcName))
+	}
+	return expanded
+}
+
 // validateTag checks the format of the tag (allowed characters)
 func validateTag(tag string) error {
 	// Ex. Docker tag must be lowercase alphanum + . _ -
@@ -339,9 +1109,9 @@ func cleanupFile(path string) {
 	if _, err := os.Stat(path); err == nil {
 		err = os.Remove(path)
 		if err == nil {
-			fmt.Printf("Temporary file deleted: %s\n", path)
+			outf("Temporary file deleted: %s\n", path)
 		} else {
-			fmt.Printf("Unable to delete file %s: %v\n", path, err)
+			outf("Unable to delete file %s: %v\n", path, err)
 		}
 	}
 }
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/secrets"
+)
+
+// secretsPassphraseEnv holds the passphrase of the secrets store in non-interactive runs
+const secretsPassphraseEnv = "XPD_SECRETS_PASSPHRASE"
+
+// secretKeyRegexp is the format of a secret key (an environment variable name)
+var secretKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var secretsProfile string
+
+func init() {
+	SecretsCmd.PersistentFlags().StringVarP(&secretsProfile, "profile", "p", "", "Profile (environment) of the secrets (required)")
+	SecretsCmd.AddCommand(secretsSetCmd, secretsGetCmd, secretsListCmd, secretsRmCmd)
+}
+
+// SecretsCmd manages the encrypted deployment variables of the profiles
+var SecretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage the encrypted deployment variables of a profile (injected by run-flow)",
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set KEY",
+	Short: "Set a variable (the value is asked without echo, or read from stdin when piped)",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		if secretsProfile == "" {
+			return inputErrorf("The --profile flag is required.")
+		}
+		if !secretKeyRegexp.MatchString(key) {
+			return inputErrorf("Invalid key '%s': letters, digits and '_' only, not starting with a digit", key)
+		}
+		// A new store asks for the passphrase twice
+		values, passphrase, err := openSecrets(secretsProfile, !secrets.Exists(secretsProfile))
+		if err != nil {
+			return err
+		}
+		// The value is never taken from the command line (shell history, process list)
+		var value string
+		if stdinIsTerminal() {
+			value = readPassword("Value of " + key + ": ")
+		} else {
+			data, err := io.ReadAll(stdinReader)
+			if err != nil {
+				return inputErrorf("Unable to read the value from stdin: %w", err)
+			}
+			value = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		}
+		if value == "" {
+			return inputErrorf("Empty value, cancellation.")
+		}
+
+		_, updated := values[key]
+		values[key] = value
+		if err := secrets.Save(secretsProfile, passphrase, values); err != nil {
+			return configErrorf("Unable to save the secrets: %w", err)
+		}
+		if updated {
+			outf("Secret '%s' of '%s' updated.\n", key, secretsProfile)
+		} else {
+			outf("Secret '%s' of '%s' added.\n", key, secretsProfile)
+		}
+		emitResult(map[string]interface{}{"profile": secretsProfile, "key": key, "updated": updated})
+		return nil
+	},
+}
+
+var secretsGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print the value of a variable",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, _, err := openSecrets(secretsProfile, false)
+		if err != nil {
+			return err
+		}
+		value, ok := values[args[0]]
+		if !ok {
+			return inputErrorf("Secret '%s' not found in '%s'.", args[0], secretsProfile)
+		}
+		emitResult(map[string]interface{}{"profile": secretsProfile, "key": args[0], "value": value})
+		if !jsonOutput() {
+			outln(value)
+		}
+		return nil
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the variables (names only)",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, _, err := openSecrets(secretsProfile, false)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		emitResult(map[string]interface{}{"profile": secretsProfile, "keys": keys})
+		if len(keys) == 0 {
+			outf("No secrets for '%s'.\n", secretsProfile)
+		}
+		for _, k := range keys {
+			outln(k)
+		}
+		return nil
+	},
+}
+
+var secretsRmCmd = &cobra.Command{
+	Use:   "rm KEY",
+	Short: "Remove a variable",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, passphrase, err := openSecrets(secretsProfile, false)
+		if err != nil {
+			return err
+		}
+		if _, ok := values[args[0]]; !ok {
+			return inputErrorf("Secret '%s' not found in '%s'.", args[0], secretsProfile)
+		}
+		delete(values, args[0])
+		if len(values) == 0 {
+			err = secrets.Remove(secretsProfile)
+		} else {
+			err = secrets.Save(secretsProfile, passphrase, values)
+		}
+		if err != nil {
+			return configErrorf("Unable to save the secrets: %w", err)
+		}
+		outf("Secret '%s' of '%s' removed.\n", args[0], secretsProfile)
+		emitResult(map[string]interface{}{"profile": secretsProfile, "key": args[0], "removed": true})
+		return nil
+	},
+}
+
+// openSecrets asks for the passphrase (or reads XPD_SECRETS_PASSPHRASE) and decrypts the store.
+// confirm asks for the passphrase twice, when the store is created.
+func openSecrets(scope string, confirm bool) (map[string]string, string, error) {
+	if scope == "" {
+		return nil, "", inputErrorf("The --profile flag is required.")
+	}
+	if _, err := secrets.Path(scope); err != nil {
+		return nil, "", inputErrorf("Invalid profile: %w", err)
+	}
+	passphrase := os.Getenv(secretsPassphraseEnv)
+	if passphrase == "" {
+		passphrase = readPassword("Passphrase of the secrets of '" + scope + "': ")
+		if confirm && passphrase != "" && readPassword("Confirm the passphrase: ") != passphrase {
+			return nil, "", inputErrorf("The passphrases don't match.")
+		}
+	}
+	if passphrase == "" {
+		return nil, "", inputErrorf("No passphrase given (set %s for non-interactive use).", secretsPassphraseEnv)
+	}
+
+	values, err := secrets.Load(scope, passphrase)
+	if errors.Is(err, secrets.ErrWrongPassphrase) {
+		return nil, "", inputErrorf("Unable to decrypt the secrets of '%s': %w", scope, err)
+	}
+	if err != nil {
+		return nil, "", configErrorf("Unable to read the secrets of '%s': %w", scope, err)
+	}
+	return values, passphrase, nil
+}
+
+// injectSecrets decrypts the secrets of the profile and sets them in the environment
+// of the deploy commands and hooks: compose interpolates them (${DB_PASSWORD}) and
+// passes them to the containers (environment: [DB_PASSWORD]).
+func (f *flow) injectSecrets() error {
+	if f.record.Profile == "" || !secrets.Exists(f.record.Profile) {
+		return nil
+	}
+	values, _, err := openSecrets(f.record.Profile, false)
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		os.Setenv(k, v)
+	}
+	f.record.SecretKeys = sortedKeys(values)
+	outf("Secrets of '%s' injected: %d variable(s).\n", f.record.Profile, len(values))
+	return nil
+}
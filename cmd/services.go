@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// selectServices returns the services the flow should work on.
+//   - names != nil  => only the named services
+//   - changedOnly   => the built services, narrowed to the changed ones by the changes step
+//     (the build inputs are part of the comparison)
+//   - otherwise     => the user picks them interactively (ENTER = all)
+func selectServices(all []compose.Service, names []string, changedOnly bool) ([]compose.Service, error) {
+	if len(names) > 0 {
+		return servicesByName(all, names)
+	}
+	if changedOnly {
+		var built []compose.Service
+		for _, svc := range all {
+			if svc.HasBuild() {
+				built = append(built, svc)
+			} else {
+				outf("  - %s: no build section, skipped\n", svc.Name)
+			}
+		}
+		return built, nil
+	}
+
+	if interactive() && !runFlowYes {
+		items := make([]string, len(all))
+		for i, svc := range all {
+			items[i] = describeService(svc)
+		}
+		indexes, err := selectMany("Services to build/push/deploy", items)
+		if err != nil {
+			return nil, err
+		}
+		var selected []compose.Service
+		for _, idx := range indexes {
+			selected = appendService(selected, all[idx])
+		}
+		return selected, nil
+	}
+
+	outln("Services in this docker-compose:")
+	for i, svc := range all {
+		outf("  [%d] %s\n", i, describeService(svc))
+	}
+	input := ask("Choose the indexes of the services to build/push/deploy (comma-separated, ENTER for all): ", "")
+	if input == "" {
+		return all, nil
+	}
+
+	var selected []compose.Service
+	for _, part := range strings.Split(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		idx, err := parseIndex(part, len(all))
+		if err != nil {
+			return nil, err
+		}
+		selected = appendService(selected, all[idx])
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("no service selected")
+	}
+	return selected, nil
+}
+
+// servicesByName returns the services matching the given names, in the order of the compose file
+func servicesByName(all []compose.Service, names []string) ([]compose.Service, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	var selected []compose.Service
+	for _, svc := range all {
+		if wanted[svc.Name] {
+			selected = append(selected, svc)
+			delete(wanted, svc.Name)
+		}
+	}
+	if len(wanted) > 0 {
+		var unknown []string
+		for n := range wanted {
+			unknown = append(unknown, n)
+		}
+		return nil, fmt.Errorf("unknown service(s): %s", strings.Join(unknown, ", "))
+	}
+	return selected, nil
+}
+
+// changedServices returns the built services whose build context or Dockerfile
+// was modified since the last successful deployment of the compose file.
+// The build context hash (salted like detectUnchangedServices) is compared when the deployment
+// recorded it, the modification times otherwise.
+// Without a previous deployment, every service is returned.
+func changedServices(all []compose.Service, composeFile string, salts map[string]string) ([]compose.Service, error) {
+	last, err := history.LastSuccessful(composeFile, history.Deployed)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the deployment history: %w", err)
+	}
+	if last == nil {
+		outln("No previous deployment found for this docker-compose, all services are selected.")
+		return all, nil
+	}
+
+	outf("Last deployment: %s (%s)\n", last.ID, last.StartedAt.Format(time.RFC3339))
+	var selected []compose.Service
+	for _, svc := range all {
+		if !svc.HasBuild() {
+			outf("  - %s: no build section, skipped\n", svc.Name)
+			continue
+		}
+		var changed bool
+		if previous, ok := last.Hashes[svc.Name]; ok {
+			var hash string
+			hash, err = serviceHash(svc, salts[svc.Name])
+			changed = hash != previous
+		} else {
+			changed, err = buildContextChangedSince(svc, last.StartedAt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to inspect the build context of '%s': %w", svc.Name, err)
+		}
+		if changed {
+			outf("  - %s: changed\n", svc.Name)
+			selected = append(selected, svc)
+		} else {
+			outf("  - %s: unchanged\n", svc.Name)
+		}
+	}
+	return selected, nil
+}
+
+// buildContextChangedSince reports whether a file of the build context (or the Dockerfile)
+// was modified after t. Remote build contexts (git, URL) are always considered changed.
+func buildContextChangedSince(svc compose.Service, t time.Time) (bool, error) {
+	if !svc.IsLocal() {
+		return true, nil
+	}
+
+	if info, err := os.Stat(svc.Dockerfile); err == nil && info.ModTime().After(t) {
+		return true, nil
+	}
+
+	errChanged := errors.New("changed")
+	err := filepath.WalkDir(svc.BuildContext, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(t) {
+			return errChanged
+		}
+		return nil
+	})
+	if errors.Is(err, errChanged) {
+		return true, nil
+	}
+	return false, err
+}
+
+// detectUnchangedServices computes the build context hash of the selected services and compares it
+// with the last successful run that pushed them. salts holds extra per-service inputs (e.g., build args)
+// mixed into the hash. It returns the hash of each built service and, for the unchanged ones,
+// the previously pushed image to reuse (empty when force is set).
+func detectUnchangedServices(services []compose.Service, composeFile string, salts map[string]string, force bool) (map[string]string, map[string]string, error) {
+	records, err := history.List()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read the run history: %w", err)
+	}
+
+	hashes := make(map[string]string)
+	reused := make(map[string]string)
+	for _, svc := range services {
+		if !svc.IsLocal() {
+			continue
+		}
+		hash, err := serviceHash(svc, salts[svc.Name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to hash the build context of '%s': %w", svc.Name, err)
+		}
+		hashes[svc.Name] = hash
+		if force {
+			continue
+		}
+
+		// Find the last run that pushed this service (records are sorted newest first)
+		for _, rec := range records {
+			if rec.Status != history.StatusSuccess || !rec.Pushed || rec.ComposeFile != composeFile {
+				continue
+			}
+			previous, ok := rec.Hashes[svc.Name]
+			if !ok {
+				continue
+			}
+			if previous == hash && rec.Images[svc.Name] != "" {
+				reused[svc.Name] = rec.Images[svc.Name]
+				outf("  - %s: unchanged since run %s, reusing %s\n", svc.Name, rec.ID, rec.Images[svc.Name])
+			}
+			break
+		}
+	}
+	return hashes, reused, nil
+}
+
+// serviceHash returns the hash of the build context of a service, mixed with the salt
+// of its other build inputs (the value stored in the run records)
+func serviceHash(svc compose.Service, salt string) (string, error) {
+	hash, err := compose.HashBuildContext(svc)
+	if err != nil || salt == "" {
+		return hash, err
+	}
+	sum := sha256.Sum256([]byte(hash + salt))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// appendService adds svc to the list if it is not already present
+func appendService(list []compose.Service, svc compose.Service) []compose.Service {
+	for _, s := range list {
+		if s.Name == svc.Name {
+			return list
+		}
+	}
+	return append(list, svc)
+}
+
+// serviceNames returns the names of the services
+func serviceNames(services []compose.Service) []string {
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	return names
+}
+
+// describeService returns a one-line description of a service (image and build context)
+func describeService(svc compose.Service) string {
+	desc := svc.Name
+	if svc.Image != "" {
+		desc += fmt.Sprintf(" (image=%s)", svc.Image)
+	}
+	if svc.HasBuild() {
+		desc += fmt.Sprintf(" (build=%s)", svc.BuildContext)
+	}
+	return desc
+}
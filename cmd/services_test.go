@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+func testServices() []compose.Service {
+	return []compose.Service{
+		{Name: "api", BuildContext: "/app/api"},
+		{Name: "db", Image: "postgres:16"},
+		{Name: "web", BuildContext: "/app/web"},
+	}
+}
+
+func TestSelectServices(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       []string
+		changedOnly bool
+		input       string
+		want        []string
+		wantErr     string
+	}{
+		{name: "by name, in the order of the compose file", names: []string{"web", "api"}, want: []string{"api", "web"}},
+		{name: "unknown name", names: []string{"api", "cache"}, wantErr: "unknown service(s): cache"},
+		{name: "changed only keeps the built services", changedOnly: true, want: []string{"api", "web"}},
+		{name: "names win over changed", names: []string{"db"}, changedOnly: true, want: []string{"db"}},
+		{name: "ENTER selects all", input: "\n", want: []string{"api", "db", "web"}},
+		{name: "typed indexes, duplicates removed", input: "2, 0,2\n", want: []string{"web", "api"}},
+		{name: "invalid index", input: "0,3\n", wantErr: "invalid index"},
+		{name: "no index", input: " , \n", wantErr: "no service selected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discardStdout(t)
+			feedStdin(t, tt.input)
+			got, err := selectServices(testServices(), tt.names, tt.changedOnly)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("selectServices error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("selectServices: %v", err)
+			}
+			if names := serviceNames(got); !slices.Equal(names, tt.want) {
+				t.Errorf("selectServices = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangedServices(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docker-compose.yml": "services: {}\n",
+		"api/Dockerfile":     "FROM scratch\n",
+		"api/main.go":        "package main\n",
+		"web/Dockerfile":     "FROM scratch\n",
+		"web/index.html":     "<html></html>\n",
+	})
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	services := []compose.Service{
+		{Name: "api", BuildContext: filepath.Join(dir, "api"), Dockerfile: filepath.Join(dir, "api/Dockerfile")},
+		{Name: "db", Image: "postgres:16"},
+		{Name: "web", BuildContext: filepath.Join(dir, "web"), Dockerfile: filepath.Join(dir, "web/Dockerfile")},
+	}
+
+	// Never deployed: every service
+	got, err := changedServices(services, composeFile, nil)
+	if err != nil {
+		t.Fatalf("changedServices: %v", err)
+	}
+	if names := serviceNames(got); len(names) != 3 {
+		t.Errorf("changedServices without a deployment = %v, want all", names)
+	}
+
+	// Deployed after the files were written, then one file of web is modified
+	deployedAt := time.Now().Add(time.Second)
+	rec := &history.Record{ID: "20261019-120000.000", StartedAt: deployedAt, Status: history.StatusSuccess, ComposeFile: composeFile, Deployed: true}
+	if err := history.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	later := deployedAt.Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "web/index.html"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, err = changedServices(services, composeFile, nil)
+	if err != nil {
+		t.Fatalf("changedServices: %v", err)
+	}
+	if names := serviceNames(got); !slices.Equal(names, []string{"web"}) {
+		t.Errorf("changedServices = %v, want [web]", names)
+	}
+
+	// A run that didn't deploy is not a reference
+	rec = &history.Record{ID: "20261019-130000.000", StartedAt: later.Add(time.Minute), Status: history.StatusSuccess, ComposeFile: composeFile}
+	if err := history.Save(rec); err != nil {
+		t.Fatal(err)
+	}
+	got, _ = changedServices(services, composeFile, nil)
+	if names := serviceNames(got); !slices.Equal(names, []string{"web"}) {
+		t.Errorf("changedServices after a run without deployment = %v, want [web]", names)
+	}
+}
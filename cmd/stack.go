@@ -0,0 +1,320 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+var (
+	stackProfile string
+	stackRun     string
+
+	logsFollow     bool
+	logsTail       string
+	logsSince      string
+	psAll          bool
+	execUser       string
+	execNoTTY      bool
+	downVolumes    bool
+	downAssumeYes  bool
+	restartTimeout int
+)
+
+func init() {
+	for _, c := range []*cobra.Command{LogsCmd, PsCmd, ExecCmd, RestartCmd, DownCmd, DriftCmd} {
+		c.Flags().StringVarP(&stackProfile, "profile", "p", "", "Target the last deployment of this profile (its settings when it was never deployed)")
+		c.Flags().StringVar(&stackRun, "run", "", "Target the deployment of this run ID (default: the last deployment)")
+	}
+	LogsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Follow the log output")
+	LogsCmd.Flags().StringVar(&logsTail, "tail", "all", "Number of lines to show from the end of the logs of each container")
+	LogsCmd.Flags().StringVar(&logsSince, "since", "", "Show the logs since a timestamp or a relative time (e.g., 42m)")
+	PsCmd.Flags().BoolVarP(&psAll, "all", "a", false, "Show the stopped containers too")
+	ExecCmd.Flags().StringVarP(&execUser, "user", "u", "", "Run the command as this user")
+	ExecCmd.Flags().BoolVarP(&execNoTTY, "no-tty", "T", false, "Don't allocate a pseudo-TTY (default when stdin is not a terminal)")
+	// The flags after the service belong to the command run in the container
+	ExecCmd.Flags().SetInterspersed(false)
+	RestartCmd.Flags().IntVarP(&restartTimeout, "timeout", "t", -1, "Seconds to wait for the containers to stop before killing them (default: the one of the services)")
+	DownCmd.Flags().BoolVarP(&downVolumes, "volumes", "v", false, "Remove the named volumes of the project too")
+	DownCmd.Flags().BoolVarP(&downAssumeYes, "yes", "y", false, "Don't ask for confirmation")
+}
+
+// stack is a deployed compose project, and what the compose commands need to address it
+type stack struct {
+	runID   string
+	context string
+	// composeFile is the generated compose file of the deployment (the original one when it was deleted),
+	// "" when neither remains: the project is then addressed by its name only
+	composeFile string
+	project     string
+	directory   string
+	// secretEnvFiles were loaded in the environment of the deployment,
+	// secretKeys are the variables it got from the secrets store
+	secretEnvFiles []string
+	secretKeys     []string
+}
+
+// LogsCmd shows the logs of a deployed stack
+var LogsCmd = &cobra.Command{
+	Use:   "logs [SERVICE...]",
+	Short: "Show the logs of the services of a deployed stack",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		composeArgs := []string{"logs", "--tail", logsTail}
+		if logsFollow {
+			composeArgs = append(composeArgs, "--follow")
+		}
+		if logsSince != "" {
+			composeArgs = append(composeArgs, "--since", logsSince)
+		}
+		err = s.run(append(composeArgs, args...)...)
+		// Ctrl-C is the way out of --follow
+		if err != nil && logsFollow && interrupted() {
+			err = nil
+		}
+		return s.result("logs", err)
+	},
+}
+
+// PsCmd lists the containers of a deployed stack
+var PsCmd = &cobra.Command{
+	Use:   "ps [SERVICE...]",
+	Short: "List the containers of a deployed stack",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		composeArgs := []string{"ps"}
+		if psAll {
+			composeArgs = append(composeArgs, "--all")
+		}
+		return s.result("ps", s.run(append(composeArgs, args...)...))
+	},
+}
+
+// ExecCmd runs a command in a service container of a deployed stack
+var ExecCmd = &cobra.Command{
+	Use:   "exec SERVICE COMMAND [ARG...]",
+	Short: "Run a command in a service container of a deployed stack",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		composeArgs := []string{"exec"}
+		if execNoTTY || !stdinIsTerminal() {
+			composeArgs = append(composeArgs, "-T")
+		}
+		if execUser != "" {
+			composeArgs = append(composeArgs, "--user", execUser)
+		}
+		return s.result("exec", s.run(append(composeArgs, args...)...))
+	},
+}
+
+// RestartCmd restarts the services of a deployed stack
+var RestartCmd = &cobra.Command{
+	Use:   "restart [SERVICE...]",
+	Short: "Restart the services of a deployed stack",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		composeArgs := []string{"restart"}
+		if restartTimeout >= 0 {
+			composeArgs = append(composeArgs, "--timeout", strconv.Itoa(restartTimeout))
+		}
+		return s.result("restart", s.run(append(composeArgs, args...)...))
+	},
+}
+
+// DownCmd stops and removes the containers of a deployed stack
+var DownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Stop and remove the containers and networks of a deployed stack",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		s, err := loadStack()
+		if err != nil {
+			return err
+		}
+		if !downAssumeYes {
+			what := "containers and networks"
+			if downVolumes {
+				what = "containers, networks and volumes"
+			}
+			choice := readLine("Do you want to remove the " + what + " of " + s.project + " on '" + s.context + "'? (y/n): ")
+			if strings.ToLower(choice) != "y" {
+				outln("Down canceled.")
+				return nil
+			}
+		}
+		composeArgs := []string{"down"}
+		if downVolumes {
+			composeArgs = append(composeArgs, "--volumes")
+		}
+		return s.result("down", s.run(composeArgs...))
+	},
+}
+
+// loadStack resolves the stack targeted by --run or --profile (the last deployment by default)
+func loadStack() (*stack, error) {
+	if stackRun != "" && stackProfile != "" {
+		return nil, inputErrorf("--run can't be used with --profile.")
+	}
+	rec, err := findDeployedRun(stackProfile, stackRun)
+	if err != nil {
+		return nil, err
+	}
+
+	// A temporary flow resolves the project like the deploy step did
+	f := &flow{ctx: appCtx, record: &history.Record{ID: history.NewID()}}
+	contextName, generated := "", ""
+	switch {
+	case rec != nil:
+		if rec.Checkpoint != nil {
+			f.profile = rec.Checkpoint.Inputs
+			generated = rec.Checkpoint.GeneratedCompose
+		}
+		contextName = rec.DeployContext
+		f.absComposeFile = rec.ComposeFile
+		outf("==> Deployment %s (%s) on '%s'\n", rec.ID, rec.FinishedAt.Local().Format(time.DateTime), contextName)
+	case stackProfile != "":
+		p, ok := config.FindProfile(stackProfile)
+		if !ok {
+			return nil, configErrorf("Profile '%s' not found in the configuration.", stackProfile)
+		}
+		if p.DeployContext == "" || p.ComposeFile == "" {
+			return nil, configErrorf("Profile '%s' has never been deployed and has no deploy context or compose file.", p.Name)
+		}
+		f.profile = *p
+		contextName = p.DeployContext
+		if f.absComposeFile, err = filepath.Abs(p.ComposeFile); err != nil {
+			return nil, composeErrorf("Invalid compose file '%s': %w", p.ComposeFile, err)
+		}
+		outf("==> Profile '%s' has never been deployed, using its settings\n", p.Name)
+	default:
+		return nil, inputErrorf("No deployment in the history: use --profile to target a profile.")
+	}
+
+	var ok bool
+	if f.deployContext, ok = config.FindContext(contextName); !ok {
+		return nil, configErrorf("Deploy context '%s' is not registered.", contextName)
+	}
+	if err := f.loadDeployConfig(); err != nil {
+		return nil, err
+	}
+
+	s := &stack{context: contextName, directory: f.projectDirectory(), project: f.projectName()}
+	s.secretEnvFiles = f.profile.SecretEnvFiles
+	if rec != nil {
+		s.runID = rec.ID
+		if rec.Project != "" {
+			s.project = rec.Project
+		}
+		s.secretKeys = rec.SecretKeys
+	}
+	// The traffic may have been switched back since the deployment
+	if f.strategy == StrategyBlueGreen {
+		base := f.blueGreenProject()
+		state, err := f.loadBlueGreenState(base)
+		if err != nil {
+			return nil, configErrorf("Unable to read the blue-green state: %w", err)
+		}
+		if state.Active != "" {
+			s.project = base + "-" + state.Active
+		}
+	}
+	for _, path := range []string{generated, f.absComposeFile} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			s.composeFile = path
+			break
+		}
+	}
+	if s.composeFile == "" {
+		outln("The compose files of the deployment no longer exist, the project is addressed by its name only.")
+	} else if s.composeFile != generated && generated != "" {
+		outf("The generated compose file was deleted, using %s.\n", s.composeFile)
+	}
+	outf("Project %s on '%s'\n", s.project, s.context)
+	return s, nil
+}
+
+// findDeployedRun returns the run with the given ID, or the last run that deployed
+// the profile (any profile when empty). It returns nil when there is none.
+func findDeployedRun(profile, id string) (*history.Record, error) {
+	if id != "" && id != "last" {
+		rec, err := history.Load(id)
+		if err != nil {
+			return nil, inputErrorf("%w", err)
+		}
+		if !rec.Deployed {
+			return nil, inputErrorf("Run '%s' did not deploy anything.", id)
+		}
+		return rec, nil
+	}
+	records, err := history.List()
+	if err != nil {
+		return nil, configErrorf("Unable to read the run history: %w", err)
+	}
+	for i := range records {
+		if records[i].Deployed && (profile == "" || records[i].Profile == profile) {
+			return &records[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// args returns the docker arguments of a compose command on the stack
+func (s *stack) args(args ...string) []string {
+	base := []string{"--context", s.context, "compose", "-p", s.project}
+	if s.composeFile != "" {
+		base = append(base, "-f", s.composeFile)
+		base = append(base, projectDirectoryArgs(s.directory, s.composeFile)...)
+	}
+	return append(base, args...)
+}
+
+// run executes a compose command on the stack, attached to the terminal
+func (s *stack) run(args ...string) error {
+	args = s.args(args...)
+	outf("=> Command: docker %s\n", strings.Join(args, " "))
+	start := time.Now()
+	cmd := commandContext(appCtx, "docker", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = out()
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	emitCommand("docker", args, start, err)
+	return err
+}
+
+// result reports the outcome of a stack command
+func (s *stack) result(command string, err error) error {
+	if err != nil {
+		return deployErrorf("docker compose %s failed on %s: %w", command, s.project, err)
+	}
+	emitResult(map[string]interface{}{
+		"run":          s.runID,
+		"context":      s.context,
+		"project":      s.project,
+		"compose_file": s.composeFile,
+	})
+	return nil
+}
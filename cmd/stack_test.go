@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// saveRecords stores the records in the history, started one minute apart in order
+func saveRecords(t *testing.T, records ...*history.Record) {
+	t.Helper()
+	start := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	for i, rec := range records {
+		rec.StartedAt = start.Add(time.Duration(i) * time.Minute)
+		rec.FinishedAt = rec.StartedAt.Add(30 * time.Second)
+		if err := history.Save(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindDeployedRun(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if rec, err := findDeployedRun("", ""); err != nil || rec != nil {
+		t.Errorf("findDeployedRun with an empty history = %v, %v", rec, err)
+	}
+	saveRecords(t,
+		&history.Record{ID: "shop-1", Profile: "shop", Status: history.StatusSuccess, Deployed: true},
+		&history.Record{ID: "blog-1", Profile: "blog", Status: history.StatusSuccess, Deployed: true},
+		&history.Record{ID: "shop-2", Profile: "shop", Status: history.StatusFailed, Pushed: true},
+	)
+
+	tests := []struct {
+		profile, id string
+		want        string
+		wantErr     string
+	}{
+		{want: "blog-1"},
+		{id: "last", want: "blog-1"},
+		{profile: "shop", want: "shop-1"},
+		{profile: "docs"},
+		{id: "shop-1", want: "shop-1"},
+		{id: "shop-2", wantErr: "did not deploy anything"},
+		{id: "unknown", wantErr: "not found"},
+	}
+	for _, tt := range tests {
+		rec, err := findDeployedRun(tt.profile, tt.id)
+		if tt.wantErr != "" {
+			var inputErr *InputError
+			if !errors.As(err, &inputErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("findDeployedRun(%q, %q) error = %v, want %q", tt.profile, tt.id, err, tt.wantErr)
+			}
+			continue
+		}
+		got := ""
+		if rec != nil {
+			got = rec.ID
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("findDeployedRun(%q, %q) = %q, %v, want %q", tt.profile, tt.id, got, err, tt.want)
+		}
+	}
+}
+
+func TestStackArgs(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose-tagged.yml": "services: {}\n", ".env": "TAG=1.2\n"})
+	generated := filepath.Join(dir, "docker-compose-tagged.yml")
+	tests := []struct {
+		name string
+		s    stack
+		want []string
+	}{
+		{"name only", stack{context: "prod", project: "shop"}, []string{"--context", "prod", "compose", "-p", "shop", "ps"}},
+		{"compose file", stack{context: "prod", project: "shop", composeFile: generated},
+			[]string{"--context", "prod", "compose", "-p", "shop", "-f", generated, "ps"}},
+		{"project directory", stack{context: "prod", project: "shop", composeFile: generated, directory: "/srv/shop"},
+			[]string{"--context", "prod", "compose", "-p", "shop", "-f", generated, "--project-directory", "/srv/shop", "--env-file", filepath.Join(dir, ".env"), "ps"}},
+	}
+	for _, tt := range tests {
+		if got := tt.s.args("ps"); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: args = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLoadStack(t *testing.T) {
+	discardStdout(t)
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"docker-compose.yml": "services: {}\n", "docker-compose-tagged.yml": "services: {}\n"})
+	composeFile := filepath.Join(dir, "docker-compose.yml")
+	generated := filepath.Join(dir, "docker-compose-tagged.yml")
+	setTestConfig(t, config.AppConfig{
+		DockerContexts: []config.DockerContext{{Name: "prod", Host: "ssh://deploy@prod"}},
+		Profiles: []config.Profile{
+			{Name: "docs", DeployContext: "prod", ComposeFile: composeFile, Project: "docs"},
+			{Name: "draft", ComposeFile: composeFile},
+		},
+	})
+	fakeDocker(t, "")
+	saveRecords(t,
+		&history.Record{
+			ID: "shop-1", Profile: "shop", Status: history.StatusSuccess, Deployed: true,
+			DeployContext: "prod", ComposeFile: composeFile, Project: "shop", SecretKeys: []string{"DB_PASSWORD"},
+			Checkpoint: &history.Checkpoint{Inputs: config.Profile{Project: "shop", ProjectDirectory: "/srv/shop"}, GeneratedCompose: generated},
+		},
+		&history.Record{
+			ID: "shop-2", Profile: "shop", Status: history.StatusSuccess, Deployed: true,
+			DeployContext: "prod", ComposeFile: composeFile, Project: "shop-blue",
+			Checkpoint: &history.Checkpoint{Inputs: config.Profile{Project: "shop", Deploy: &config.DeployConfig{Strategy: StrategyBlueGreen}}},
+		},
+	)
+	savedRun, savedProfile := stackRun, stackProfile
+	defer func() { stackRun, stackProfile = savedRun, savedProfile }()
+
+	// 1) A deployment with its generated compose file
+	stackRun, stackProfile = "shop-1", ""
+	s, err := loadStack()
+	if err != nil {
+		t.Fatalf("loadStack: %v", err)
+	}
+	want := stack{runID: "shop-1", context: "prod", composeFile: generated, project: "shop", directory: "/srv/shop", secretKeys: []string{"DB_PASSWORD"}}
+	if s.runID != want.runID || s.context != want.context || s.composeFile != want.composeFile || s.project != want.project ||
+		s.directory != want.directory || !slices.Equal(s.secretKeys, want.secretKeys) {
+		t.Errorf("loadStack = %+v, want %+v", *s, want)
+	}
+
+	// 2) The last deployment, blue-green: the traffic was switched back to green since
+	f := &flow{deployContext: config.DockerContext{Name: "prod"}}
+	if err := f.saveBlueGreenState("shop", blueGreenState{Active: ColorGreen}); err != nil {
+		t.Fatal(err)
+	}
+	stackRun = ""
+	if s, err := loadStack(); err != nil || s.runID != "shop-2" || s.project != "shop-green" || s.composeFile != composeFile {
+		t.Errorf("loadStack of a blue-green deployment = %+v, %v", s, err)
+	}
+
+	// 3) A profile never deployed uses its settings
+	stackProfile = "docs"
+	if s, err := loadStack(); err != nil || s.runID != "" || s.project != "docs" || s.composeFile != composeFile {
+		t.Errorf("loadStack of a profile never deployed = %+v, %v", s, err)
+	}
+	stackProfile = "draft"
+	if _, err := loadStack(); err == nil || !strings.Contains(err.Error(), "has never been deployed") {
+		t.Errorf("loadStack of an incomplete profile = %v", err)
+	}
+	stackRun = "shop-1"
+	if _, err := loadStack(); err == nil || !strings.Contains(err.Error(), "--run can't be used with --profile") {
+		t.Errorf("loadStack with --run and --profile = %v", err)
+	}
+}
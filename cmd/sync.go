@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// syncFile is a local file of the bind mounts
+type syncFile struct {
+	// rel is the path relative to the directory of the compose file (slash-separated)
+	rel  string
+	abs  string
+	info fs.FileInfo
+	// sum is the SHA-256 of the content (empty for a symlink, which is always uploaded)
+	sum  string
+	link string
+}
+
+// syncBindMounts uploads the bind mounts of the compose file to the project directory:
+// only the files whose checksum differs from the remote copy are sent, like rsync --checksum.
+// The remote files missing locally are kept (the containers may have written them), unless
+// sync_delete is set, like rsync --delete. The delivered secret files (.secrets/) are left
+// out on both sides.
+func (f *flow) syncBindMounts(dir string) error {
+	paths, err := f.shippedPaths(false)
+	if err != nil {
+		return composeErrorf("Unable to synchronize the bind mounts: %w", err)
+	}
+	if len(paths) == 0 {
+		outln("No relative bind mount to synchronize.")
+		return nil
+	}
+	remote, err := remoteShell(f.deployContext.Host)
+	if err != nil {
+		return contextErrorf("Unable to synchronize the bind mounts to '%s': %w", f.deployContext.Name, err)
+	}
+	outf("==> Synchronizing %s to %s:%s...\n", strings.Join(paths, ", "), f.deployContext.Name, dir)
+
+	// 1) Checksums of both sides
+	local, err := localManifest(filepath.Dir(f.absComposeFile), paths)
+	if err != nil {
+		return composeErrorf("Unable to read the bind mounts: %w", err)
+	}
+	remoteSums, err := f.remoteManifest(remote, dir, paths)
+	if err != nil {
+		return deployErrorf("Unable to read the files of %s:%s: %w", f.deployContext.Name, dir, err)
+	}
+
+	// 2) Differences
+	upload, remove := syncPlan(local, remoteSums, f.profile.SyncDelete)
+	if len(upload) == 0 && len(remove) == 0 {
+		outln("Bind mounts are up to date.")
+		return nil
+	}
+	outf("%d file(s) to upload, %d to delete.\n", len(upload), len(remove))
+	for _, file := range upload {
+		if _, ok := remoteSums[file.rel]; ok {
+			outf("  - %s: replaced by the local copy\n", file.rel)
+		}
+	}
+	for _, rel := range remove {
+		outf("  - %s: deleted (missing locally)\n", rel)
+	}
+
+	// 3) The directories are always sent (empty ones included), then the changed files
+	var entries []syncFile
+	for _, file := range local {
+		if file.info.IsDir() {
+			entries = append(entries, file)
+		}
+	}
+	entries = append(entries, upload...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+
+	name, args := remote(syncCommand(dir, remove))
+	if err := streamArchive(f.ctx, entries, name, args); err != nil {
+		return deployErrorf("Error synchronizing the bind mounts: %w", err)
+	}
+	return nil
+}
+
+// syncPlan returns the local files to upload (new, changed or symlinks) and, when deleteExtra
+// is set, the remote files to delete because they are missing locally
+func syncPlan(local map[string]syncFile, remoteSums map[string]string, deleteExtra bool) ([]syncFile, []string) {
+	var upload []syncFile
+	for _, file := range local {
+		if file.info.IsDir() {
+			continue
+		}
+		if file.sum == "" || remoteSums[file.rel] != file.sum {
+			upload = append(upload, file)
+		}
+	}
+	sort.Slice(upload, func(i, j int) bool { return upload[i].rel < upload[j].rel })
+
+	var remove []string
+	if deleteExtra {
+		for rel := range remoteSums {
+			if _, ok := local[rel]; !ok {
+				remove = append(remove, rel)
+			}
+		}
+		sort.Strings(remove)
+	}
+	return upload, remove
+}
+
+// syncCommand returns the shell command extracting the archive in dir, then deleting the files
+func syncCommand(dir string, remove []string) string {
+	command := fmt.Sprintf("mkdir -p %s && cd %s && tar -xzf -", shellQuote(dir), shellQuote(dir))
+	if len(remove) > 0 {
+		quoted := make([]string, 0, len(remove))
+		for _, rel := range remove {
+			quoted = append(quoted, shellQuote(rel))
+		}
+		command += " && rm -f -- " + strings.Join(quoted, " ")
+	}
+	return command
+}
+
+// localManifest walks the paths and returns their files and directories by relative path
+func localManifest(baseDir string, paths []string) (map[string]syncFile, error) {
+	files := make(map[string]syncFile)
+	for _, p := range paths {
+		err := filepath.WalkDir(filepath.Join(baseDir, p), func(abs string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(baseDir, abs)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			file := syncFile{rel: filepath.ToSlash(rel), abs: abs, info: info}
+			if file.rel == secretsDir {
+				if info.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			switch {
+			case info.Mode()&fs.ModeSymlink != 0:
+				if file.link, err = os.Readlink(abs); err != nil {
+					return err
+				}
+			case info.Mode().IsRegular():
+				if file.sum, err = fileChecksum(abs); err != nil {
+					return err
+				}
+			case !info.IsDir():
+				return nil // sockets, devices... can't be shipped
+			}
+			files[file.rel] = file
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// remoteManifest returns the SHA-256 of the regular files under the paths on the deploy host
+func (f *flow) remoteManifest(remote func(string) (string, []string), dir string, paths []string) (map[string]string, error) {
+	quoted := make([]string, 0, len(paths))
+	for _, p := range paths {
+		quoted = append(quoted, shellQuote(p))
+	}
+	// A missing directory (first synchronization) or path is not an error.
+	// The delivered secrets are never listed, so never deleted.
+	command := fmt.Sprintf("cd %s 2>/dev/null || exit 0; find %s \\( -path ./%[3]s -o -path %[3]s \\) -prune -o -type f -exec sha256sum {} + 2>/dev/null; exit 0",
+		shellQuote(dir), strings.Join(quoted, " "), secretsDir)
+	name, args := remote(command)
+	output, err := commandOutput(f.ctx, name, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		sum, rel, ok := strings.Cut(line, "  ")
+		if !ok {
+			continue
+		}
+		sums[strings.TrimPrefix(rel, "./")] = sum
+	}
+	return sums, nil
+}
+
+// streamArchive writes the entries as a tar.gz archive to the standard input of the command
+func streamArchive(ctx context.Context, entries []syncFile, name string, args []string) error {
+	outf("=> Command: %s %s\n", name, strings.Join(args, " "))
+	start := time.Now()
+	cmd := commandContext(ctx, name, args...)
+	cmd.Stdout = out()
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	writeErr := writeArchive(stdin, entries)
+	stdin.Close()
+	err = cmd.Wait()
+	emitCommand(name, args, start, err)
+	if err != nil {
+		return err
+	}
+	return writeErr
+}
+
+// writeArchive writes the entries as a tar.gz archive
+func writeArchive(w io.Writer, entries []syncFile) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		header, err := tar.FileInfoHeader(e.info, e.link)
+		if err != nil {
+			return err
+		}
+		header.Name = e.rel
+		if e.info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !e.info.Mode().IsRegular() {
+			continue
+		}
+		if err := copyFile(tw, e.abs); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// fileChecksum returns the hex SHA-256 of a file (same format as sha256sum)
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
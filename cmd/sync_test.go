@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// fakeInfo is the fs.FileInfo of a syncFile built by the tests
+type fakeInfo struct {
+	fs.FileInfo
+	dir bool
+}
+
+func (i fakeInfo) IsDir() bool { return i.dir }
+
+func TestSyncPlan(t *testing.T) {
+	local := map[string]syncFile{
+		"conf":           {rel: "conf", info: fakeInfo{dir: true}},
+		"conf/same.conf": {rel: "conf/same.conf", info: fakeInfo{}, sum: "aaa"},
+		"conf/edit.conf": {rel: "conf/edit.conf", info: fakeInfo{}, sum: "bbb"},
+		"conf/new.conf":  {rel: "conf/new.conf", info: fakeInfo{}, sum: "ccc"},
+		"conf/link":      {rel: "conf/link", info: fakeInfo{}, link: "same.conf"},
+	}
+	remote := map[string]string{
+		"conf/same.conf":  "aaa",
+		"conf/edit.conf":  "old",
+		"conf/link":       "aaa",
+		"conf/written.db": "ddd",
+	}
+	tests := []struct {
+		name        string
+		deleteExtra bool
+		wantUpload  []string
+		wantRemove  []string
+	}{
+		{"remote files kept", false, []string{"conf/edit.conf", "conf/link", "conf/new.conf"}, nil},
+		{"remote files deleted", true, []string{"conf/edit.conf", "conf/link", "conf/new.conf"}, []string{"conf/written.db"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload, remove := syncPlan(local, remote, tt.deleteExtra)
+			var got []string
+			for _, file := range upload {
+				got = append(got, file.rel)
+			}
+			if !slices.Equal(got, tt.wantUpload) {
+				t.Errorf("upload = %v, want %v", got, tt.wantUpload)
+			}
+			if !slices.Equal(remove, tt.wantRemove) {
+				t.Errorf("remove = %v, want %v", remove, tt.wantRemove)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for _, value := range []string{"plain", "with space", `it's`, `"double"`, `$HOME`, "a\nb", "`x`", `\`} {
+		output, err := commandOutput(context.Background(), "sh", "-c", "printf %s "+shellQuote(value))
+		if err != nil {
+			t.Fatalf("sh: %v", err)
+		}
+		if output != value {
+			t.Errorf("sh read %q as %q", value, output)
+		}
+	}
+}
+
+func TestSyncBindMounts(t *testing.T) {
+	discardStdout(t)
+	local, remote := t.TempDir(), filepath.Join(t.TempDir(), "shop")
+	writeFiles(t, local, map[string]string{
+		"docker-compose.yml":          "services: {}\n",
+		"my conf/it's \"quoted\".txt": "v1\n",
+		"my conf/plain.conf":          "v1\n",
+	})
+	f := &flow{
+		ctx:            context.Background(),
+		absComposeFile: filepath.Join(local, "docker-compose.yml"),
+		allServices:    []compose.Service{{Name: "web", BindMounts: []string{"./my conf"}}},
+		deployContext:  config.DockerContext{Name: "local", Host: "unix:///var/run/docker.sock"},
+	}
+
+	// 1) First synchronization
+	if err := f.syncBindMounts(remote); err != nil {
+		t.Fatalf("syncBindMounts: %v", err)
+	}
+	checkFile(t, remote, "my conf/it's \"quoted\".txt", "v1\n")
+	checkFile(t, remote, "my conf/plain.conf", "v1\n")
+
+	// 2) A file written by a container and a delivered secret are kept, a changed file is replaced
+	writeFiles(t, remote, map[string]string{"my conf/written.db": "data\n", ".secrets/1/pw": "x\n"})
+	writeFiles(t, local, map[string]string{"my conf/it's \"quoted\".txt": "v2\n"})
+	os.Remove(filepath.Join(local, "my conf/plain.conf"))
+	if err := f.syncBindMounts(remote); err != nil {
+		t.Fatalf("syncBindMounts: %v", err)
+	}
+	checkFile(t, remote, "my conf/it's \"quoted\".txt", "v2\n")
+	checkFile(t, remote, "my conf/plain.conf", "v1\n")
+	checkFile(t, remote, "my conf/written.db", "data\n")
+
+	// 3) With sync_delete, the files missing locally are deleted
+	f.profile.SyncDelete = true
+	if err := f.syncBindMounts(remote); err != nil {
+		t.Fatalf("syncBindMounts: %v", err)
+	}
+	for _, rel := range []string{"my conf/plain.conf", "my conf/written.db"} {
+		if _, err := os.Stat(filepath.Join(remote, rel)); !os.IsNotExist(err) {
+			t.Errorf("%s was not deleted: %v", rel, err)
+		}
+	}
+	checkFile(t, remote, "my conf/it's \"quoted\".txt", "v2\n")
+	checkFile(t, remote, ".secrets/1/pw", "x\n")
+}
+
+// writeFiles creates the files (relative path => content) under dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkFile fails the test when the file under dir doesn't have the content
+func checkFile(t *testing.T, dir, rel, want string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		t.Errorf("%s: %v", rel, err)
+	} else if string(data) != want {
+		t.Errorf("%s = %q, want %q", rel, data, want)
+	}
+}
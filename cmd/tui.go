@@ -0,0 +1,536 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// plainPrompts disables the interactive selection (--plain)
+var plainPrompts bool
+
+// AddPlainFlag registers the global --plain flag on the root command
+func AddPlainFlag(root *cobra.Command) {
+	root.PersistentFlags().BoolVar(&plainPrompts, "plain", false, "Plain prompts (type the indexes) instead of the interactive selection on a terminal")
+}
+
+// maxVisibleItems is the height of the interactive lists (they scroll beyond)
+const maxVisibleItems = 10
+
+// Terminal control sequences
+const (
+	ansiBold       = "\x1b[1m"
+	ansiDim        = "\x1b[2m"
+	ansiReset      = "\x1b[0m"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiClearDown  = "\x1b[J"
+)
+
+// interactive reports whether the prompts can use the terminal UI:
+// stdin and the prompts are a terminal, and --plain is not set.
+// Otherwise (pipes, CI, TERM=dumb) the prompts read plain lines.
+func interactive() bool {
+	if plainPrompts || os.Getenv("TERM") == "dumb" || !isTerminal(os.Stdin) {
+		return false
+	}
+	if _, err := exec.LookPath("stty"); err != nil {
+		return false
+	}
+	w, ok := out().(*os.File)
+	return ok && isTerminal(w)
+}
+
+// isTerminal reports whether f is a character device (a terminal)
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// stdinIsTerminal reports whether stdin is an actual terminal:
+// /dev/null is a character device too, stty tells the real terminals
+func stdinIsTerminal() bool {
+	if !isTerminal(os.Stdin) {
+		return false
+	}
+	_, err := stty("-g")
+	return err == nil
+}
+
+// stty runs stty on the terminal of stdin
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// rawMode switches the terminal to key-by-key input without echo, and returns the function
+// restoring it. Ctrl-C still sends SIGINT.
+func rawMode() (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1", "time", "0"); err != nil {
+		return nil, err
+	}
+	return func() { stty(state) }, nil
+}
+
+// terminalWidth returns the number of columns of the terminal (80 when unknown)
+func terminalWidth() int {
+	size, err := stty("size")
+	if fields := strings.Fields(size); err == nil && len(fields) == 2 {
+		if cols, err := strconv.Atoi(fields[1]); err == nil && cols > 0 {
+			return cols
+		}
+	}
+	return 80
+}
+
+// Keys of the interactive lists
+const (
+	keyNone = iota
+	keyRune
+	keyUp
+	keyDown
+	keyEnter
+	keyEscape
+	keyBackspace
+	keySpace
+	keyTab
+	keySelectAll
+)
+
+type key struct {
+	code int
+	r    rune
+}
+
+// readKey reads one key press from stdin (arrow keys are escape sequences)
+func readKey() (key, error) {
+	b, err := stdinReader.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	switch b {
+	case '\r', '\n':
+		return key{code: keyEnter}, nil
+	case 27: // ESC, alone or starting a sequence
+		if stdinReader.Buffered() < 2 {
+			return key{code: keyEscape}, nil
+		}
+		prefix, _ := stdinReader.ReadByte()
+		code, _ := stdinReader.ReadByte()
+		if prefix == '[' || prefix == 'O' {
+			switch code {
+			case 'A':
+				return key{code: keyUp}, nil
+			case 'B':
+				return key{code: keyDown}, nil
+			}
+			// Other sequences (e.g., "ESC [ 3 ~") are ignored
+			for code >= '0' && code <= '9' && stdinReader.Buffered() > 0 {
+				code, _ = stdinReader.ReadByte()
+			}
+		}
+		return key{code: keyNone}, nil
+	case 127, 8:
+		return key{code: keyBackspace}, nil
+	case '\t':
+		return key{code: keyTab}, nil
+	case ' ':
+		return key{code: keySpace}, nil
+	case 1: // Ctrl-A
+		return key{code: keySelectAll}, nil
+	case 16: // Ctrl-P
+		return key{code: keyUp}, nil
+	case 14: // Ctrl-N
+		return key{code: keyDown}, nil
+	}
+	if b < 32 {
+		return key{code: keyNone}, nil
+	}
+	if err := stdinReader.UnreadByte(); err != nil {
+		return key{}, err
+	}
+	r, _, err := stdinReader.ReadRune()
+	return key{code: keyRune, r: r}, err
+}
+
+// listPrompt is an interactive list: the arrows move, typing filters the items (fuzzy search),
+// Enter validates. In multi mode, Space or Tab toggles an item and Ctrl-A toggles them all.
+type listPrompt struct {
+	title    string
+	items    []string
+	multi    bool
+	selected []bool
+	filter   []rune
+	// cursor is the position in the filtered list, offset the first visible position
+	cursor int
+	offset int
+	// lines is the number of lines drawn, erased by the next render
+	lines int
+	width int
+	w     io.Writer
+}
+
+// selectOne asks the user to pick one item, and returns its index
+func selectOne(title string, items []string) (int, error) {
+	p := &listPrompt{title: title, items: items}
+	indexes, err := p.run()
+	if err != nil {
+		return -1, err
+	}
+	return indexes[0], nil
+}
+
+// selectMany asks the user to pick items (all of them are selected at first),
+// and returns their indexes in order
+func selectMany(title string, items []string) ([]int, error) {
+	p := &listPrompt{title: title, items: items, multi: true, selected: make([]bool, len(items))}
+	for i := range p.selected {
+		p.selected[i] = true
+	}
+	return p.run()
+}
+
+// run shows the list until an item is validated
+func (p *listPrompt) run() ([]int, error) {
+	if len(p.items) == 0 {
+		return nil, fmt.Errorf("nothing to choose from")
+	}
+	restore, err := rawMode()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the terminal: %w", err)
+	}
+	p.w = out()
+	p.width = terminalWidth()
+	fmt.Fprint(p.w, ansiHideCursor)
+	defer func() {
+		fmt.Fprint(p.w, ansiShowCursor)
+		restore()
+	}()
+
+	for {
+		p.render()
+		// stdinReader returns as soon as the run is interrupted
+		k, err := readKey()
+		if interrupted() {
+			p.erase()
+			return nil, appCtx.Err()
+		}
+		if err != nil {
+			p.erase()
+			return nil, fmt.Errorf("unable to read the terminal: %w", err)
+		}
+
+		visible := p.visible()
+		switch k.code {
+		case keyUp:
+			if p.cursor > 0 {
+				p.cursor--
+			}
+		case keyDown:
+			if p.cursor < len(visible)-1 {
+				p.cursor++
+			}
+		case keyRune:
+			p.filter = append(p.filter, k.r)
+			p.cursor, p.offset = 0, 0
+		case keySpace:
+			if !p.multi {
+				p.filter = append(p.filter, ' ')
+				p.cursor, p.offset = 0, 0
+				break
+			}
+			fallthrough
+		case keyTab:
+			if p.multi && len(visible) > 0 {
+				p.selected[visible[p.cursor]] = !p.selected[visible[p.cursor]]
+			}
+		case keySelectAll:
+			if p.multi {
+				all := !p.allSelected()
+				for i := range p.selected {
+					p.selected[i] = all
+				}
+			}
+		case keyBackspace:
+			if len(p.filter) > 0 {
+				p.filter = p.filter[:len(p.filter)-1]
+				p.cursor, p.offset = 0, 0
+			}
+		case keyEscape:
+			if len(p.filter) > 0 {
+				p.filter = nil
+				p.cursor, p.offset = 0, 0
+				break
+			}
+			p.erase()
+			return nil, fmt.Errorf("selection cancelled")
+		case keyEnter:
+			result := p.result(visible)
+			if len(result) == 0 {
+				continue
+			}
+			p.erase()
+			names := make([]string, 0, len(result))
+			for _, i := range result {
+				names = append(names, p.items[i])
+			}
+			fmt.Fprintf(p.w, "%s: %s\n", p.title, strings.Join(names, ", "))
+			return result, nil
+		}
+	}
+}
+
+// result returns the validated indexes (empty when nothing can be validated)
+func (p *listPrompt) result(visible []int) []int {
+	if !p.multi {
+		if len(visible) == 0 {
+			return nil
+		}
+		return []int{visible[p.cursor]}
+	}
+	var result []int
+	for i, ok := range p.selected {
+		if ok {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func (p *listPrompt) allSelected() bool {
+	for _, ok := range p.selected {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// visible returns the indexes of the items matching the filter, best matches first
+func (p *listPrompt) visible() []int {
+	type match struct{ index, score int }
+	var matches []match
+	for i, item := range p.items {
+		if score, ok := fuzzyScore(string(p.filter), item); ok {
+			matches = append(matches, match{i, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score > matches[b].score })
+	indexes := make([]int, len(matches))
+	for i, m := range matches {
+		indexes[i] = m.index
+	}
+	return indexes
+}
+
+// render draws the list in place of the previous drawing
+func (p *listPrompt) render() {
+	visible := p.visible()
+	if p.cursor < p.offset {
+		p.offset = p.cursor
+	}
+	if p.cursor >= p.offset+maxVisibleItems {
+		p.offset = p.cursor - maxVisibleItems + 1
+	}
+
+	help := "↑/↓ move, type to search, Enter select, Esc cancel"
+	if p.multi {
+		help = "↑/↓ move, Space toggle, Ctrl-A all, type to search, Enter confirm"
+	}
+	var b strings.Builder
+	b.WriteString(ansiBold + "? " + p.title + ansiReset + "  " + ansiDim + help + ansiReset + "\n")
+	lines := 1
+	if len(p.filter) > 0 {
+		b.WriteString("  search: " + string(p.filter) + "\n")
+		lines++
+	}
+	if len(visible) == 0 {
+		b.WriteString(ansiDim + "  (no match)" + ansiReset + "\n")
+		lines++
+	}
+	for pos := p.offset; pos < len(visible) && pos < p.offset+maxVisibleItems; pos++ {
+		i := visible[pos]
+		line := "  "
+		if pos == p.cursor {
+			line = "> "
+		}
+		if p.multi {
+			if p.selected[i] {
+				line += "[x] "
+			} else {
+				line += "[ ] "
+			}
+		}
+		line = truncate(line+p.items[i], p.width-1)
+		if pos == p.cursor {
+			line = ansiBold + line + ansiReset
+		}
+		b.WriteString(line + "\n")
+		lines++
+	}
+	if hidden := len(visible) - maxVisibleItems; hidden > 0 {
+		b.WriteString(ansiDim + fmt.Sprintf("  (%d more)", hidden) + ansiReset + "\n")
+		lines++
+	}
+
+	p.erase()
+	fmt.Fprint(p.w, b.String())
+	p.lines = lines
+}
+
+// erase removes the lines drawn by the last render
+func (p *listPrompt) erase() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA\r%s", p.lines, ansiClearDown)
+		p.lines = 0
+	}
+}
+
+// truncate shortens s to width runes (the lines must not wrap, or the redraw breaks)
+func truncate(s string, width int) string {
+	if width < 4 || utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
+
+// fuzzyScore matches the runes of pattern, in order, in s (case-insensitive).
+// Consecutive runes and word starts score higher; ok is false when s doesn't match.
+func fuzzyScore(pattern, s string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	target := []rune(strings.ToLower(s))
+	score, last := 0, -2
+	pos := 0
+	for _, r := range strings.ToLower(pattern) {
+		found := false
+		for ; pos < len(target); pos++ {
+			if target[pos] != r {
+				continue
+			}
+			score++
+			if pos == last+1 {
+				score += 3
+			}
+			if pos == 0 || !unicode.IsLetter(target[pos-1]) && !unicode.IsDigit(target[pos-1]) {
+				score += 2
+			}
+			last = pos
+			pos++
+			found = true
+			break
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	return score, true
+}
+
+// parseIndex parses an index typed at a plain prompt: a number between 0 and n-1
+func parseIndex(s string, n int) (int, error) {
+	s = strings.TrimSpace(s)
+	idx, err := strconv.Atoi(s)
+	if err != nil || idx < 0 || idx >= n {
+		return -1, fmt.Errorf("invalid index '%s' (expected 0 to %d)", s, n-1)
+	}
+	return idx, nil
+}
+
+// printSummary prints what a confirmation applies to ("Push" or "Deploy"), on a terminal only
+// (the output of scripted runs is left unchanged)
+func (f *flow) printSummary(kind string) {
+	if !interactive() {
+		return
+	}
+	services := "all"
+	if len(f.targetServices) > 0 {
+		services = strings.Join(f.targetServices, ", ")
+	}
+	var rows [][2]string
+	switch kind {
+	case "Push":
+		images := make(map[string]string)
+		for _, name := range f.progressServices() {
+			images[name] = f.record.Images[name]
+		}
+		rows = [][2]string{
+			{"Registry", f.registry},
+			{"From", f.buildContext.Name},
+			{"Images", strings.Join(sortedValues(images), ", ")},
+		}
+	case "Deploy":
+		rows = [][2]string{
+			{"Context", fmt.Sprintf("%s (host=%s)", f.deployContext.Name, f.deployContext.Host)},
+			{"Project", f.projectName()},
+			{"Strategy", f.strategy},
+			{"Services", services},
+			{"Images", strings.Join(sortedValues(f.record.Images), ", ")},
+		}
+	}
+	printRows(kind+" summary", rows)
+}
+
+// printRows prints a title and aligned "label  value" rows (the empty values are left out)
+func printRows(title string, rows [][2]string) {
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row[0]))
+	}
+	outln(ansiBold + title + ansiReset)
+	for _, row := range rows {
+		if row[1] != "" {
+			outf("  %-*s  %s\n", width, row[0], row[1])
+		}
+	}
+}
+
+// showProgress prints a line per step of run-flow on a terminal (position, status, duration),
+// and returns the function removing the listener
+func (f *flow) showProgress() func() {
+	if !interactive() {
+		return func() {}
+	}
+	listeners := eventListeners
+	eventListeners = append(eventListeners, func(e Event) {
+		position := 0
+		for i, step := range f.steps {
+			if step == e.Step {
+				position = i + 1
+			}
+		}
+		switch e.Type {
+		case EventStepStarted:
+			outf("%s[%d/%d] %s%s\n", ansiBold, position, len(f.steps), e.Step, ansiReset)
+		case EventStepFinished:
+			duration := (time.Duration(e.Duration) * time.Millisecond).Round(100 * time.Millisecond)
+			switch e.Status {
+			case history.StatusSuccess:
+				outf("%s✔ %s (%s)%s\n", ansiDim, e.Step, duration, ansiReset)
+			case "skipped":
+				outf("%s– %s skipped%s\n", ansiDim, e.Step, ansiReset)
+			default:
+				outf("%s✖ %s %s (%s)%s\n", ansiBold, e.Step, e.Status, duration, ansiReset)
+			}
+		}
+	})
+	return func() { eventListeners = listeners }
+}
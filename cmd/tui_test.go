@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeTerminal makes the interactive lists read their keys from the input:
+// stty succeeds without a terminal until the end of the test
+func fakeTerminal(t *testing.T, input string) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$1\" in -g) echo saved ;; size) echo '24 100' ;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "stty"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	discardStdout(t)
+	feedStdin(t, input)
+}
+
+func TestParseIndex(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"0", 0, true},
+		{" 2 ", 2, true},
+		{"3", -1, false},
+		{"-1", -1, false},
+		{"", -1, false},
+		{"web", -1, false},
+	}
+	for _, tt := range tests {
+		got, err := parseIndex(tt.input, 3)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseIndex(%q, 3) = %d, %v, want %d", tt.input, got, err, tt.want)
+		}
+	}
+	if _, err := parseIndex("5", 3); err == nil || !strings.Contains(err.Error(), "expected 0 to 2") {
+		t.Errorf("parseIndex error = %v", err)
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "anything", true},
+		{"prd", "production", true},
+		{"PROD", "my-production", true},
+		{"dorp", "production", false},
+		{"xyz", "production", false},
+	} {
+		if _, ok := fuzzyScore(tt.pattern, tt.s); ok != tt.ok {
+			t.Errorf("fuzzyScore(%q, %q) matches = %v, want %v", tt.pattern, tt.s, ok, tt.ok)
+		}
+	}
+
+	// Consecutive runes and word starts come first
+	p := &listPrompt{items: []string{"worker-backend", "api", "web"}, filter: []rune("web")}
+	if got := p.visible(); !slices.Equal(got, []int{2, 0}) {
+		t.Errorf("visible = %v, want [2 0]", got)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("truncate = %q", got)
+	}
+	if got := truncate("ééééééééé", 5); got != "éééé…" {
+		t.Errorf("truncate = %q", got)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	feedStdin(t, "\x1b[A\x1b[B\x1bOA\x1b[3~a\xc3\xa9 \t\x01\x7f\r")
+	want := []key{
+		{code: keyUp}, {code: keyDown}, {code: keyUp}, {code: keyNone},
+		{code: keyRune, r: 'a'}, {code: keyRune, r: 'é'},
+		{code: keySpace}, {code: keyTab}, {code: keySelectAll}, {code: keyBackspace}, {code: keyEnter},
+	}
+	for i, w := range want {
+		got, err := readKey()
+		if err != nil || got != w {
+			t.Fatalf("key %d = %+v, %v, want %+v", i, got, err, w)
+		}
+	}
+}
+
+func TestSelectOne(t *testing.T) {
+	items := []string{"build-server", "production", "staging"}
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr string
+	}{
+		{"first item", "\r", 0, ""},
+		{"arrows", "\x1b[B\x1b[B\x1b[B\x1b[A\r", 1, ""},
+		{"search", "stg\r", 2, ""},
+		{"no match, then backspace", "stx\r\x7fg\r", 2, ""},
+		{"cancelled", "\x1b", -1, "selection cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeTerminal(t, tt.input)
+			got, err := selectOne("Deploy context", items)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("selectOne error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("selectOne = %d, %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectMany(t *testing.T) {
+	items := []string{"api", "db", "web"}
+	tests := []struct {
+		name  string
+		input string
+		want  []int
+	}{
+		{"everything by default", "\r", []int{0, 1, 2}},
+		{"toggle one", "\x1b[B \r", []int{0, 2}},
+		{"none, then one", "\x01\r\x1b[B\x1b[B\t\r", []int{2}},
+		{"toggle a search result", "db\t\r", []int{0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeTerminal(t, tt.input)
+			got, err := selectMany("Services", items)
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("selectMany = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/xpdemon/ac-deploy/config"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
 // getLocalDockerContexts returns the list of Docker contexts
@@ -17,7 +21,9 @@ func getLocalDockerContexts() ([]config.DockerContext, error) {
 	// For example: name|description|dockerEndpoint
 	formatString := "{{.Name}}|{{.Description}}|{{.DockerEndpoint}}"
 
-	cmd := exec.Command("docker", "context", "ls", "--format", formatString)
+	ctx, cancel := probeContext()
+	defer cancel()
+	cmd := commandContext(ctx, "docker", "context", "ls", "--format", formatString)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("Error executing docker context ls: %w", err)
@@ -42,42 +48,132 @@ func getLocalDockerContexts() ([]config.DockerContext, error) {
 	return result, nil
 }
 
-// readLine reads a line from standard input
-func readLine(prompt string) string {
-	fmt.Print(prompt)
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
-	return strings.TrimSpace(input)
+// stdinReader is shared by all the prompts, so that buffered input (e.g., answers piped by a script)
+// is not lost between two reads
+var stdinReader = bufio.NewReader(&stdinFeed{})
+
+// stdinFeed reads the standard input through a single goroutine, so that a read can be abandoned
+// when the run is interrupted: the data of an abandoned read goes to the next prompt.
+// The goroutine only reads when a prompt asks for input, so that the commands attached
+// to the terminal (docker login...) get theirs. It is used by the prompts only (one reader).
+type stdinFeed struct {
+	once     sync.Once
+	requests chan struct{}
+	chunks   chan stdinChunk
+	// reading is set while a read is in progress, left is the data not returned yet
+	reading bool
+	left    []byte
+	err     error
 }
 
-// strToInt converts a string to an int (simply)
-func strToInt(s string) int {
-	var i int
-	_, err := fmt.Sscanf(s, "%d", &i)
-	if err != nil {
-		return 0
+type stdinChunk struct {
+	data []byte
+	err  error
+}
+
+// Read returns the data of stdin, or the error of appCtx when the run is interrupted first
+func (f *stdinFeed) Read(p []byte) (int, error) {
+	f.once.Do(func() {
+		f.requests = make(chan struct{})
+		f.chunks = make(chan stdinChunk)
+		go func() {
+			for range f.requests {
+				buf := make([]byte, 4096)
+				n, err := os.Stdin.Read(buf)
+				f.chunks <- stdinChunk{data: buf[:n], err: err}
+			}
+		}()
+	})
+	if len(f.left) == 0 && f.err == nil {
+		if !f.reading {
+			f.requests <- struct{}{}
+			f.reading = true
+		}
+		select {
+		case c := <-f.chunks:
+			f.reading = false
+			f.left, f.err = c.data, c.err
+		case <-appCtx.Done():
+			return 0, appCtx.Err()
+		}
+	}
+	if len(f.left) == 0 {
+		return 0, f.err
 	}
-	return i
+	n := copy(p, f.left)
+	f.left = f.left[n:]
+	return n, nil
+}
+
+// readLine reads a line from standard input.
+// It returns an empty answer as soon as the run is interrupted (SIGINT/SIGTERM).
+func readLine(prompt string) string {
+	if interrupted() {
+		return ""
+	}
+	fmt.Fprint(out(), prompt)
+	input, _ := stdinReader.ReadString('\n')
+	if interrupted() {
+		fmt.Fprintln(out())
+		return ""
+	}
+	return strings.TrimSpace(input)
 }
 
 // runCommand executes a system command and redirects stdout/stderr
 func runCommand(name string, args ...string) error {
-	fmt.Printf("=> Command: %s %s\n", name, strings.Join(args, " "))
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	return runCommandContext(appCtx, name, args...)
+}
+
+// runCommandContext is runCommand stopped when ctx is done (interruption or step timeout)
+func runCommandContext(ctx context.Context, name string, args ...string) error {
+	outf("=> Command: %s %s\n", name, strings.Join(args, " "))
+	logf("=> Command: %s %s\n", name, strings.Join(args, " "))
+	start := time.Now()
+	cmd := commandContext(ctx, name, args...)
+	cmd.Stdout = io.MultiWriter(out(), logWriter())
+	cmd.Stderr = io.MultiWriter(os.Stderr, logWriter())
+	err := cmd.Run()
+	emitCommand(name, args, start, err)
+	return err
+}
+
+// commandOutput executes a command and returns its standard output (stderr is shown)
+func commandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	logf("=> Command: %s %s\n", name, strings.Join(args, " "))
+	start := time.Now()
+	cmd := commandContext(ctx, name, args...)
+	cmd.Stderr = io.MultiWriter(os.Stderr, logWriter())
+	output, err := cmd.Output()
+	logWriter().Write(output)
+	emitCommand(name, args, start, err)
+	return string(output), err
+}
+
+// ask prompts for an answer of the flow, or returns the given one without prompting with --yes
+func ask(prompt, yes string) string {
+	if runFlowYes {
+		outf("%s%s\n", prompt, yes)
+		return yes
+	}
+	return readLine(prompt)
 }
 
-// dockerLogin executes an interactive `docker login <registry>` (password mode)
+// dockerLogin executes an interactive `docker login <registry>` (password mode),
+// retried on transient failures (a wrong password is never retried)
 func dockerLogin(registry string) error {
+	policy, err := defaultRetryPolicy()
+	if err != nil {
+		return configErrorf("Invalid retry configuration: %w", err)
+	}
 	user := readLine("Username: ")
 	pass := readLine("Password/Token: ")
-	cmd := exec.Command("docker", "login", registry, "--username", user, "--password-stdin")
-	cmd.Stdin = strings.NewReader(pass)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	_, err = runWithRetry(appCtx, policy, 0, func(ctx context.Context) *exec.Cmd {
+		cmd := commandContext(ctx, "docker", "login", registry, "--username", user, "--password-stdin")
+		cmd.Stdin = strings.NewReader(pass)
+		return cmd
+	})
+	return err
 }
 
 // CheckDockerInstalled verifies that the docker command (and docker compose) are available
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReadLineAfterInterruption(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	savedStdin, savedStdout, savedReader, savedCtx := os.Stdin, os.Stdout, stdinReader, appCtx
+	defer func() { os.Stdin, os.Stdout, stdinReader, appCtx = savedStdin, savedStdout, savedReader, savedCtx }()
+	// The prompts are not shown
+	os.Stdin, os.Stdout, stdinReader = r, devNull, bufio.NewReader(&stdinFeed{})
+
+	// A prompt interrupted before any input returns an empty answer...
+	ctx, cancel := context.WithCancel(context.Background())
+	appCtx = ctx
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if got := readLine("first? "); got != "" {
+		t.Fatalf("interrupted readLine = %q, want an empty answer", got)
+	}
+
+	// ...and the line typed afterwards goes to the next prompt
+	appCtx = context.Background()
+	w.WriteString("second\nthird\n")
+	if got := readLine("second? "); got != "second" {
+		t.Errorf("readLine = %q, want %q", got, "second")
+	}
+	if got := readLine("third? "); got != "third" {
+		t.Errorf("readLine = %q, want %q", got, "third")
+	}
+	w.Close()
+	if got := readLine("eof? "); got != "" {
+		t.Errorf("readLine at EOF = %q, want an empty answer", got)
+	}
+}
+
+// discardStdout hides the messages printed on the standard output until the end of the test
+func discardStdout(t *testing.T) {
+	t.Helper()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = devNull
+	t.Cleanup(func() {
+		os.Stdout = saved
+		devNull.Close()
+	})
+}
+
+// feedStdin makes the prompts read the input until the end of the test
+func feedStdin(t *testing.T, input string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStdin, savedReader := os.Stdin, stdinReader
+	os.Stdin, stdinReader = r, bufio.NewReader(&stdinFeed{})
+	t.Cleanup(func() {
+		os.Stdin, stdinReader = savedStdin, savedReader
+		r.Close()
+	})
+	go func() {
+		w.WriteString(input)
+		w.Close()
+	}()
+}
@@ -0,0 +1,420 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xpdemon/ac-deploy/compose"
+	"github.com/xpdemon/ac-deploy/config"
+	"github.com/xpdemon/ac-deploy/history"
+)
+
+// Event types of the watch command
+const (
+	EventChangeDetected = "change_detected"
+	EventRunTriggered   = "run_triggered"
+)
+
+// watchMaxRetryDelay bounds the time between the retries of a failed run
+const watchMaxRetryDelay = time.Hour
+
+var (
+	watchProfile   string
+	watchImages    []string
+	watchGitBranch string
+	watchGitRemote string
+	watchInterval  time.Duration
+	watchDebounce  time.Duration
+	watchOnce      bool
+)
+
+func init() {
+	WatchCmd.Flags().StringVarP(&watchProfile, "profile", "p", "", "Profile run when a change is detected (required)")
+	WatchCmd.Flags().StringArrayVar(&watchImages, "image", nil, "Tag whose digest is watched in its registry (repeatable, default: the images of the compose file that are not built)")
+	WatchCmd.Flags().StringVar(&watchGitBranch, "git-branch", "", "Watch the new commits of this branch instead of the images (the working tree of the compose file is fast-forwarded before the run)")
+	WatchCmd.Flags().StringVar(&watchGitRemote, "git-remote", "origin", "Remote of the watched branch")
+	WatchCmd.Flags().DurationVar(&watchInterval, "interval", time.Minute, "Time between two checks")
+	WatchCmd.Flags().DurationVar(&watchDebounce, "debounce", 30*time.Second, "Time without new change before the run starts (several images pushed in a row trigger one run)")
+	WatchCmd.Flags().BoolVar(&watchOnce, "once", false, "Check once against the last deployed state and exit (for cron), without debounce")
+}
+
+// watchState is what the last run of a watched profile deployed
+type watchState struct {
+	// Digests holds the digest of each watched tag
+	Digests map[string]string `json:"digests,omitempty"`
+	// Commit is the last commit of the watched branch
+	Commit    string    `json:"commit,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// watcher polls the sources of a profile
+type watcher struct {
+	profile config.Profile
+	images  []string
+	// dir is the directory of the compose file (the git working tree)
+	dir string
+}
+
+// WatchCmd runs the flow of a profile when new images or commits are available
+var WatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Run the flow of a profile when its images get new digests or its branch new commits",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if watchProfile == "" {
+			return inputErrorf("The --profile flag is required.")
+		}
+		if watchInterval <= 0 || watchDebounce < 0 {
+			return inputErrorf("The interval must be positive and the debounce can't be negative.")
+		}
+		w, err := newWatcher(watchProfile)
+		if err != nil {
+			return err
+		}
+		// One watcher per profile, the runs themselves take the lock of the profile
+		unlock, err := history.Lock("watch-" + watchProfile)
+		var locked *history.LockedError
+		if errors.As(err, &locked) {
+			return lockedErrorf("Profile '%s' is already watched by the process %d.", watchProfile, locked.PID)
+		}
+		if err != nil {
+			return configErrorf("Unable to lock the profile '%s': %w", watchProfile, err)
+		}
+		defer unlock()
+		return w.watch()
+	},
+}
+
+// newWatcher resolves the watched sources of the profile
+func newWatcher(name string) (*watcher, error) {
+	p, ok := config.FindProfile(name)
+	if !ok {
+		return nil, configErrorf("Profile '%s' not found in the configuration.", name)
+	}
+	if p.ComposeFile == "" {
+		return nil, configErrorf("Profile '%s' has no compose file.", name)
+	}
+	composeFile, err := filepath.Abs(p.ComposeFile)
+	if err != nil {
+		return nil, composeErrorf("Invalid compose file '%s': %w", p.ComposeFile, err)
+	}
+	w := &watcher{profile: *p, images: watchImages, dir: filepath.Dir(composeFile)}
+	if watchGitBranch != "" || len(w.images) > 0 {
+		return w, nil
+	}
+
+	// The built images are pushed by the run itself: only the pulled ones are watched
+	services, err := compose.ParseServices(composeFile)
+	if err != nil {
+		return nil, composeErrorf("Error parsing docker-compose services: %w", err)
+	}
+	for _, svc := range services {
+		if !svc.HasBuild() && svc.Image != "" && !strings.Contains(svc.Image, "@") {
+			w.images = append(w.images, svc.Image)
+		}
+	}
+	if len(w.images) == 0 {
+		return nil, inputErrorf("Nothing to watch in %s: every service is built (use --image or --git-branch).", p.ComposeFile)
+	}
+	return w, nil
+}
+
+// watch polls the sources until interrupted (or once with --once)
+func (w *watcher) watch() error {
+	deployed, err := loadWatchState(w.profile.Name)
+	if err != nil {
+		return configErrorf("Unable to read the watch state: %w", err)
+	}
+	if watchGitBranch != "" {
+		outf("==> Watching %s/%s for '%s' (every %s)\n", watchGitRemote, watchGitBranch, w.profile.Name, watchInterval)
+	} else {
+		outf("==> Watching %s for '%s' (every %s)\n", strings.Join(w.images, ", "), w.profile.Name, watchInterval)
+	}
+
+	// The first check sets the reference when nothing was deployed by watch yet
+	if deployed.UpdatedAt.IsZero() {
+		current, err := w.poll()
+		if err != nil {
+			return contextErrorf("Unable to read the watched sources: %w", err)
+		}
+		outln("No state saved yet, the current versions are the reference.")
+		if err := saveWatchState(w.profile.Name, current); err != nil {
+			return configErrorf("Unable to save the watch state: %w", err)
+		}
+		if watchOnce {
+			return nil
+		}
+		deployed = current
+	}
+
+	last := deployed
+	var changedAt time.Time
+	// A failed run is retried at the next checks, less and less often
+	failures := 0
+	var retryAt time.Time
+	for {
+		// --once checks right away
+		if !watchOnce {
+			select {
+			case <-appCtx.Done():
+				outln("Watch stopped.")
+				return nil
+			case <-time.After(watchInterval):
+			}
+		}
+		current, err := w.poll()
+		if err != nil {
+			// The registry or the remote may be briefly unreachable: the next check tells
+			outf("Check failed: %v\n", err)
+			if watchOnce {
+				return contextErrorf("Unable to read the watched sources: %w", err)
+			}
+			continue
+		}
+
+		changes := w.changes(last, current)
+		if len(changes) > 0 {
+			changedAt = time.Now()
+			last = current
+			// A new version may fix the failed run: no need to wait
+			failures, retryAt = 0, time.Time{}
+			for _, c := range changes {
+				outf("Change detected: %s\n", c)
+			}
+			emit(Event{Type: EventChangeDetected, Data: map[string]interface{}{"profile": w.profile.Name, "changes": changes}})
+			if !watchOnce && watchDebounce > 0 {
+				outf("Waiting for %s without new change before running...\n", watchDebounce)
+			}
+		}
+		pending := w.changes(deployed, current)
+		switch {
+		case len(pending) == 0:
+			if watchOnce {
+				outln("No change since the last run.")
+				return nil
+			}
+			continue
+		case !watchOnce && time.Since(changedAt) < watchDebounce:
+			continue
+		case time.Now().Before(retryAt):
+			continue
+		}
+
+		if pid := history.Locked(profileLockName(w.profile.Name)); pid != 0 {
+			outf("Profile '%s' is being run by the process %d, retrying at the next check.\n", w.profile.Name, pid)
+			if watchOnce {
+				return lockedErrorf("Profile '%s' is already being run by the process %d.", w.profile.Name, pid)
+			}
+			continue
+		}
+		runErr := w.trigger(pending)
+		if runErr != nil {
+			// The deployed state is unchanged: the run is retried
+			if watchOnce {
+				return runErr
+			}
+			failures++
+			delay := watchRetryDelay(failures)
+			retryAt = time.Now().Add(delay)
+			outf("The run failed (%v), retrying in %s.\n", runErr, delay)
+			continue
+		}
+		failures, retryAt = 0, time.Time{}
+		// The run pushes images too: the reference is read again after it
+		if after, err := w.poll(); err == nil {
+			current = after
+		}
+		deployed, last = current, current
+		if err := saveWatchState(w.profile.Name, current); err != nil {
+			outf("Unable to save the watch state: %v\n", err)
+		}
+		if watchOnce {
+			return nil
+		}
+	}
+}
+
+// watchRetryDelay returns the time before retrying a run that failed failures times in a row:
+// the interval, doubled at every failure up to watchMaxRetryDelay
+func watchRetryDelay(failures int) time.Duration {
+	delay := watchInterval
+	for i := 1; i < failures && delay < watchMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return max(min(delay, watchMaxRetryDelay), watchInterval)
+}
+
+// poll reads the current digests of the images, or the last commit of the branch
+func (w *watcher) poll() (watchState, error) {
+	state := watchState{UpdatedAt: time.Now()}
+	if watchGitBranch != "" {
+		ctx, cancel := probeContext()
+		defer cancel()
+		output, err := commandOutput(ctx, "git", "-C", w.dir, "ls-remote", watchGitRemote, "refs/heads/"+watchGitBranch)
+		if err != nil {
+			return state, fmt.Errorf("git ls-remote %s: %w", watchGitRemote, err)
+		}
+		fields := strings.Fields(output)
+		if len(fields) == 0 {
+			return state, fmt.Errorf("branch '%s' not found on %s", watchGitBranch, watchGitRemote)
+		}
+		state.Commit = fields[0]
+		return state, nil
+	}
+
+	state.Digests = make(map[string]string, len(w.images))
+	for _, image := range w.images {
+		digest, err := registryDigest(image)
+		if err != nil {
+			return state, fmt.Errorf("%s: %w", image, err)
+		}
+		state.Digests[image] = digest
+	}
+	return state, nil
+}
+
+// changes describes the differences between two states
+func (w *watcher) changes(from, to watchState) []string {
+	var changes []string
+	if from.Commit != to.Commit {
+		changes = append(changes, fmt.Sprintf("%s/%s at %s", watchGitRemote, watchGitBranch, shortID(to.Commit)))
+	}
+	for _, image := range sortedKeys(to.Digests) {
+		if from.Digests[image] != to.Digests[image] {
+			changes = append(changes, fmt.Sprintf("%s has a new digest %s", image, shortDigest(to.Digests[image])))
+		}
+	}
+	return changes
+}
+
+// trigger updates the working tree (git mode) and runs the flow of the profile non-interactively
+func (w *watcher) trigger(changes []string) error {
+	if watchGitBranch != "" {
+		outf("==> Updating %s...\n", w.dir)
+		err := runCommandContext(appCtx, "git", "-C", w.dir, "pull", "--ff-only", watchGitRemote, watchGitBranch)
+		if err != nil {
+			return fmt.Errorf("unable to fast-forward %s to %s/%s: %w", w.dir, watchGitRemote, watchGitBranch, err)
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("unable to find the executable: %w", err)
+	}
+
+	trigger := "watch: " + strings.Join(changes, ", ")
+	outf("==> Running the flow of '%s'...\n", w.profile.Name)
+	start := time.Now()
+	// The run is a separate process: its flags and state start from scratch, and its history record is its own
+	runErr := runCommandContext(appCtx, exe, "run-flow", "--profile", w.profile.Name, "--yes", "--plain", "--output", OutputText, "--trigger", trigger)
+
+	data := map[string]interface{}{"profile": w.profile.Name, "changes": changes, "status": history.StatusSuccess}
+	if rec := triggeredRun(w.profile.Name, start); rec != nil {
+		data["run_id"] = rec.ID
+		data["status"] = rec.Status
+		outf("Run %s: %s\n", rec.ID, rec.Status)
+	}
+	if runErr != nil {
+		data["status"] = history.StatusFailed
+		data["error"] = runErr.Error()
+	}
+	emit(Event{Type: EventRunTriggered, Data: data})
+	if runErr != nil {
+		return deployErrorf("The run of '%s' failed: %w", w.profile.Name, runErr)
+	}
+	return nil
+}
+
+// triggeredRun returns the record of the run of the profile started after start
+func triggeredRun(profile string, start time.Time) *history.Record {
+	records, err := history.List()
+	if err != nil {
+		return nil
+	}
+	for i := range records {
+		if records[i].Profile == profile && !records[i].StartedAt.Before(start) {
+			return &records[i]
+		}
+	}
+	return nil
+}
+
+// registryDigest returns the digest of a tag in its registry
+func registryDigest(image string) (string, error) {
+	ctx, cancel := probeContext()
+	defer cancel()
+	output, err := commandOutput(ctx, "docker", "buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", image)
+	if err != nil {
+		return "", err
+	}
+	var manifest struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal([]byte(output), &manifest); err != nil || manifest.Digest == "" {
+		return "", fmt.Errorf("unexpected manifest of %s", image)
+	}
+	return manifest.Digest, nil
+}
+
+// loadWatchState returns the state saved by the last run of the profile
+func loadWatchState(profile string) (watchState, error) {
+	states, err := readWatchStates()
+	if err != nil {
+		return watchState{}, err
+	}
+	return states[profile], nil
+}
+
+// saveWatchState stores the state of a profile
+func saveWatchState(profile string, state watchState) error {
+	states, err := readWatchStates()
+	if err != nil {
+		return err
+	}
+	state.Digests = maps.Clone(state.Digests)
+	states[profile] = state
+	path, err := watchStatePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readWatchStates reads ~/.xpdemon-deploy/watch.json (profile => state)
+func readWatchStates() (map[string]watchState, error) {
+	states := make(map[string]watchState)
+	path, err := watchStatePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return states, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return states, nil
+}
+
+func watchStatePath() (string, error) {
+	dir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "watch.json"), nil
+}
@@ -2,18 +2,261 @@ package compose
 
 import (
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type ComposeFile struct {
-	Services map[string]struct {
-		Image string `yaml:"image"`
-	} `yaml:"services"`
+	Services map[string]ServiceConfig  `yaml:"services"`
+	Secrets  map[string]SecretConfig   `yaml:"secrets"`
+	Networks map[string]ResourceConfig `yaml:"networks"`
+	Volumes  map[string]ResourceConfig `yaml:"volumes"`
+}
+
+// ServiceConfig holds the subset of a compose service definition used by the tool
+type ServiceConfig struct {
+	Image         string          `yaml:"image"`
+	Build         BuildConfig     `yaml:"build"`
+	DependsOn     DependsOn       `yaml:"depends_on"`
+	ContainerName string          `yaml:"container_name"`
+	Ports         []PortConfig    `yaml:"ports"`
+	Volumes       []VolumeConfig  `yaml:"volumes"`
+	EnvFile       EnvFiles        `yaml:"env_file"`
+	Secrets       []ServiceSecret `yaml:"secrets"`
+	Deploy        struct {
+		// Replicas is kept as written: it may be interpolated ("${REPLICAS:-2}")
+		Replicas string `yaml:"replicas"`
+	} `yaml:"deploy"`
+}
+
+// DependsOn is the "depends_on" section of a service.
+// It accepts both the list syntax and the map syntax (service: {condition: ...}).
+type DependsOn []string
+
+// UnmarshalYAML handles the list and map syntaxes of depends_on
+func (d *DependsOn) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		for i := 0; i < len(value.Content); i += 2 {
+			*d = append(*d, value.Content[i].Value)
+		}
+		sort.Strings(*d)
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*d = list
+	return nil
+}
+
+// PortConfig is one entry of the "ports" section of a service.
+// Only the host side is kept: "8080:80" => "8080", "80" => "".
+type PortConfig struct {
+	HostIP    string `yaml:"host_ip"`
+	Published string `yaml:"published"`
+	Protocol  string `yaml:"protocol"`
+}
+
+// UnmarshalYAML handles the short "[ip:]host:container[/proto]" syntax
+func (p *PortConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		spec, proto, _ := strings.Cut(value.Value, "/")
+		parts := strings.Split(spec, ":")
+		if len(parts) >= 2 {
+			p.Published = parts[len(parts)-2]
+			p.HostIP = strings.Join(parts[:len(parts)-2], ":")
+		}
+		p.Protocol = proto
+		return nil
+	}
+	type plain PortConfig
+	return value.Decode((*plain)(p))
+}
+
+// VolumeConfig is one entry of the "volumes" section of a service
+type VolumeConfig struct {
+	Type   string `yaml:"type"`
+	Source string `yaml:"source"`
+	Target string `yaml:"target"`
+}
+
+// UnmarshalYAML handles the short "source:target[:mode]" syntax
+func (v *VolumeConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		source, target, ok := strings.Cut(value.Value, ":")
+		if !ok {
+			// Anonymous volume: only the target
+			v.Type, v.Target = "volume", source
+			return nil
+		}
+		v.Source = source
+		v.Target, _, _ = strings.Cut(target, ":")
+		v.Type = "volume"
+		if isHostPath(source) {
+			v.Type = "bind"
+		}
+		return nil
+	}
+	type plain VolumeConfig
+	return value.Decode((*plain)(v))
+}
+
+// isHostPath reports whether the source of a short volume is a host path (and not a named volume)
+func isHostPath(source string) bool {
+	return strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.HasPrefix(source, "~")
+}
+
+// BuildConfig is the "build" section of a service.
+// It accepts both the short syntax (build: ./dir) and the long syntax (build: {context: ./dir}).
+type BuildConfig struct {
+	Context    string `yaml:"context"`
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+// UnmarshalYAML handles the short "build: ./dir" syntax
+func (b *BuildConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		b.Context = value.Value
+		return nil
+	}
+	type plain BuildConfig
+	return value.Decode((*plain)(b))
+}
+
+// Service describes one service of a compose file
+type Service struct {
+	Name  string
+	Image string
+	// BuildContext is the absolute path of the build context ("" if the service is not built)
+	BuildContext string
+	// Dockerfile is the absolute path of the Dockerfile ("" if the service is not built)
+	Dockerfile string
+	// DependsOn lists the services started before this one
+	DependsOn []string
+	// ContainerName is the fixed name of the container ("" when compose names it)
+	ContainerName string
+	// PublishedPorts are the fixed host ports of the service
+	PublishedPorts []string
+	// Ports are the ports entries with a fixed host port
+	Ports []PortConfig
+	// Replicas is the number of containers of the service (deploy.replicas,
+	// 1 by default or when the value is interpolated)
+	Replicas int
+	// EnvFiles are the env_file entries of the service (absolute paths)
+	EnvFiles []EnvFile
+	// Secrets are the names of the secrets mounted in the containers
+	Secrets []string
+	// BindMounts are the relative host paths mounted in the service (./conf:/etc/app => "./conf"),
+	// as written in the compose file
+	BindMounts []string
+}
+
+// HasBuild reports whether the service is built from sources
+func (s Service) HasBuild() bool {
+	return s.BuildContext != ""
+}
+
+// IsScalable reports whether several containers of the service can run side by side
+// (no fixed container name, no fixed host port)
+func (s Service) IsScalable() bool {
+	if s.ContainerName != "" {
+		return false
+	}
+	for _, p := range s.PublishedPorts {
+		if !strings.Contains(p, "-") { // a range lets every replica get its own port
+			return false
+		}
+	}
+	return true
+}
+
+// IsLocal reports whether the service is built from a local directory
+func (s Service) IsLocal() bool {
+	return s.HasBuild() && !isRemoteContext(s.BuildContext)
 }
 
 // ParseComposeFile reads a docker-compose.yml and returns the list of images
 func ParseComposeFile(path string) ([]string, error) {
+	c, err := readComposeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, svc := range c.Services {
+		if svc.Image != "" {
+			images = append(images, svc.Image)
+		}
+	}
+	return images, nil
+}
+
+// ParseServices reads a docker-compose.yml and returns its services sorted by name.
+// Relative build paths are resolved from the directory of the compose file.
+func ParseServices(path string) ([]Service, error) {
+	c, err := readComposeFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var services []Service
+	for name, svc := range c.Services {
+		s := Service{
+			Name:          name,
+			Image:         svc.Image,
+			DependsOn:     svc.DependsOn,
+			ContainerName: svc.ContainerName,
+			Replicas:      1,
+		}
+		if n, err := strconv.Atoi(svc.Deploy.Replicas); err == nil {
+			s.Replicas = n
+		}
+		for _, p := range svc.Ports {
+			if p.Published != "" {
+				s.PublishedPorts = append(s.PublishedPorts, p.Published)
+				s.Ports = append(s.Ports, p)
+			}
+		}
+		for _, e := range svc.EnvFile {
+			s.EnvFiles = append(s.EnvFiles, EnvFile{Path: resolvePath(baseDir, e.Path), Required: e.Required})
+		}
+		for _, secret := range svc.Secrets {
+			s.Secrets = append(s.Secrets, secret.Source)
+		}
+		for _, v := range svc.Volumes {
+			if v.Type == "bind" && IsRelativePath(v.Source) {
+				s.BindMounts = append(s.BindMounts, v.Source)
+			}
+		}
+		if svc.Build.Context != "" && isRemoteContext(svc.Build.Context) {
+			// Git or URL contexts are kept as-is, there is nothing to resolve locally
+			s.BuildContext = svc.Build.Context
+		} else if svc.Build.Context != "" {
+			s.BuildContext = resolvePath(baseDir, svc.Build.Context)
+			dockerfile := svc.Build.Dockerfile
+			if dockerfile == "" {
+				dockerfile = "Dockerfile"
+			}
+			s.Dockerfile = resolvePath(s.BuildContext, dockerfile)
+		}
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// readComposeFile loads and decodes a compose file
+func readComposeFile(path string) (*ComposeFile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -24,12 +267,23 @@ func ParseComposeFile(path string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return &c, nil
+}
 
-	var images []string
-	for _, svc := range c.Services {
-		if svc.Image != "" {
-			images = append(images, svc.Image)
-		}
+// resolvePath returns p as an absolute path, relative to base if needed
+func resolvePath(base, p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
 	}
-	return images, nil
+	return filepath.Join(base, p)
+}
+
+// IsRelativePath reports whether a host path of the compose file is relative to its directory
+func IsRelativePath(p string) bool {
+	return p == "." || p == ".." || strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../")
+}
+
+// isRemoteContext reports whether a build context is a URL (git repository, tarball...)
+func isRemoteContext(p string) bool {
+	return strings.Contains(p, "://") || strings.HasPrefix(p, "git@")
 }
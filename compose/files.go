@@ -0,0 +1,144 @@
+package compose
+
+import (
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvFile is one entry of the "env_file" section of a service
+type EnvFile struct {
+	Path string `yaml:"path"`
+	// Required is false when the file may be missing (long syntax "required: false")
+	Required bool `yaml:"required"`
+}
+
+// EnvFiles is the "env_file" section of a service.
+// It accepts a single path, a list of paths and the long syntax ({path, required}).
+type EnvFiles []EnvFile
+
+// UnmarshalYAML handles the three syntaxes of env_file
+func (e *EnvFiles) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*e = EnvFiles{{Path: value.Value, Required: true}}
+		return nil
+	}
+	var items []yaml.Node
+	if err := value.Decode(&items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Kind == yaml.ScalarNode {
+			*e = append(*e, EnvFile{Path: item.Value, Required: true})
+			continue
+		}
+		entry := EnvFile{Required: true}
+		type plain EnvFile
+		if err := item.Decode((*plain)(&entry)); err != nil {
+			return err
+		}
+		*e = append(*e, entry)
+	}
+	return nil
+}
+
+// ServiceSecret is one entry of the "secrets" section of a service (short or long syntax)
+type ServiceSecret struct {
+	Source string `yaml:"source"`
+}
+
+// UnmarshalYAML handles the short "secrets: [name]" syntax
+func (s *ServiceSecret) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		s.Source = value.Value
+		return nil
+	}
+	type plain ServiceSecret
+	return value.Decode((*plain)(s))
+}
+
+// SecretConfig is a top-level secret definition
+type SecretConfig struct {
+	File        string `yaml:"file"`
+	Environment string `yaml:"environment"`
+	External    bool   `yaml:"external"`
+}
+
+// ParseSecrets reads the top-level secrets of a compose file.
+// Relative file paths are resolved from the directory of the compose file.
+func ParseSecrets(path string) (map[string]SecretConfig, error) {
+	c, err := readComposeFile(path)
+	if err != nil {
+		return nil, err
+	}
+	baseDir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]SecretConfig, len(c.Secrets))
+	for name, secret := range c.Secrets {
+		if secret.File != "" {
+			secret.File = resolvePath(baseDir, secret.File)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
+
+// ResourceConfig is a top-level network or volume definition
+type ResourceConfig struct {
+	Name     string   `yaml:"name"`
+	External External `yaml:"external"`
+}
+
+// External is the "external" key of a network or volume: a boolean,
+// or the legacy {name: ...} syntax
+type External struct {
+	Enabled bool
+	Name    string
+}
+
+// UnmarshalYAML handles the boolean and the legacy map syntaxes
+func (e *External) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.MappingNode {
+		var legacy struct {
+			Name string `yaml:"name"`
+		}
+		if err := value.Decode(&legacy); err != nil {
+			return err
+		}
+		e.Enabled, e.Name = true, legacy.Name
+		return nil
+	}
+	return value.Decode(&e.Enabled)
+}
+
+// ParseExternals returns the names of the external networks and volumes of a compose file:
+// they are not created by compose and must exist on the Docker host
+func ParseExternals(path string) (networks, volumes []string, err error) {
+	c, err := readComposeFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return externalNames(c.Networks), externalNames(c.Volumes), nil
+}
+
+// externalNames returns the sorted names of the external resources
+func externalNames(resources map[string]ResourceConfig) []string {
+	var names []string
+	for key, r := range resources {
+		if !r.External.Enabled {
+			continue
+		}
+		name := key
+		if r.External.Name != "" {
+			name = r.External.Name
+		} else if r.Name != "" {
+			name = r.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
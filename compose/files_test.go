@@ -0,0 +1,72 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEnvFilesAndSecrets(t *testing.T) {
+	dir := t.TempDir()
+	data := `services:
+  api:
+    env_file: .env
+    secrets: [db_password]
+  worker:
+    env_file:
+      - ./common.env
+      - path: ./local.env
+        required: false
+      - path: /etc/shop/worker.env
+    secrets:
+      - source: api_key
+        target: /run/secrets/key
+secrets:
+  db_password:
+    file: ./secrets/db.txt
+  api_key:
+    environment: API_KEY
+  tls:
+    external: true
+`
+	path := filepath.Join(dir, "docker-compose.yml")
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := ParseServices(path)
+	if err != nil {
+		t.Fatalf("ParseServices: %v", err)
+	}
+	wantEnv := map[string][]EnvFile{
+		"api": {{Path: filepath.Join(dir, ".env"), Required: true}},
+		"worker": {
+			{Path: filepath.Join(dir, "common.env"), Required: true},
+			{Path: filepath.Join(dir, "local.env"), Required: false},
+			{Path: "/etc/shop/worker.env", Required: true},
+		},
+	}
+	wantSecrets := map[string][]string{"api": {"db_password"}, "worker": {"api_key"}}
+	for _, svc := range services {
+		if !reflect.DeepEqual(svc.EnvFiles, wantEnv[svc.Name]) {
+			t.Errorf("%s: EnvFiles = %+v, want %+v", svc.Name, svc.EnvFiles, wantEnv[svc.Name])
+		}
+		if !reflect.DeepEqual(svc.Secrets, wantSecrets[svc.Name]) {
+			t.Errorf("%s: Secrets = %v, want %v", svc.Name, svc.Secrets, wantSecrets[svc.Name])
+		}
+	}
+
+	secrets, err := ParseSecrets(path)
+	if err != nil {
+		t.Fatalf("ParseSecrets: %v", err)
+	}
+	want := map[string]SecretConfig{
+		"db_password": {File: filepath.Join(dir, "secrets", "db.txt")},
+		"api_key":     {Environment: "API_KEY"},
+		"tls":         {External: true},
+	}
+	if !reflect.DeepEqual(secrets, want) {
+		t.Errorf("ParseSecrets = %+v, want %+v", secrets, want)
+	}
+}
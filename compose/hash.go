@@ -0,0 +1,201 @@
+package compose
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// HashBuildContext computes a content hash of the build context of a service.
+// Files excluded by the .dockerignore of the context are not taken into account,
+// the Dockerfile is always included (even when it lives outside of the context).
+func HashBuildContext(svc Service) (string, error) {
+	if !svc.IsLocal() {
+		return "", fmt.Errorf("service '%s' has no local build context", svc.Name)
+	}
+
+	ignore, err := loadDockerignore(svc)
+	if err != nil {
+		return "", err
+	}
+
+	// 1) Collect the files to hash (relative paths, sorted for a stable result)
+	var files []string
+	err = filepath.WalkDir(svc.BuildContext, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(svc.BuildContext, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if ignore.excluded(rel) {
+			// Directories can't be skipped entirely when exceptions (!pattern) exist
+			if d.IsDir() && !ignore.hasExceptions {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() || d.Type()&fs.ModeSymlink != 0 {
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(files)
+
+	// 2) Hash the path, mode and content of each file, then the Dockerfile
+	h := sha256.New()
+	for _, rel := range files {
+		if err := hashFile(h, rel, filepath.Join(svc.BuildContext, filepath.FromSlash(rel))); err != nil {
+			return "", err
+		}
+	}
+	if err := hashFile(h, "Dockerfile", svc.Dockerfile); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashFile writes the name, mode and content of a file into h
+func hashFile(h io.Writer, name, path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "%s\x00%o\x00", name, info.Mode().Perm())
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "link:%s\x00", target)
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	_, err = h.Write([]byte{0})
+	return err
+}
+
+// dockerignore holds the compiled patterns of a .dockerignore file
+type dockerignore struct {
+	patterns      []ignorePattern
+	hasExceptions bool
+}
+
+type ignorePattern struct {
+	re        *regexp.Regexp
+	exception bool
+}
+
+// loadDockerignore reads <Dockerfile>.dockerignore or <context>/.dockerignore (same precedence as BuildKit)
+func loadDockerignore(svc Service) (*dockerignore, error) {
+	candidates := []string{
+		svc.Dockerfile + ".dockerignore",
+		filepath.Join(svc.BuildContext, ".dockerignore"),
+	}
+	for _, path := range candidates {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseDockerignore(f)
+	}
+	return &dockerignore{}, nil
+}
+
+// parseDockerignore compiles the patterns of a .dockerignore file
+func parseDockerignore(r io.Reader) (*dockerignore, error) {
+	ignore := &dockerignore{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exception := strings.HasPrefix(line, "!")
+		if exception {
+			line = strings.TrimSpace(line[1:])
+			ignore.hasExceptions = true
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		re, err := compileIgnorePattern(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern '%s': %w", line, err)
+		}
+		ignore.patterns = append(ignore.patterns, ignorePattern{re: re, exception: exception})
+	}
+	return ignore, scanner.Err()
+}
+
+// compileIgnorePattern converts a .dockerignore pattern into a regexp.
+// A pattern also matches everything below a matching directory.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			// "**/" matches zero or more directories, "**" anything
+			i++
+			if i+1 < len(pattern) && pattern[i+1] == '/' {
+				i++
+				sb.WriteString("(.*/)?")
+			} else {
+				sb.WriteString(".*")
+			}
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("(/.*)?$")
+	return regexp.Compile(sb.String())
+}
+
+// excluded reports whether a path (relative to the context, slash separated) is ignored.
+// The last matching pattern wins, as in docker.
+func (d *dockerignore) excluded(rel string) bool {
+	excluded := false
+	for _, p := range d.patterns {
+		if p.re.MatchString(rel) {
+			excluded = !p.exception
+		}
+	}
+	return excluded
+}
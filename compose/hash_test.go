@@ -0,0 +1,187 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerignore(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		excluded []string
+		included []string
+	}{
+		{
+			name:     "plain names",
+			patterns: "node_modules\n.git\n# comment\n\n",
+			excluded: []string{"node_modules", "node_modules/a/b.js", ".git/HEAD"},
+			included: []string{"src/node_modules", "main.go", ".gitignore"},
+		},
+		{
+			name:     "star and question mark",
+			patterns: "*.log\ntmp?\n",
+			excluded: []string{"app.log", "tmp1", "tmp1/x"},
+			included: []string{"logs/app.log", "tmp", "tmp12", "app.logs"},
+		},
+		{
+			name:     "double star",
+			patterns: "**/*.pyc\ndocs/**\n",
+			excluded: []string{"a.pyc", "pkg/sub/a.pyc", "docs/index.md", "docs/a/b"},
+			included: []string{"a.py", "pkg/docs/index.md"},
+		},
+		{
+			name:     "exceptions, the last match wins",
+			patterns: "*.md\n!README.md\nREADME.md\n!docs/KEEP.md\n",
+			excluded: []string{"CHANGELOG.md", "README.md"},
+			included: []string{"main.go", "docs/KEEP.md"},
+		},
+		{
+			name:     "leading slash and cleaned paths",
+			patterns: "/build/\n./dist\n",
+			excluded: []string{"build/out", "dist"},
+			included: []string{"src/build"},
+		},
+		{
+			name:     "escaped characters",
+			patterns: `file\*name` + "\n",
+			excluded: []string{"file*name"},
+			included: []string{"file-name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore, err := parseDockerignore(strings.NewReader(tt.patterns))
+			if err != nil {
+				t.Fatalf("parseDockerignore: %v", err)
+			}
+			for _, rel := range tt.excluded {
+				if !ignore.excluded(rel) {
+					t.Errorf("%s is not excluded", rel)
+				}
+			}
+			for _, rel := range tt.included {
+				if ignore.excluded(rel) {
+					t.Errorf("%s is excluded", rel)
+				}
+			}
+		})
+	}
+}
+
+// writeTree creates the files (relative path => content) under dir
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHashBuildContext(t *testing.T) {
+	base := map[string]string{
+		"Dockerfile":     "FROM scratch\n",
+		"main.go":        "package main\n",
+		"pkg/lib.go":     "package pkg\n",
+		".dockerignore":  "*.log\nnode_modules\n!node_modules/keep.js\n",
+		"debug.log":      "old\n",
+		"node_modules/x": "x\n",
+	}
+	tests := []struct {
+		name    string
+		change  func(t *testing.T, dir string)
+		changed bool
+	}{
+		{"nothing", func(t *testing.T, dir string) {}, false},
+		{"ignored file edited", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{"debug.log": "new\n", "node_modules/y": "y\n"})
+		}, false},
+		{"source edited", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{"main.go": "package main // v2\n"})
+		}, true},
+		{"file added", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{"pkg/new.go": "package pkg\n"})
+		}, true},
+		{"file removed", func(t *testing.T, dir string) {
+			os.Remove(filepath.Join(dir, "pkg/lib.go"))
+		}, true},
+		{"file renamed", func(t *testing.T, dir string) {
+			os.Rename(filepath.Join(dir, "pkg/lib.go"), filepath.Join(dir, "pkg/lib2.go"))
+		}, true},
+		{"exception under an ignored directory", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{"node_modules/keep.js": "kept\n"})
+		}, true},
+		{"mode changed", func(t *testing.T, dir string) {
+			os.Chmod(filepath.Join(dir, "main.go"), 0755)
+		}, true},
+		{"Dockerfile edited", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{"Dockerfile": "FROM alpine\n"})
+		}, true},
+		{".dockerignore edited", func(t *testing.T, dir string) {
+			writeTree(t, dir, map[string]string{".dockerignore": "*.log\n"})
+		}, true},
+	}
+
+	hash := func(t *testing.T, dir string) string {
+		t.Helper()
+		svc := Service{Name: "api", BuildContext: dir, Dockerfile: filepath.Join(dir, "Dockerfile")}
+		h, err := HashBuildContext(svc)
+		if err != nil {
+			t.Fatalf("HashBuildContext: %v", err)
+		}
+		return h
+	}
+	reference := ""
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTree(t, dir, base)
+			before := hash(t, dir)
+			if reference == "" {
+				reference = before
+			} else if before != reference {
+				// Same content in another directory: same hash
+				t.Errorf("hash depends on the location of the context")
+			}
+			tt.change(t, dir)
+			if after := hash(t, dir); (after != before) != tt.changed {
+				t.Errorf("hash changed = %v, want %v", after != before, tt.changed)
+			}
+		})
+	}
+}
+
+func TestHashBuildContextDockerfileOutside(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"docker/api.Dockerfile":              "FROM scratch\n",
+		"docker/api.Dockerfile.dockerignore": "secret.txt\n",
+		"app/main.go":                        "package main\n",
+		"app/secret.txt":                     "v1\n",
+	})
+	svc := Service{Name: "api", BuildContext: filepath.Join(dir, "app"), Dockerfile: filepath.Join(dir, "docker/api.Dockerfile")}
+	before, err := HashBuildContext(svc)
+	if err != nil {
+		t.Fatalf("HashBuildContext: %v", err)
+	}
+	// The .dockerignore next to the Dockerfile takes precedence
+	writeTree(t, dir, map[string]string{"app/secret.txt": "v2\n"})
+	if after, _ := HashBuildContext(svc); after != before {
+		t.Errorf("a file ignored by <Dockerfile>.dockerignore changed the hash")
+	}
+	writeTree(t, dir, map[string]string{"docker/api.Dockerfile": "FROM alpine\n"})
+	if after, _ := HashBuildContext(svc); after == before {
+		t.Errorf("the Dockerfile outside of the context is not hashed")
+	}
+
+	if _, err := HashBuildContext(Service{Name: "db", Image: "postgres"}); err == nil {
+		t.Errorf("HashBuildContext of a service without build succeeded")
+	}
+}
@@ -0,0 +1,64 @@
+package compose
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeployOrder returns the names of the selected services sorted so that every service
+// comes after the services it depends on (depends_on, followed transitively through
+// the services that are not selected). Services without dependencies keep the name order.
+func DeployOrder(services []Service, names []string) ([]string, error) {
+	byName := make(map[string]Service, len(services))
+	for _, svc := range services {
+		byName[svc.Name] = svc
+	}
+	selected := make(map[string]bool, len(names))
+	for _, n := range names {
+		selected[n] = true
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var order []string
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("circular depends_on: %s -> %s", strings.Join(path, " -> "), name)
+		}
+		svc, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("service '%s' (from depends_on) does not exist", name)
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dep := range svc.DependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		if selected[name] {
+			order = append(order, name)
+		}
+		return nil
+	}
+
+	// services is sorted by name, so the order is stable
+	for _, svc := range services {
+		if selected[svc.Name] {
+			if err := visit(svc.Name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return order, nil
+}
@@ -0,0 +1,93 @@
+package compose
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeployOrder(t *testing.T) {
+	services := []Service{
+		{Name: "api", DependsOn: []string{"cache", "db"}},
+		{Name: "cache"},
+		{Name: "db"},
+		{Name: "proxy", DependsOn: []string{"web"}},
+		{Name: "web", DependsOn: []string{"api"}},
+	}
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"every service", []string{"web", "proxy", "db", "cache", "api"}, []string{"cache", "db", "api", "web", "proxy"}},
+		{"through the services not selected", []string{"proxy", "db"}, []string{"db", "proxy"}},
+		{"without dependencies", []string{"db", "cache"}, []string{"cache", "db"}},
+	}
+	for _, tt := range tests {
+		got, err := DeployOrder(services, tt.names)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: DeployOrder = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+
+	cycle := []Service{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}
+	if _, err := DeployOrder(cycle, []string{"a"}); err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("DeployOrder with a cycle = %v", err)
+	}
+	missing := []Service{{Name: "a", DependsOn: []string{"ghost"}}}
+	if _, err := DeployOrder(missing, []string{"a"}); err == nil || !strings.Contains(err.Error(), "'ghost'") {
+		t.Errorf("DeployOrder with a missing dependency = %v", err)
+	}
+}
+
+func TestParseServicesReplicas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "docker-compose.yml")
+	data := `services:
+  api:
+    deploy:
+      replicas: 3
+  worker:
+    deploy:
+      replicas: ${WORKERS:-2}
+  web:
+    container_name: shop-web
+  proxy:
+    ports: ["80:80"]
+  range:
+    ports: ["8000-8010:80"]
+  db:
+    depends_on:
+      cache:
+        condition: service_healthy
+  cache: {}
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	services, err := ParseServices(path)
+	if err != nil {
+		t.Fatalf("ParseServices: %v", err)
+	}
+	byName := make(map[string]Service)
+	for _, s := range services {
+		byName[s.Name] = s
+	}
+
+	replicas := map[string]int{"api": 3, "worker": 1, "web": 1}
+	for name, want := range replicas {
+		if got := byName[name].Replicas; got != want {
+			t.Errorf("%s: Replicas = %d, want %d", name, got, want)
+		}
+	}
+	scalable := map[string]bool{"api": true, "web": false, "proxy": false, "range": true}
+	for name, want := range scalable {
+		if got := byName[name].IsScalable(); got != want {
+			t.Errorf("%s: IsScalable = %v, want %v", name, got, want)
+		}
+	}
+	if got := byName["db"].DependsOn; !slices.Equal(got, []string{"cache"}) {
+		t.Errorf("db: DependsOn = %v, want [cache]", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -11,20 +12,185 @@ import (
 type AppConfig struct {
 	DockerContexts   []DockerContext `json:"docker_contexts"`
 	DockerRegistries []string        `json:"docker_registries"`
+	Profiles         []Profile       `json:"profiles,omitempty"`
+	// Retry sets the default retries of the network operations
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Webhooks receive the notifications of every run-flow
+	Webhooks []Webhook `json:"webhooks,omitempty"`
 }
 
 type DockerContext struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Host        string `json:"host"`
+	// ProjectsDir is the directory of the deploy host where the files of the projects are shipped
+	// (<projects_dir>/<project>), used when a profile ships files without a project directory
+	ProjectsDir string `json:"projects_dir,omitempty"`
 	// ...
 }
 
+// Profile stores the answers of run-flow so that a deployment can be replayed.
+// Empty fields are asked interactively.
+type Profile struct {
+	Name          string            `json:"name"`
+	BuildContext  string            `json:"build_context,omitempty"`
+	DeployContext string            `json:"deploy_context,omitempty"`
+	Registry      string            `json:"registry,omitempty"`
+	ComposeFile   string            `json:"compose_file,omitempty"`
+	Tag           string            `json:"tag,omitempty"`
+	Prefix        string            `json:"prefix,omitempty"`
+	Services      []string          `json:"services,omitempty"`
+	Buildx        *BuildxConfig     `json:"buildx,omitempty"`
+	BuildCache    *BuildCacheConfig `json:"build_cache,omitempty"`
+	// Project is the compose project name on the deploy context (default: the directory of the compose file)
+	Project string `json:"project,omitempty"`
+	// ProjectDirectory is the directory of the deploy host where the relative paths of the compose
+	// file (bind mounts) are resolved. Empty: they are resolved on the local machine.
+	ProjectDirectory string `json:"project_directory,omitempty"`
+	// Ship sends the relative directories of the compose file to the project directory before
+	// the deployment: "none" (default), "tar" (tar archive over SSH) or "sync" (changed
+	// files of the bind mounts, whose paths are rewritten in the generated compose)
+	Ship string `json:"ship,omitempty"`
+	// SyncDelete deletes the files of the synchronized bind mounts that are missing locally
+	// (off by default: the containers may write to the bind mounts)
+	SyncDelete bool `json:"sync_delete,omitempty"`
+	// BuildArgs are "KEY=VALUE" or "service:KEY=VALUE"
+	BuildArgs []string `json:"build_args,omitempty"`
+	// BuildArgFiles are .env files whose variables become build arguments of every service
+	BuildArgFiles []string `json:"build_arg_files,omitempty"`
+	// BuildSecrets are "id=NAME,src=PATH" or "id=NAME,env=VAR", optionally prefixed with "service:"
+	BuildSecrets []string `json:"build_secrets,omitempty"`
+	// SecretEnvFiles are .env files loaded in the environment to feed the env= secrets
+	SecretEnvFiles []string `json:"secret_env_files,omitempty"`
+	// Timeouts bounds the duration of the run-flow steps (step name => duration, e.g., "push": "10m")
+	Timeouts map[string]string `json:"timeouts,omitempty"`
+	// Retry overrides the global retry settings for this profile
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Hooks are run at the stages of run-flow (stage name => hooks, e.g., "pre-deploy")
+	Hooks map[string][]Hook `json:"hooks,omitempty"`
+	// Webhooks receive the notifications of the runs of this profile (in addition to the global ones)
+	Webhooks []Webhook `json:"webhooks,omitempty"`
+	// Deploy sets how the services are updated on the deploy context
+	Deploy *DeployConfig `json:"deploy,omitempty"`
+	// Lint tunes the checks of the compose file before the build
+	Lint *LintConfig `json:"lint,omitempty"`
+}
+
+// LintConfig tunes the lint rules of a profile
+type LintConfig struct {
+	// Production turns the latest tags into errors
+	Production bool `json:"production,omitempty"`
+	// Rules overrides the severity of rules (rule => error, warning, info or off)
+	Rules map[string]string `json:"rules,omitempty"`
+}
+
+// DeployConfig sets the deploy strategy
+type DeployConfig struct {
+	// Strategy is "recreate" (default: docker compose up), "rolling" (new containers first,
+	// old ones removed once healthy) or "blue-green" (a second project, then a traffic switch)
+	Strategy string `json:"strategy,omitempty"`
+	// HealthTimeout bounds the wait for the new containers to be healthy (e.g., "2m")
+	HealthTimeout string `json:"health_timeout,omitempty"`
+	// BlueGreen configures the "blue-green" strategy
+	BlueGreen *BlueGreenConfig `json:"blue_green,omitempty"`
+	// Preflight configures the checks run on the deploy context before deploying
+	Preflight *PreflightConfig `json:"preflight,omitempty"`
+}
+
+// PreflightConfig configures the preflight checks
+type PreflightConfig struct {
+	// Skip lists the checks not run: versions, disk, ports, resources, registry (or "all")
+	Skip []string `json:"skip,omitempty"`
+}
+
+// BlueGreenConfig deploys the new version as a second compose project, then switches the traffic
+type BlueGreenConfig struct {
+	// Project is the base project name: the colors run as <project>-blue and <project>-green
+	// (default: the directory of the compose file)
+	Project string `json:"project,omitempty"`
+	// Switch hooks point the reverse proxy to the new color (XPD_COLOR, XPD_PROJECT...)
+	Switch []Hook `json:"switch,omitempty"`
+	// Previous is what happens to the previous color after the switch:
+	// "stop" (default), "keep" (running, for an instant switch-back) or "down"
+	Previous string `json:"previous,omitempty"`
+}
+
+// Webhook posts the run-flow notifications (start, success, failure) to an HTTP endpoint
+type Webhook struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// Format of the payload: generic (default), slack or discord
+	Format string `json:"format,omitempty"`
+	// Events filters the notifications: start, success, failure (default: all)
+	Events []string `json:"events,omitempty"`
+	// Headers are added to the request (e.g., Authorization)
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a Go template of the JSON body, used instead of the format
+	Template string `json:"template,omitempty"`
+}
+
+// Hook is a shell command run locally, or a one-off compose service run on a Docker context
+type Hook struct {
+	// Command is a shell command (sh -c)
+	Command string `json:"command,omitempty"`
+	// Service is a service of the compose file run with "docker compose run --rm"
+	Service string `json:"service,omitempty"`
+	// Args replace the command of the service
+	Args []string `json:"args,omitempty"`
+	// Context is the Docker context of the service (default: the deploy context)
+	Context string `json:"context,omitempty"`
+	// ContinueOnError keeps the flow going when the hook fails
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+}
+
+// BuildxConfig enables multi-platform builds with docker buildx
+type BuildxConfig struct {
+	// Platforms to build (e.g., linux/amd64, linux/arm64).
+	// Empty => the platform of the deploy context.
+	Platforms []string `json:"platforms,omitempty"`
+	// Nodes are additional Docker contexts appended to the builder,
+	// optionally pinned to a platform: "rpi=linux/arm64"
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// BuildCacheConfig describes how the build cache is managed
+type BuildCacheConfig struct {
+	// CacheFrom / CacheTo are injected in the build section of every service,
+	// {service} is replaced by the service name (e.g., type=registry,ref=my-registry.com/cache:{service})
+	CacheFrom []string `json:"cache_from,omitempty"`
+	CacheTo   []string `json:"cache_to,omitempty"`
+	// PruneUntil / PruneKeepStorage prune the builder cache before the build without asking
+	// (e.g., "24h" and "10GB")
+	PruneUntil       string `json:"prune_until,omitempty"`
+	PruneKeepStorage string `json:"prune_keep_storage,omitempty"`
+	// Report prints the size of the build cache before and after the build
+	Report bool `json:"report,omitempty"`
+}
+
+// RetryConfig sets how the network operations (push, pull, login, context probes)
+// are retried after a transient failure. Empty fields keep the defaults.
+type RetryConfig struct {
+	// Attempts is the total number of attempts (1 = no retry)
+	Attempts int `json:"attempts,omitempty"`
+	// Delay is the wait before the first retry (e.g., "2s"), doubled at every retry up to MaxDelay
+	Delay    string `json:"delay,omitempty"`
+	MaxDelay string `json:"max_delay,omitempty"`
+}
+
+// IsZero reports whether no cache strategy is configured
+func (c BuildCacheConfig) IsZero() bool {
+	return len(c.CacheFrom) == 0 && len(c.CacheTo) == 0 &&
+		c.PruneUntil == "" && c.PruneKeepStorage == "" && !c.Report
+}
+
 // In-memory configuration instance
 var Cfg AppConfig
 
-// getConfigPath returns the path ~/.xpdemon-deploy/config.json
-func getConfigPath() (string, error) {
+// Output receives the messages of this package (stderr when the CLI writes JSON on stdout)
+var Output io.Writer = os.Stdout
+
+// ConfigDir returns the directory ~/.xpdemon-deploy, creating it if needed
+func ConfigDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -34,6 +200,15 @@ func getConfigPath() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return cfgDir, nil
+}
+
+// getConfigPath returns the path ~/.xpdemon-deploy/config.json
+func getConfigPath() (string, error) {
+	cfgDir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
 	return filepath.Join(cfgDir, "config.json"), nil
 }
 
@@ -63,6 +238,37 @@ func LoadConfig() error {
 	return nil
 }
 
+// FindContext returns the registered Docker context with the given name
+func FindContext(name string) (DockerContext, bool) {
+	for _, c := range Cfg.DockerContexts {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return DockerContext{}, false
+}
+
+// FindProfile returns the profile with the given name
+func FindProfile(name string) (*Profile, bool) {
+	for i := range Cfg.Profiles {
+		if Cfg.Profiles[i].Name == name {
+			return &Cfg.Profiles[i], true
+		}
+	}
+	return nil, false
+}
+
+// SetProfile adds the profile or replaces the existing one with the same name
+func SetProfile(p Profile) {
+	for i := range Cfg.Profiles {
+		if Cfg.Profiles[i].Name == p.Name {
+			Cfg.Profiles[i] = p
+			return
+		}
+	}
+	Cfg.Profiles = append(Cfg.Profiles, p)
+}
+
 // SaveConfig saves the configuration to the file ~/.xpdemon-deploy/config.json
 func SaveConfig() error {
 	path, err := getConfigPath()
@@ -78,6 +284,6 @@ func SaveConfig() error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("Configuration saved to", path)
+	fmt.Fprintln(Output, "Configuration saved to", path)
 	return nil
 }
@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestProfiles(t *testing.T) {
+	saved := Cfg
+	defer func() { Cfg = saved }()
+	Cfg = AppConfig{DockerContexts: []DockerContext{{Name: "builder"}, {Name: "prod", Host: "ssh://deploy@prod"}}}
+
+	if _, ok := FindProfile("staging"); ok {
+		t.Fatalf("FindProfile found a missing profile")
+	}
+	SetProfile(Profile{Name: "staging", Registry: "reg.io/a"})
+	SetProfile(Profile{Name: "prod", Registry: "reg.io/b"})
+	// Same name: replaced in place
+	SetProfile(Profile{Name: "staging", Registry: "reg.io/c"})
+	if len(Cfg.Profiles) != 2 || Cfg.Profiles[0].Name != "staging" {
+		t.Fatalf("profiles = %+v, want staging then prod", Cfg.Profiles)
+	}
+	p, ok := FindProfile("staging")
+	if !ok || p.Registry != "reg.io/c" {
+		t.Errorf("FindProfile = %+v, %v, want the replaced profile", p, ok)
+	}
+
+	if c, ok := FindContext("prod"); !ok || c.Host != "ssh://deploy@prod" {
+		t.Errorf("FindContext = %+v, %v", c, ok)
+	}
+	if _, ok := FindContext("staging"); ok {
+		t.Errorf("FindContext found a missing context")
+	}
+}
@@ -0,0 +1,220 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Run statuses
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+)
+
+// Output receives the warnings of this package (stderr when the CLI writes JSON on stdout)
+var Output io.Writer = os.Stdout
+
+// skipped holds the records already reported as unreadable, so that each one is reported once
+var skipped sync.Map
+
+// Record describes one execution of run-flow
+type Record struct {
+	ID            string    `json:"id"`
+	StartedAt     time.Time `json:"started_at"`
+	FinishedAt    time.Time `json:"finished_at"`
+	Status        string    `json:"status"`
+	Profile       string    `json:"profile,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	ComposeFile   string    `json:"compose_file"`
+	BuildContext  string    `json:"build_context"`
+	DeployContext string    `json:"deploy_context"`
+	Registry      string    `json:"registry"`
+	Services      []string  `json:"services"`
+	// Pushed / Deployed tell which steps of the flow were executed
+	Pushed   bool `json:"pushed"`
+	Deployed bool `json:"deployed"`
+	// Hashes holds the build context hash of each built service
+	Hashes map[string]string `json:"hashes,omitempty"`
+	// Images holds the image reference of each service in the generated compose
+	Images map[string]string `json:"images,omitempty"`
+	// Project is the compose project the services were deployed to (set when it is explicit)
+	Project string `json:"project,omitempty"`
+	// SecretKeys are the names of the variables injected from the secrets store (never their values)
+	SecretKeys []string `json:"secret_keys,omitempty"`
+	// LogFile is the full output of the commands of the run
+	LogFile string `json:"log_file,omitempty"`
+	// Trigger tells what started the run when it was not started by hand (watch)
+	Trigger string `json:"trigger,omitempty"`
+	// ResumedFrom is the ID of the failed run this run resumed
+	ResumedFrom string `json:"resumed_from,omitempty"`
+	// Checkpoint holds the state needed to resume the run (saved after every step)
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// Checkpoint is the state of a run after its last completed step
+type Checkpoint struct {
+	// Completed lists the steps that finished (successfully or skipped), in order
+	Completed []string `json:"completed"`
+	// Inputs holds the resolved answers of the run (contexts, compose file, build inputs...)
+	Inputs config.Profile `json:"inputs"`
+	Tag    string         `json:"tag,omitempty"`
+	// GeneratedCompose is the path of the -tagged compose file used by the build, push and deploy steps
+	GeneratedCompose string   `json:"generated_compose,omitempty"`
+	TargetServices   []string `json:"target_services,omitempty"`
+	// BuildServices is nil when every target service is built
+	BuildServices []string          `json:"build_services"`
+	SkipBuild     bool              `json:"skip_build,omitempty"`
+	ReusedImages  map[string]string `json:"reused_images,omitempty"`
+	// ChangedOnly is set when the services are narrowed to the changed ones (--changed)
+	ChangedOnly bool `json:"changed_only,omitempty"`
+}
+
+// IsCompleted reports whether the step was completed
+func (c *Checkpoint) IsCompleted(step string) bool {
+	for _, s := range c.Completed {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// NewID returns a new run identifier based on the current time
+func NewID() string {
+	return time.Now().Format("20060102-150405.000")
+}
+
+// getHistoryDir returns the directory ~/.xpdemon-deploy/history
+func getHistoryDir() (string, error) {
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	// The records describe the infrastructure (hosts, registries, paths): user only,
+	// including the directories created by the previous versions
+	dir := filepath.Join(cfgDir, "history")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Save writes the record to ~/.xpdemon-deploy/history/<id>.json
+func Save(rec *Record) error {
+	if rec.ID == "" {
+		return errors.New("the run record has no ID")
+	}
+	dir, err := getHistoryDir()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, rec.ID+".json")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, 0600)
+}
+
+// Load reads the run record with the given ID
+func Load(id string) (*Record, error) {
+	dir, err := getHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(id)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("run '%s' not found in the history", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("invalid run record %s: %w", id, err)
+	}
+	return &rec, nil
+}
+
+// List returns all the run records, newest first.
+// The records that can't be read (truncated by a crash, edited by hand) are skipped with a warning.
+func List() ([]Record, error) {
+	dir, err := getHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		var rec Record
+		if err == nil {
+			err = json.Unmarshal(data, &rec)
+		}
+		if err != nil {
+			if _, reported := skipped.LoadOrStore(path, true); !reported {
+				fmt.Fprintf(Output, "Warning: run record %s skipped: %v\n", path, err)
+			}
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].StartedAt.After(records[j].StartedAt) })
+	return records, nil
+}
+
+// LastSuccessful returns the most recent successful run for a compose file
+// matching the filter (nil = any), or nil if there is none.
+func LastSuccessful(composeFile string, filter func(*Record) bool) (*Record, error) {
+	records, err := List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range records {
+		rec := &records[i]
+		if rec.Status != StatusSuccess || rec.ComposeFile != composeFile {
+			continue
+		}
+		if filter == nil || filter(rec) {
+			return rec, nil
+		}
+	}
+	return nil, nil
+}
+
+// Deployed is a LastSuccessful filter keeping the runs that reached the deploy step
+func Deployed(rec *Record) bool {
+	return rec.Deployed
+}
+
+// Pushed is a LastSuccessful filter keeping the runs that pushed their images
+func Pushed(rec *Record) bool {
+	return rec.Pushed
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListSkipsMalformedRecords(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var warnings bytes.Buffer
+	saved := Output
+	Output = &warnings
+	defer func() { Output = saved }()
+
+	now := time.Now()
+	for i, id := range []string{"20261019-100000.000", "20261019-110000.000"} {
+		rec := &Record{ID: id, StartedAt: now.Add(time.Duration(i) * time.Hour), Status: StatusSuccess}
+		if err := Save(rec); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+	}
+	dir, err := getHistoryDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "20261019-120000.000.json"), []byte(`{"id": "20261019-12`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		records, err := List()
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(records) != 2 || records[0].ID != "20261019-110000.000" || records[1].ID != "20261019-100000.000" {
+			t.Errorf("List = %+v, want the 2 valid records, newest first", records)
+		}
+	}
+	if n := strings.Count(warnings.String(), "20261019-120000.000.json skipped"); n != 1 {
+		t.Errorf("warnings = %q, want one warning for the malformed record", warnings.String())
+	}
+}
+
+func TestSaveIsPrivate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	// A directory created by a previous version is restricted too
+	dir := filepath.Join(os.Getenv("HOME"), ".xpdemon-deploy", "history")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(dir, "20261019-100000.000.json")
+	if err := os.WriteFile(old, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range []string{"20261019-100000.000", "20261019-110000.000"} {
+		if err := Save(&Record{ID: id, Status: StatusSuccess}); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(dir, id+".json"))
+		if err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("mode of %s = %v, %v, want 0600", id, info.Mode().Perm(), err)
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("mode of the history directory = %v, %v, want 0700", info.Mode().Perm(), err)
+	}
+}
@@ -0,0 +1,97 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// LockedError is returned by Lock when a running process holds the lock
+type LockedError struct {
+	Name string
+	PID  int
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by the process %d", e.Name, e.PID)
+}
+
+// getLocksDir returns the directory ~/.xpdemon-deploy/locks
+func getLocksDir() (string, error) {
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cfgDir, "locks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Lock takes the lock ~/.xpdemon-deploy/locks/<name>.lock and returns the function releasing it.
+// The lock is held on the file as long as it is open (flock on Unix, LockFileEx on Windows):
+// the system releases it when its owner exits, so the lock of a killed process is free
+// without any takeover.
+// The file holds the PID of the owner, for the messages only.
+func Lock(name string) (func(), error) {
+	dir, err := getLocksDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(name)+".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := lockFile(f, true)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !locked {
+		pid := lockOwner(f)
+		f.Close()
+		return nil, &LockedError{Name: name, PID: pid}
+	}
+	// The file is kept: removing it would let another process lock a new file while
+	// a third one still holds the old one
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return func() {
+		f.Truncate(0)
+		f.Close()
+	}, nil
+}
+
+// Locked returns the PID of the process holding the lock, 0 when it is free
+func Locked(name string) int {
+	dir, err := getLocksDir()
+	if err != nil {
+		return 0
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.Base(name)+".lock"))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if locked, err := lockFile(f, false); err == nil && locked {
+		unlockFile(f)
+		return 0
+	}
+	return lockOwner(f)
+}
+
+// lockOwner reads the PID written in a lock file (0 when unknown)
+func lockOwner(f *os.File) int {
+	data := make([]byte, 32)
+	n, _ := f.ReadAt(data, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,45 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestLock(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if pid := Locked("profile-prod"); pid != 0 {
+		t.Fatalf("Locked before Lock = %d, want 0", pid)
+	}
+	unlock, err := Lock("profile-prod")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+	if pid := Locked("profile-prod"); pid != os.Getpid() {
+		t.Errorf("Locked = %d, want %d", pid, os.Getpid())
+	}
+
+	// Another open file description doesn't get the lock, even in the same process
+	_, err = Lock("profile-prod")
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.PID != os.Getpid() {
+		t.Fatalf("second Lock = %v, want a LockedError with the PID", err)
+	}
+	// Other names are independent
+	unlockOther, err := Lock("watch-prod")
+	if err != nil {
+		t.Fatalf("Lock of another name: %v", err)
+	}
+	unlockOther()
+
+	unlock()
+	if pid := Locked("profile-prod"); pid != 0 {
+		t.Errorf("Locked after the release = %d, want 0", pid)
+	}
+	unlock, err = Lock("profile-prod")
+	if err != nil {
+		t.Fatalf("Lock after the release: %v", err)
+	}
+	unlock()
+}
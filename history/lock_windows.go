@@ -0,0 +1,53 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorLockViolation syscall.Errno = 33
+)
+
+// lockRegion is the locked byte of the file. A locked region can't be read through the other
+// handles on Windows, so it is far beyond the PID written at the start of the file.
+func lockRegion() *syscall.Overlapped {
+	return &syscall.Overlapped{OffsetHigh: 1}
+}
+
+// lockFile takes an exclusive or shared lock on the file with LockFileEx without waiting,
+// it returns false when another handle holds a conflicting lock
+func lockFile(f *os.File, exclusive bool) (bool, error) {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	r, _, err := procLockFileEx.Call(f.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(lockRegion())))
+	if r != 0 {
+		return true, nil
+	}
+	if errors.Is(err, errorLockViolation) {
+		return false, nil
+	}
+	return false, err
+}
+
+// unlockFile releases the lock taken by lockFile
+func unlockFile(f *os.File) error {
+	r, _, err := procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(lockRegion())))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// LogsKept is the number of run logs kept in ~/.xpdemon-deploy/logs
+const LogsKept = 50
+
+// getLogsDir returns the directory ~/.xpdemon-deploy/logs
+func getLogsDir() (string, error) {
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cfgDir, "logs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// CreateLog creates the log file of a run: ~/.xpdemon-deploy/logs/<id>.log.
+// The output of the commands may hold sensitive values, so the file is readable by the user only.
+// The oldest logs are removed.
+func CreateLog(id string) (*os.File, error) {
+	dir, err := getLogsDir()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, filepath.Base(id)+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	pruneLogs(dir)
+	return f, nil
+}
+
+// pruneLogs keeps the LogsKept most recent logs (the IDs sort by date)
+func pruneLogs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for len(names) > LogsKept {
+		os.Remove(filepath.Join(dir, names[0]))
+		names = names[1:]
+	}
+}
@@ -0,0 +1,37 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateLog(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var last string
+	for i := 0; i < LogsKept+3; i++ {
+		f, err := CreateLog(fmt.Sprintf("20261019-1200%02d.000", i))
+		if err != nil {
+			t.Fatalf("CreateLog: %v", err)
+		}
+		last = f.Name()
+		f.Close()
+	}
+
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("log mode = %v, want 0600", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(filepath.Dir(last))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The oldest logs are removed
+	if len(entries) != LogsKept || entries[0].Name() != "20261019-120003.000.log" {
+		t.Errorf("%d logs kept, the oldest is %s, want %d from 20261019-120003.000.log", len(entries), entries[0].Name(), LogsKept)
+	}
+}
@@ -0,0 +1,413 @@
+package lint
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xpdemon/ac-deploy/compose"
+)
+
+// Severities of the findings ("off" disables a rule)
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+	SeverityOff     = "off"
+)
+
+// Severities lists the values accepted to override the severity of a rule
+var Severities = []string{SeverityError, SeverityWarning, SeverityInfo, SeverityOff}
+
+// Rules
+const (
+	RuleSchema        = "schema"
+	RuleNoImage       = "no-image"
+	RuleLatestTag     = "latest-tag"
+	RuleNoHealthcheck = "no-healthcheck"
+	RulePrivileged    = "privileged"
+	RulePortConflict  = "port-conflict"
+	RuleDependsOn     = "depends-on"
+)
+
+// Rules lists the rules, in the order of the documentation
+var Rules = []string{RuleSchema, RuleNoImage, RuleLatestTag, RuleNoHealthcheck, RulePrivileged, RulePortConflict, RuleDependsOn}
+
+// Finding is a problem found in the compose file
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Service  string `json:"service,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Options tune the rules to the deployment
+type Options struct {
+	// Push is set when the images are pushed to a registry: built services need an image name
+	Push bool
+	// Production turns the latest tags into errors
+	Production bool
+	// Tag replaces the "latest" tags during the deployment (run-flow)
+	Tag string
+}
+
+// Known keys of the compose specification (x-* extensions are always allowed)
+var (
+	topLevelKeys = []string{"version", "name", "include", "services", "networks", "volumes", "secrets", "configs", "models"}
+	serviceKeys  = []string{
+		"annotations", "attach", "blkio_config", "build", "cap_add", "cap_drop", "cgroup", "cgroup_parent",
+		"command", "configs", "container_name", "cpu_count", "cpu_percent", "cpu_period", "cpu_quota",
+		"cpu_rt_period", "cpu_rt_runtime", "cpu_shares", "cpus", "cpuset", "credential_spec", "depends_on",
+		"deploy", "develop", "device_cgroup_rules", "devices", "dns", "dns_opt", "dns_search", "domainname",
+		"driver_opts", "entrypoint", "env_file", "environment", "expose", "extends", "external_links",
+		"extra_hosts", "gpus", "group_add", "healthcheck", "hostname", "image", "init", "ipc", "isolation",
+		"label_file", "labels", "links", "logging", "mac_address", "mem_limit", "mem_reservation",
+		"mem_swappiness", "memswap_limit", "models", "network_mode", "networks", "oom_kill_disable",
+		"oom_score_adj", "pid", "pids_limit", "platform", "ports", "post_start", "pre_stop", "privileged",
+		"profiles", "provider", "pull_policy", "read_only", "restart", "runtime", "scale", "secrets",
+		"security_opt", "shm_size", "stdin_open", "stop_grace_period", "stop_signal", "storage_opt",
+		"sysctls", "tmpfs", "tty", "ulimits", "use_api_socket", "user", "userns_mode", "uts", "volumes",
+		"volumes_from", "working_dir",
+	}
+)
+
+// Check checks a compose file against the structure of the compose specification and the rules.
+// The findings are sorted by severity, then service and rule.
+func Check(path string, opts Options) ([]Finding, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return []Finding{{Rule: RuleSchema, Severity: SeverityError, Message: "invalid YAML: " + err.Error()}}, nil
+	}
+
+	// 1) Structure: a broken structure makes the other rules meaningless
+	findings := checkStructure(raw)
+	if len(findings) > 0 {
+		return findings, nil
+	}
+	services, err := compose.ParseServices(path)
+	if err != nil {
+		return []Finding{{Rule: RuleSchema, Severity: SeverityError, Message: err.Error()}}, nil
+	}
+
+	// 2) Rules on every service
+	servicesRaw := raw["services"].(map[string]interface{})
+	for _, svc := range services {
+		svcMap, _ := servicesRaw[svc.Name].(map[string]interface{})
+		findings = append(findings, checkImage(svc, opts)...)
+		findings = append(findings, checkHealthcheck(svc.Name, svcMap)...)
+		findings = append(findings, checkPrivileged(svc.Name, svcMap)...)
+	}
+	findings = append(findings, checkPorts(services, servicesRaw)...)
+	findings = append(findings, checkDependsOn(services)...)
+	Sort(findings)
+	return findings, nil
+}
+
+// checkStructure verifies the top-level keys and the keys of the services
+func checkStructure(raw map[string]interface{}) []Finding {
+	var findings []Finding
+	for key := range raw {
+		if !slices.Contains(topLevelKeys, key) && !strings.HasPrefix(key, "x-") {
+			findings = append(findings, Finding{Rule: RuleSchema, Severity: SeverityError, Message: fmt.Sprintf("unknown top-level key '%s'", key)})
+		}
+	}
+	servicesRaw, ok := raw["services"].(map[string]interface{})
+	if !ok {
+		return append(findings, Finding{Rule: RuleSchema, Severity: SeverityError, Message: "the 'services' section is missing or is not a mapping"})
+	}
+	for name, svcVal := range servicesRaw {
+		svcMap, ok := svcVal.(map[string]interface{})
+		if !ok {
+			if svcVal != nil {
+				findings = append(findings, Finding{Rule: RuleSchema, Severity: SeverityError, Service: name, Message: "the service is not a mapping"})
+			}
+			continue
+		}
+		for key := range svcMap {
+			if !slices.Contains(serviceKeys, key) && !strings.HasPrefix(key, "x-") {
+				findings = append(findings, Finding{Rule: RuleSchema, Severity: SeverityError, Service: name, Message: fmt.Sprintf("unknown key '%s'", key)})
+			}
+		}
+	}
+	Sort(findings)
+	return findings
+}
+
+// checkImage: every service needs an image or a build, pushed images need a name,
+// and "latest" (or no tag) doesn't identify a version
+func checkImage(svc compose.Service, opts Options) []Finding {
+	if svc.Image == "" {
+		if !svc.HasBuild() {
+			return []Finding{{Rule: RuleNoImage, Severity: SeverityError, Service: svc.Name, Message: "no image and no build section"}}
+		}
+		if opts.Push {
+			return []Finding{{Rule: RuleNoImage, Severity: SeverityError, Service: svc.Name, Message: "built without an image name, it can't be pushed to the registry"}}
+		}
+		return nil
+	}
+
+	image := svc.Image
+	if strings.Contains(image, "@") {
+		return nil // pinned by digest
+	}
+	tag := ""
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		tag = image[i+1:]
+	}
+	if tag == "latest" && opts.Tag != "" {
+		return nil // replaced by the tag of the deployment
+	}
+	if tag != "" && tag != "latest" {
+		return nil
+	}
+	severity := SeverityWarning
+	if opts.Production {
+		severity = SeverityError
+	}
+	message := fmt.Sprintf("image '%s' uses the latest tag, the deployed version is unknown", image)
+	if tag == "" {
+		message = fmt.Sprintf("image '%s' has no tag (latest), the deployed version is unknown", image)
+	}
+	return []Finding{{Rule: RuleLatestTag, Severity: severity, Service: svc.Name, Message: message}}
+}
+
+// checkHealthcheck warns about the services without healthcheck: the rolling and
+// blue-green strategies can only wait for them to be running
+func checkHealthcheck(name string, svcMap map[string]interface{}) []Finding {
+	hc, ok := svcMap["healthcheck"].(map[string]interface{})
+	if ok && hc["disable"] != true {
+		return nil
+	}
+	return []Finding{{Rule: RuleNoHealthcheck, Severity: SeverityWarning, Service: name,
+		Message: "no healthcheck (unless the image defines one), a started container is considered ready"}}
+}
+
+// checkPrivileged warns about the containers with extended privileges on the host
+func checkPrivileged(name string, svcMap map[string]interface{}) []Finding {
+	var findings []Finding
+	add := func(message string) {
+		findings = append(findings, Finding{Rule: RulePrivileged, Severity: SeverityWarning, Service: name, Message: message})
+	}
+	if svcMap["privileged"] == true {
+		add("privileged container")
+	}
+	caps, _ := svcMap["cap_add"].([]interface{})
+	for _, c := range caps {
+		if c == "ALL" || c == "SYS_ADMIN" || c == "CAP_SYS_ADMIN" {
+			add(fmt.Sprintf("capability %v added", c))
+		}
+	}
+	for _, key := range []string{"network_mode", "pid", "ipc", "userns_mode"} {
+		if svcMap[key] == "host" {
+			add(fmt.Sprintf("%s: host shares a namespace of the host", key))
+		}
+	}
+	for _, v := range stringItems(svcMap["volumes"]) {
+		if strings.HasPrefix(v, "/var/run/docker.sock:") || strings.HasPrefix(v, "/run/docker.sock:") {
+			add("the Docker socket is mounted (root access to the host)")
+		}
+	}
+	return findings
+}
+
+// hostPort is a published port of a service
+type hostPort struct {
+	service string
+	ip      string
+	port    int
+	proto   string
+}
+
+// checkPorts finds the host ports published twice, and the fixed ports of scaled services
+func checkPorts(services []compose.Service, servicesRaw map[string]interface{}) []Finding {
+	var findings []Finding
+	var ports []hostPort
+	for _, svc := range services {
+		svcMap, _ := servicesRaw[svc.Name].(map[string]interface{})
+		items, _ := svcMap["ports"].([]interface{})
+		for _, item := range items {
+			published, err := publishedPorts(svc.Name, item)
+			if err != nil {
+				findings = append(findings, Finding{Rule: RuleSchema, Severity: SeverityError, Service: svc.Name, Message: err.Error()})
+				continue
+			}
+			if svc.Replicas > 1 && len(published) == 1 {
+				findings = append(findings, Finding{Rule: RulePortConflict, Severity: SeverityError, Service: svc.Name,
+					Message: fmt.Sprintf("%d replicas can't publish the same host port %d", svc.Replicas, published[0].port)})
+			}
+			ports = append(ports, published...)
+		}
+	}
+
+	for i, a := range ports {
+		for _, b := range ports[i+1:] {
+			if a.port != b.port || a.proto != b.proto || (a.ip != b.ip && a.ip != "" && b.ip != "") {
+				continue
+			}
+			message := fmt.Sprintf("host port %d/%s is also published by '%s'", a.port, a.proto, a.service)
+			if a.service == b.service {
+				message = fmt.Sprintf("host port %d/%s is published twice", a.port, a.proto)
+			}
+			findings = append(findings, Finding{Rule: RulePortConflict, Severity: SeverityError, Service: b.service, Message: message})
+		}
+	}
+	return findings
+}
+
+// publishedPorts parses a ports entry: "[ip:]host[-end]:container[/proto]" or the long syntax.
+// The entries without host port (random port) are ignored.
+func publishedPorts(service string, item interface{}) ([]hostPort, error) {
+	var ip, published, proto string
+	switch p := item.(type) {
+	case map[string]interface{}:
+		published = fmt.Sprint(valueOr(p["published"], ""))
+		ip = fmt.Sprint(valueOr(p["host_ip"], ""))
+		proto = fmt.Sprint(valueOr(p["protocol"], ""))
+	case string, int:
+		spec := fmt.Sprint(p)
+		spec, proto, _ = strings.Cut(spec, "/")
+		parts := strings.Split(spec, ":")
+		switch len(parts) {
+		case 1:
+			return nil, nil
+		case 2:
+			published = parts[0]
+		default:
+			ip, published = strings.Join(parts[:len(parts)-2], ":"), parts[len(parts)-2]
+		}
+	default:
+		return nil, fmt.Errorf("invalid ports entry %v", item)
+	}
+	if published == "" {
+		return nil, nil
+	}
+	if proto == "" {
+		proto = "tcp"
+	}
+	if ip == "0.0.0.0" || ip == "::" || ip == "[::]" {
+		ip = ""
+	}
+
+	start, end, isRange := strings.Cut(published, "-")
+	first, err := strconv.Atoi(start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host port '%s'", published)
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(end); err != nil || last < first {
+			return nil, fmt.Errorf("invalid host port range '%s'", published)
+		}
+	}
+	var ports []hostPort
+	for port := first; port <= last; port++ {
+		ports = append(ports, hostPort{service: service, ip: ip, port: port, proto: proto})
+	}
+	return ports, nil
+}
+
+// checkDependsOn finds the dependencies on unknown services and the cycles
+func checkDependsOn(services []compose.Service) []Finding {
+	var findings []Finding
+	names := make([]string, 0, len(services))
+	for _, svc := range services {
+		names = append(names, svc.Name)
+	}
+	for _, svc := range services {
+		for _, dep := range svc.DependsOn {
+			if !slices.Contains(names, dep) {
+				findings = append(findings, Finding{Rule: RuleDependsOn, Severity: SeverityError, Service: svc.Name,
+					Message: fmt.Sprintf("depends on the unknown service '%s'", dep)})
+			}
+		}
+	}
+	if len(findings) > 0 {
+		return findings
+	}
+	if _, err := compose.DeployOrder(services, names); err != nil {
+		findings = append(findings, Finding{Rule: RuleDependsOn, Severity: SeverityError, Message: err.Error()})
+	}
+	return findings
+}
+
+// Apply overrides the severity of the rules (rule => severity) and removes the disabled ones
+func Apply(findings []Finding, severities map[string]string) []Finding {
+	var result []Finding
+	for _, f := range findings {
+		if s, ok := severities[f.Rule]; ok {
+			f.Severity = s
+		}
+		if f.Severity != SeverityOff {
+			result = append(result, f)
+		}
+	}
+	Sort(result)
+	return result
+}
+
+// Validate checks the severity overrides of a configuration
+func Validate(severities map[string]string) error {
+	for rule, severity := range severities {
+		if !slices.Contains(Rules, rule) {
+			return fmt.Errorf("unknown lint rule '%s' (expected one of %s)", rule, strings.Join(Rules, ", "))
+		}
+		if !slices.Contains(Severities, severity) {
+			return fmt.Errorf("invalid severity '%s' for rule '%s' (expected one of %s)", severity, rule, strings.Join(Severities, ", "))
+		}
+	}
+	return nil
+}
+
+// Count returns the number of findings of a severity
+func Count(findings []Finding, severity string) int {
+	n := 0
+	for _, f := range findings {
+		if f.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Sort orders the findings by severity (errors first), service and rule
+func Sort(findings []Finding) {
+	rank := map[string]int{SeverityError: 0, SeverityWarning: 1, SeverityInfo: 2}
+	sort.SliceStable(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if rank[a.Severity] != rank[b.Severity] {
+			return rank[a.Severity] < rank[b.Severity]
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Rule < b.Rule
+	})
+}
+
+// stringItems returns the string items of a YAML list
+func stringItems(v interface{}) []string {
+	items, _ := v.([]interface{})
+	var result []string
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func valueOr(v, def interface{}) interface{} {
+	if v == nil {
+		return def
+	}
+	return v
+}
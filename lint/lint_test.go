@@ -0,0 +1,188 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// healthy is appended to the services that only test another rule
+const healthy = `
+    healthcheck:
+      test: ["CMD", "true"]`
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		compose string
+		opts    Options
+		want    []string // "rule/severity/service"
+	}{
+		{
+			name:    "valid",
+			compose: "services:\n  web:\n    image: nginx:1.27" + healthy,
+			want:    nil,
+		},
+		{
+			name:    "invalid YAML",
+			compose: "services:\n  web: [",
+			want:    []string{"schema/error/"},
+		},
+		{
+			name:    "unknown keys",
+			compose: "servics: {}\nservices:\n  web:\n    image: nginx:1.27\n    imagee: x\n    x-extra: 1",
+			want:    []string{"schema/error/", "schema/error/web"},
+		},
+		{
+			name:    "no image and no build",
+			compose: "services:\n  web:\n    restart: always" + healthy,
+			want:    []string{"no-image/error/web"},
+		},
+		{
+			name:    "built without image name, not pushed",
+			compose: "services:\n  api:\n    build: ." + healthy,
+			want:    nil,
+		},
+		{
+			name:    "built without image name, pushed",
+			compose: "services:\n  api:\n    build: ." + healthy,
+			opts:    Options{Push: true},
+			want:    []string{"no-image/error/api"},
+		},
+		{
+			name:    "latest and missing tags",
+			compose: "services:\n  a:\n    image: nginx" + healthy + "\n  b:\n    image: reg.io:5000/b:latest" + healthy + "\n  c:\n    image: reg.io:5000/c" + healthy,
+			want:    []string{"latest-tag/warning/a", "latest-tag/warning/b", "latest-tag/warning/c"},
+		},
+		{
+			name:    "latest tag in production",
+			compose: "services:\n  a:\n    image: nginx:latest" + healthy,
+			opts:    Options{Production: true},
+			want:    []string{"latest-tag/error/a"},
+		},
+		{
+			name:    "latest tag replaced by the deployment",
+			compose: "services:\n  a:\n    image: app:latest" + healthy + "\n  b:\n    image: nginx@sha256:0123" + healthy,
+			opts:    Options{Tag: "1.2.0", Production: true},
+			want:    nil,
+		},
+		{
+			name:    "healthcheck missing or disabled",
+			compose: "services:\n  a:\n    image: nginx:1\n  b:\n    image: nginx:1\n    healthcheck:\n      disable: true",
+			want:    []string{"no-healthcheck/warning/a", "no-healthcheck/warning/b"},
+		},
+		{
+			name: "privileges",
+			compose: "services:\n  a:\n    image: nginx:1\n    privileged: true\n    cap_add: [NET_ADMIN, SYS_ADMIN]\n    network_mode: host\n" +
+				"    volumes: [/var/run/docker.sock:/var/run/docker.sock]" + healthy,
+			want: []string{"privileged/warning/a", "privileged/warning/a", "privileged/warning/a", "privileged/warning/a"},
+		},
+		{
+			name: "same host port",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"8080:80\"]" + healthy +
+				"\n  b:\n    image: nginx:1\n    ports:\n      - published: 8080\n        target: 81" + healthy,
+			want: []string{"port-conflict/error/b"},
+		},
+		{
+			name: "same port, other IP or protocol",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"127.0.0.1:8080:80\", \"53:53/udp\"]" + healthy +
+				"\n  b:\n    image: nginx:1\n    ports: [\"10.0.0.1:8080:80\", \"53:53\", \"80\"]" + healthy,
+			want: nil,
+		},
+		{
+			name:    "port ranges overlap",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"9000-9002:9000-9002\", \"9002:1\"]" + healthy,
+			want:    []string{"port-conflict/error/a"},
+		},
+		{
+			name:    "replicas with a fixed port",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"8080:80\"]\n    deploy:\n      replicas: 2" + healthy,
+			want:    []string{"port-conflict/error/a"},
+		},
+		{
+			name:    "replicas with a port range",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"8080-8081:80\"]\n    deploy:\n      replicas: 2" + healthy,
+			want:    nil,
+		},
+		{
+			name:    "invalid host port",
+			compose: "services:\n  a:\n    image: nginx:1\n    ports: [\"80a:80\"]" + healthy,
+			want:    []string{"schema/error/a"},
+		},
+		{
+			name:    "unknown dependency",
+			compose: "services:\n  a:\n    image: nginx:1\n    depends_on: [db]" + healthy,
+			want:    []string{"depends-on/error/a"},
+		},
+		{
+			name: "dependency cycle",
+			compose: "services:\n  a:\n    image: nginx:1\n    depends_on: [b]" + healthy +
+				"\n  b:\n    image: nginx:1\n    depends_on:\n      a:\n        condition: service_healthy" + healthy,
+			want: []string{"depends-on/error/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "docker-compose.yml")
+			if err := os.WriteFile(path, []byte(tt.compose+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			findings, err := Check(path, tt.opts)
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			var got []string
+			for _, f := range findings {
+				got = append(got, f.Rule+"/"+f.Severity+"/"+f.Service)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("findings = %v, want %v", got, tt.want)
+				for _, f := range findings {
+					t.Logf("  %+v", f)
+				}
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	findings := []Finding{
+		{Rule: RuleNoHealthcheck, Severity: SeverityWarning, Service: "b"},
+		{Rule: RuleLatestTag, Severity: SeverityWarning, Service: "a"},
+		{Rule: RulePrivileged, Severity: SeverityWarning, Service: "a"},
+	}
+	got := Apply(findings, map[string]string{RuleNoHealthcheck: SeverityOff, RulePrivileged: SeverityError})
+	want := []Finding{
+		{Rule: RulePrivileged, Severity: SeverityError, Service: "a"},
+		{Rule: RuleLatestTag, Severity: SeverityWarning, Service: "a"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+	if Count(got, SeverityError) != 1 || Count(got, SeverityWarning) != 1 {
+		t.Errorf("Count is wrong")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		severities map[string]string
+		wantErr    string
+	}{
+		{map[string]string{RuleLatestTag: SeverityError, RuleNoHealthcheck: SeverityOff}, ""},
+		{map[string]string{"no-such-rule": SeverityError}, "unknown lint rule"},
+		{map[string]string{RuleLatestTag: "fatal"}, "invalid severity"},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.severities)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%v) = %v", tt.severities, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%v) = %v, want %q", tt.severities, err, tt.wantErr)
+		}
+	}
+}
@@ -33,7 +33,15 @@ func main() {
 		Use:   "xpdemon-deploy",
 		Short: "Docker Deployment CLI (advanced example)",
 		Long:  "An example Go CLI with Cobra to build and deploy Docker Compose images across different contexts and registries.",
+		// Errors are printed once below, with an exit code matching their kind
+		SilenceUsage:  true,
+		SilenceErrors: true,
 	}
+	rootCmd.SetFlagErrorFunc(cmd.FlagError)
+
+	// Global flags (--output text|json, --plain)
+	cmd.AddOutputFlag(rootCmd)
+	cmd.AddPlainFlag(rootCmd)
 
 	// Add sub-commands
 	rootCmd.AddCommand(
@@ -42,11 +50,22 @@ func main() {
 		cmd.AddRegistryCmd,
 		cmd.LoginRegistryCmd,
 		cmd.RunFlowCmd,
+		cmd.TestWebhookCmd,
+		cmd.SwitchBackCmd,
+		cmd.SecretsCmd,
+		cmd.LintCmd,
+		cmd.LogsCmd,
+		cmd.PsCmd,
+		cmd.ExecCmd,
+		cmd.RestartCmd,
+		cmd.DownCmd,
+		cmd.DriftCmd,
+		cmd.WatchCmd,
 	)
 
-	// Execute
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// Execute (SIGINT/SIGTERM stop the current step)
+	if err := rootCmd.ExecuteContext(cmd.InterruptContext()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
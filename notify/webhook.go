@@ -0,0 +1,277 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Notification events
+const (
+	EventStart   = "start"
+	EventSuccess = "success"
+	EventFailure = "failure"
+	// EventTest is sent by the test-webhook command, whatever the events filter
+	EventTest = "test"
+)
+
+// Payload formats
+const (
+	FormatGeneric = "generic"
+	FormatSlack   = "slack"
+	FormatDiscord = "discord"
+)
+
+// Formats lists the supported payload formats
+var Formats = []string{FormatGeneric, FormatSlack, FormatDiscord}
+
+// Events lists the events a webhook can filter on
+var Events = []string{EventStart, EventSuccess, EventFailure}
+
+// requestTimeout bounds every webhook request
+var requestTimeout = 10 * time.Second
+
+// Notification is the summary of a run sent to the webhooks
+type Notification struct {
+	Event         string            `json:"event"`
+	Time          time.Time         `json:"time"`
+	RunID         string            `json:"run_id"`
+	Status        string            `json:"status,omitempty"`
+	Profile       string            `json:"profile,omitempty"`
+	BuildContext  string            `json:"build_context,omitempty"`
+	DeployContext string            `json:"deploy_context,omitempty"`
+	Registry      string            `json:"registry,omitempty"`
+	ComposeFile   string            `json:"compose_file,omitempty"`
+	Services      []string          `json:"services,omitempty"`
+	Tag           string            `json:"tag,omitempty"`
+	Images        map[string]string `json:"images,omitempty"`
+	FailedStep    string            `json:"failed_step,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	// Duration is the duration of the run in seconds (success and failure only)
+	Duration float64 `json:"duration_s,omitempty"`
+}
+
+// Validate checks the URL, format, events and template of a webhook
+func Validate(hook config.Webhook) error {
+	if !strings.HasPrefix(hook.URL, "http://") && !strings.HasPrefix(hook.URL, "https://") {
+		return fmt.Errorf("webhook URL '%s' must start with http:// or https://", hook.URL)
+	}
+	if hook.Format != "" && !slices.Contains(Formats, hook.Format) {
+		return fmt.Errorf("unknown webhook format '%s' (expected one of %s)", hook.Format, strings.Join(Formats, ", "))
+	}
+	for _, e := range hook.Events {
+		if !slices.Contains(Events, e) {
+			return fmt.Errorf("unknown webhook event '%s' (expected one of %s)", e, strings.Join(Events, ", "))
+		}
+	}
+	if hook.Template != "" {
+		if _, err := parseTemplate(hook.Template); err != nil {
+			return fmt.Errorf("invalid webhook template: %w", err)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the webhook subscribed to the event
+func Wants(hook config.Webhook, event string) bool {
+	return event == EventTest || len(hook.Events) == 0 || slices.Contains(hook.Events, event)
+}
+
+// Send posts the notification to the webhook.
+// A response other than 2xx is an error.
+func Send(ctx context.Context, hook config.Webhook, n Notification) error {
+	body, err := Payload(hook, n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "xpdemon-deploy")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if len(bytes.TrimSpace(msg)) == 0 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Payload returns the JSON body of the notification in the format of the webhook
+func Payload(hook config.Webhook, n Notification) ([]byte, error) {
+	if hook.Template != "" {
+		return renderTemplate(hook.Template, n)
+	}
+	switch hook.Format {
+	case "", FormatGeneric:
+		return json.Marshal(n)
+	case FormatSlack:
+		return json.Marshal(slackPayload(n))
+	case FormatDiscord:
+		return json.Marshal(discordPayload(n))
+	}
+	return nil, fmt.Errorf("unknown webhook format '%s'", hook.Format)
+}
+
+// parseTemplate parses a body template.
+// The "json" function encodes a value (e.g., {{json .Error}}), "join" joins a list.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+		"join": strings.Join,
+	}).Parse(text)
+}
+
+// renderTemplate executes a body template, which must produce valid JSON
+func renderTemplate(text string, n Notification) ([]byte, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, n); err != nil {
+		return nil, fmt.Errorf("webhook template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("webhook template: the result is not valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Title returns a one-line summary of the notification
+func Title(n Notification) string {
+	target := n.DeployContext
+	if n.Profile != "" {
+		target = n.Profile + " → " + n.DeployContext
+	}
+	switch n.Event {
+	case EventStart:
+		return fmt.Sprintf("Deployment %s started (%s)", n.RunID, target)
+	case EventSuccess:
+		return fmt.Sprintf("Deployment %s succeeded (%s) in %s", n.RunID, target, formatDuration(n.Duration))
+	case EventFailure:
+		if n.Status != "" && n.Status != "failed" {
+			return fmt.Sprintf("Deployment %s %s at step '%s' (%s)", n.RunID, n.Status, n.FailedStep, target)
+		}
+		return fmt.Sprintf("Deployment %s failed at step '%s' (%s)", n.RunID, n.FailedStep, target)
+	}
+	return fmt.Sprintf("Test notification from xpdemon-deploy (%s)", n.RunID)
+}
+
+// fields returns the details of the notification as ordered name/value pairs
+func fields(n Notification) [][2]string {
+	var result [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			result = append(result, [2]string{name, value})
+		}
+	}
+	add("Services", strings.Join(n.Services, ", "))
+	add("Tag", n.Tag)
+	add("Build context", n.BuildContext)
+	add("Registry", n.Registry)
+	if len(n.Images) > 0 {
+		names := make([]string, 0, len(n.Images))
+		for svc := range n.Images {
+			names = append(names, svc)
+		}
+		sort.Strings(names)
+		lines := make([]string, 0, len(names))
+		for _, svc := range names {
+			lines = append(lines, svc+": "+n.Images[svc])
+		}
+		add("Images", strings.Join(lines, "\n"))
+	}
+	add("Error", n.Error)
+	return result
+}
+
+// color returns the color of the event (green, red, blue)
+func color(n Notification) int {
+	switch n.Event {
+	case EventSuccess:
+		return 0x2eb67d
+	case EventFailure:
+		return 0xe01e5a
+	}
+	return 0x36c5f0
+}
+
+// slackPayload builds a Slack incoming-webhook message (text + attachment fields)
+func slackPayload(n Notification) map[string]interface{} {
+	var slackFields []map[string]interface{}
+	for _, f := range fields(n) {
+		slackFields = append(slackFields, map[string]interface{}{
+			"title": f[0],
+			"value": f[1],
+			"short": f[0] != "Images" && f[0] != "Error",
+		})
+	}
+	return map[string]interface{}{
+		"text": Title(n),
+		"attachments": []map[string]interface{}{{
+			"color":  fmt.Sprintf("#%06x", color(n)),
+			"fields": slackFields,
+			"ts":     n.Time.Unix(),
+		}},
+	}
+}
+
+// discordPayload builds a Discord webhook message (content + embed fields)
+func discordPayload(n Notification) map[string]interface{} {
+	var discordFields []map[string]interface{}
+	for _, f := range fields(n) {
+		value := f[1]
+		if runes := []rune(value); len(runes) > 1024 { // Discord limit of a field value, in characters
+			value = string(runes[:1021]) + "..."
+		}
+		discordFields = append(discordFields, map[string]interface{}{
+			"name":   f[0],
+			"value":  value,
+			"inline": f[0] != "Images" && f[0] != "Error",
+		})
+	}
+	return map[string]interface{}{
+		"content": Title(n),
+		"embeds": []map[string]interface{}{{
+			"title":     "Run " + n.RunID,
+			"color":     color(n),
+			"fields":    discordFields,
+			"timestamp": n.Time.Format(time.RFC3339),
+		}},
+	}
+}
+
+// formatDuration prints a duration in seconds as 1m23s
+func formatDuration(seconds float64) string {
+	return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+}
@@ -0,0 +1,212 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+func testNotification() Notification {
+	return Notification{
+		Event:         EventFailure,
+		Time:          time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC),
+		RunID:         "20261019-120000.000",
+		Status:        "failed",
+		Profile:       "production",
+		DeployContext: "prod",
+		Services:      []string{"api", "web"},
+		Tag:           "1.0",
+		Images:        map[string]string{"web": "reg.io/u/web:1.0", "api": "reg.io/u/api:1.0"},
+		FailedStep:    "deploy",
+		Error:         "docker compose up failed",
+		Duration:      83,
+	}
+}
+
+// receiver records the requests of a test webhook server
+type receiver struct {
+	headers http.Header
+	body    map[string]interface{}
+}
+
+func newServer(t *testing.T, status int, rec *receiver) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		data, _ := io.ReadAll(r.Body)
+		rec.headers = r.Header.Clone()
+		if err := json.Unmarshal(data, &rec.body); err != nil {
+			t.Errorf("invalid JSON body %q: %v", data, err)
+		}
+		w.WriteHeader(status)
+		if status >= 300 {
+			io.WriteString(w, "invalid token\n")
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSendFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		check  func(t *testing.T, body map[string]interface{})
+	}{
+		{FormatGeneric, func(t *testing.T, body map[string]interface{}) {
+			if body["event"] != EventFailure || body["run_id"] != "20261019-120000.000" || body["failed_step"] != "deploy" {
+				t.Errorf("generic body = %v", body)
+			}
+			if body["duration_s"] != 83.0 {
+				t.Errorf("duration_s = %v, want 83", body["duration_s"])
+			}
+		}},
+		{FormatSlack, func(t *testing.T, body map[string]interface{}) {
+			if body["text"] != "Deployment 20261019-120000.000 failed at step 'deploy' (production → prod)" {
+				t.Errorf("text = %v", body["text"])
+			}
+			attachment := body["attachments"].([]interface{})[0].(map[string]interface{})
+			if attachment["color"] != "#e01e5a" {
+				t.Errorf("color = %v, want #e01e5a", attachment["color"])
+			}
+			fields := attachment["fields"].([]interface{})
+			images := fields[2].(map[string]interface{})
+			if images["title"] != "Images" || images["value"] != "api: reg.io/u/api:1.0\nweb: reg.io/u/web:1.0" || images["short"] != false {
+				t.Errorf("images field = %v", images)
+			}
+		}},
+		{FormatDiscord, func(t *testing.T, body map[string]interface{}) {
+			if !strings.HasPrefix(body["content"].(string), "Deployment 20261019-120000.000 failed") {
+				t.Errorf("content = %v", body["content"])
+			}
+			embed := body["embeds"].([]interface{})[0].(map[string]interface{})
+			if embed["title"] != "Run 20261019-120000.000" || embed["color"] != float64(0xe01e5a) || embed["timestamp"] != "2026-10-19T12:00:00Z" {
+				t.Errorf("embed = %v", embed)
+			}
+			last := embed["fields"].([]interface{})[3].(map[string]interface{})
+			if last["name"] != "Error" || last["inline"] != false {
+				t.Errorf("error field = %v", last)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			rec := &receiver{}
+			srv := newServer(t, http.StatusOK, rec)
+			hook := config.Webhook{URL: srv.URL, Format: tt.format, Headers: map[string]string{"Authorization": "Bearer x"}}
+			if err := Send(context.Background(), hook, testNotification()); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			if got := rec.headers.Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q", got)
+			}
+			if got := rec.headers.Get("Authorization"); got != "Bearer x" {
+				t.Errorf("Authorization = %q", got)
+			}
+			tt.check(t, rec.body)
+		})
+	}
+}
+
+func TestSendTemplate(t *testing.T) {
+	rec := &receiver{}
+	srv := newServer(t, http.StatusNoContent, rec)
+	hook := config.Webhook{URL: srv.URL, Template: `{"msg": {{json .Error}}, "services": "{{join .Services ","}}"}`}
+	if err := Send(context.Background(), hook, testNotification()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if rec.body["msg"] != "docker compose up failed" || rec.body["services"] != "api,web" {
+		t.Errorf("body = %v", rec.body)
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	rec := &receiver{}
+	srv := newServer(t, http.StatusForbidden, rec)
+	err := Send(context.Background(), config.Webhook{URL: srv.URL}, testNotification())
+	if err == nil || err.Error() != "webhook returned 403 Forbidden: invalid token" {
+		t.Fatalf("Send error = %v, want the status and the body of the response", err)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	saved := requestTimeout
+	requestTimeout = 50 * time.Millisecond
+	defer func() { requestTimeout = saved }()
+
+	start := time.Now()
+	err := Send(context.Background(), config.Webhook{URL: srv.URL}, testNotification())
+	if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Fatalf("Send error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Send returned after %s", elapsed)
+	}
+}
+
+func TestDiscordPayloadTruncation(t *testing.T) {
+	tests := []struct {
+		name  string
+		error string
+		want  int // characters of the field value
+	}{
+		{"short", "failed", 6},
+		{"limit", strings.Repeat("é", 1024), 1024},
+		{"multi-byte", strings.Repeat("é", 2000), 1024},
+		{"ascii", strings.Repeat("a", 2000), 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := discordPayload(Notification{Event: EventFailure, Error: tt.error})
+			fields := payload["embeds"].([]map[string]interface{})[0]["fields"].([]map[string]interface{})
+			value := fields[len(fields)-1]["value"].(string)
+			if !utf8.ValidString(value) {
+				t.Fatalf("value is not valid UTF-8")
+			}
+			if got := utf8.RuneCountInString(value); got != tt.want {
+				t.Errorf("value has %d characters, want %d", got, tt.want)
+			}
+			if len(tt.error) > len(value) && !strings.HasSuffix(value, "...") {
+				t.Errorf("truncated value does not end with ...")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		hook    config.Webhook
+		wantErr string
+	}{
+		{config.Webhook{URL: "https://hooks.slack.com/x", Format: FormatSlack}, ""},
+		{config.Webhook{URL: "ftp://x"}, "must start with http:// or https://"},
+		{config.Webhook{URL: "http://x", Format: "teams"}, "unknown webhook format"},
+		{config.Webhook{URL: "http://x", Events: []string{"deploy"}}, "unknown webhook event"},
+		{config.Webhook{URL: "http://x", Template: "{{.Nope"}, "invalid webhook template"},
+	}
+	for _, tt := range tests {
+		err := Validate(tt.hook)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%+v) = %v", tt.hook, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%+v) = %v, want %q", tt.hook, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/xpdemon/ac-deploy/config"
+)
+
+// Encryption parameters of the new stores (the stored ones are read from the file)
+const (
+	formatVersion = 1
+	kdfName       = "pbkdf2-sha256"
+	kdfIterations = 600000
+	saltSize      = 16
+	keySize       = 32 // AES-256
+)
+
+// ErrWrongPassphrase is returned when a store can't be decrypted
+var ErrWrongPassphrase = errors.New("wrong passphrase (or corrupted secrets file)")
+
+// scopeRegexp is the format of a scope (profile name), used as file name
+var scopeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// envelope is the content of a secrets file: the KDF parameters and the encrypted values
+type envelope struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
+}
+
+// Path returns the secrets file of a scope: ~/.xpdemon-deploy/secrets/<scope>.json
+func Path(scope string) (string, error) {
+	if !scopeRegexp.MatchString(scope) {
+		return "", fmt.Errorf("invalid secrets scope '%s'", scope)
+	}
+	cfgDir, err := config.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cfgDir, "secrets")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, scope+".json"), nil
+}
+
+// Exists reports whether a secrets file exists for the scope
+func Exists(scope string) bool {
+	path, err := Path(scope)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// Load decrypts the values of a scope (empty when there is no secrets file yet)
+func Load(scope, passphrase string) (map[string]string, error) {
+	path, err := Path(scope)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+	if env.Version != formatVersion || env.KDF != kdfName || env.Iterations <= 0 {
+		return nil, fmt.Errorf("unsupported secrets file %s (version %d, kdf %s)", path, env.Version, env.KDF)
+	}
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid secrets file %s: bad nonce", path)
+	}
+	plain, err := aead.Open(nil, env.Nonce, env.Data, additionalData(scope, env))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	values := make(map[string]string)
+	if err := json.Unmarshal(plain, &values); err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Save encrypts the values of a scope with a new salt and nonce.
+// The file is readable by the user only and replaced atomically.
+func Save(scope, passphrase string, values map[string]string) error {
+	path, err := Path(scope)
+	if err != nil {
+		return err
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	env := envelope{Version: formatVersion, KDF: kdfName, Iterations: kdfIterations, Salt: make([]byte, saltSize)}
+	if _, err := rand.Read(env.Salt); err != nil {
+		return err
+	}
+	aead, err := newAEAD(passphrase, env.Salt, env.Iterations)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return err
+	}
+	env.Data = aead.Seal(nil, env.Nonce, plain, additionalData(scope, env))
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the secrets file of a scope
+func Remove(scope string) error {
+	path, err := Path(scope)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// newAEAD returns the AES-256-GCM cipher of the passphrase
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	block, err := aes.NewCipher(pbkdf2SHA256([]byte(passphrase), salt, iterations, keySize))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// additionalData binds the ciphertext to its scope and KDF parameters:
+// a file copied to another scope or with altered parameters doesn't decrypt
+func additionalData(scope string, env envelope) []byte {
+	return []byte(fmt.Sprintf("xpdemon-deploy/secrets/v%d/%s/%s/%d/%x", env.Version, scope, env.KDF, env.Iterations, env.Salt))
+}
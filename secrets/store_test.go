@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914, section 11
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64))
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got != want {
+		t.Errorf("pbkdf2SHA256 = %s, want %s", got, want)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	values := map[string]string{"DB_PASSWORD": "s3cr3t", "MULTILINE": "a\nb\n", "SPACES": "  x  "}
+	if err := Save("production", "correct horse ", values); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if !Exists("production") || Exists("staging") {
+		t.Errorf("Exists is wrong after Save")
+	}
+	path, _ := Path("production")
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("secrets file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	got, err := Load("production", "correct horse ")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(got) != len(values) {
+		t.Fatalf("Load = %v, want %v", got, values)
+	}
+	for k, v := range values {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// A missing store is empty
+	if got, err := Load("staging", "any"); err != nil || len(got) != 0 {
+		t.Errorf("Load of a missing store = %v, %v", got, err)
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := Save("production", "correct horse ", map[string]string{"K": "v"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	// The passphrase is used as typed: the trailing space matters
+	for _, passphrase := range []string{"wrong", "correct horse", "Correct horse "} {
+		if _, err := Load("production", passphrase); !errors.Is(err, ErrWrongPassphrase) {
+			t.Errorf("Load(%q) = %v, want ErrWrongPassphrase", passphrase, err)
+		}
+	}
+	if _, err := Load("production", ""); err == nil {
+		t.Errorf("Load with an empty passphrase succeeded")
+	}
+}
+
+func TestTamper(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(env *envelope)
+		wantErr error // nil: any error
+	}{
+		{"data", func(env *envelope) { env.Data[0] ^= 1 }, ErrWrongPassphrase},
+		{"tag", func(env *envelope) { env.Data[len(env.Data)-1] ^= 1 }, ErrWrongPassphrase},
+		{"nonce", func(env *envelope) { env.Nonce[0] ^= 1 }, ErrWrongPassphrase},
+		{"salt", func(env *envelope) { env.Salt[0] ^= 1 }, ErrWrongPassphrase},
+		{"iterations", func(env *envelope) { env.Iterations = 1 }, ErrWrongPassphrase},
+		{"truncated nonce", func(env *envelope) { env.Nonce = env.Nonce[:4] }, nil},
+		{"version", func(env *envelope) { env.Version = 2 }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			if err := Save("production", "pass", map[string]string{"K": "v"}); err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			path, _ := Path("production")
+			data, _ := os.ReadFile(path)
+			var env envelope
+			if err := json.Unmarshal(data, &env); err != nil {
+				t.Fatal(err)
+			}
+			tt.tamper(&env)
+			data, _ = json.Marshal(env)
+			if err := os.WriteFile(path, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load("production", "pass")
+			if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("Load of a tampered store = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCopiedToAnotherScope(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := Save("staging", "pass", map[string]string{"K": "v"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	from, _ := Path("staging")
+	to, _ := Path("production")
+	data, _ := os.ReadFile(from)
+	if err := os.WriteFile(to, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("production", "pass"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("Load of a copied store = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestInvalidScope(t *testing.T) {
+	for _, scope := range []string{"", "../x", ".hidden", "a/b"} {
+		if _, err := Path(scope); err == nil {
+			t.Errorf("Path(%q) succeeded", scope)
+		}
+	}
+}